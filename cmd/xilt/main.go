@@ -1,16 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
-
+	"fmt"
 	"log"
 	"math"
 	"os"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
+	"go.vxn.dev/xilt/internal/botclass"
 	"go.vxn.dev/xilt/internal/config"
 	"go.vxn.dev/xilt/internal/database"
+	"go.vxn.dev/xilt/internal/geoip"
 	"go.vxn.dev/xilt/internal/parser"
 	"go.vxn.dev/xilt/internal/reader"
 	"go.vxn.dev/xilt/pkg/logger"
@@ -20,13 +25,85 @@ const (
 	reservedRoutines = 2
 )
 
+// version is set at build time via -ldflags
+var version string
+
+// command is a subcommand working with a DB created by xilt.
+type command struct {
+	name        string
+	description string
+	run         func(args []string)
+}
+
+// commands lists the subcommands of xilt. If no command is given, logs are parsed and stored in the DB.
+var commands = []command{
+	{name: "search", description: "Runs a full-text search query against a DB created with the -fts flag.", run: runSearch},
+	{name: "query", description: "Lists the logs whose IPs belong to a CIDR range.", run: runQuery},
+	{name: "sessions", description: "Groups the stored logs into visitor sessions by client IP and user agent.", run: runSessions},
+	{name: "prune", description: "Deletes logs older than a given age or beyond a maximum row count.", run: runPrune},
+	{name: "merge", description: "Merges DBs created on multiple nodes into a single one.", run: runMerge},
+}
+
+// usage prints the usage of xilt including the available commands.
+func usage() {
+	w := flag.CommandLine.Output()
+
+	fmt.Fprintln(w, "Usage: xilt [flags] [logFilePath] [dbFilePath]")
+	fmt.Fprintln(w, "       xilt <command> [flags] [args]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.description)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	flag.PrintDefaults()
+}
+
 func getRoutineCount(cfg *config.Config) int {
 	// Max number of routines is equal to the maximum memory usage limit / the average size of a batch, taking into account the configured average log size and  batch size
 	// reservedRoutines are subtracted because one write routine is running concurrently and at the same time another batch is being put together by reading from the log file, which also has to be taken into account
 	return int(math.Max(1, math.Floor(float64(cfg.MaxMemoryUsageMB)/(cfg.AverageLogSizeMB*float64(cfg.BatchSize)))-reservedRoutines))
 }
 
+// getVersion returns the version of xilt. The version is set at build time (see Makefile) or taken from the module version if installed via go install.
+func getVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// configSnapshot returns the config of an ingestion run encoded in JSON, including the regex used to parse logs, so that it can be recorded in the DB.
+func configSnapshot(cfg *config.Config, regex string) string {
+	snapshot := *cfg
+	snapshot.Regex = regex
+
+	var b strings.Builder
+
+	// HTML escaping would make the recorded regex hard to read
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(snapshot); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(b.String())
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		for _, c := range commands {
+			if c.name == os.Args[1] {
+				c.run(os.Args[2:])
+				return
+			}
+		}
+	}
+
+	flag.Usage = usage
+
 	// Start timer
 	start := time.Now()
 
@@ -53,22 +130,88 @@ func main() {
 	}()
 
 	// Logs are distributed to parsing routines in batches via this channel
-	batchChannel := make(chan []string)
+	batchChannel := make(chan parser.RawBatch)
 	// Parsing routines distribute batches of parsed logs to the single writing routine via this channel
-	parsedLogChannel := make(chan []parser.Log)
+	parsedLogChannel := make(chan parser.Batch)
+
+	// If bots are classified, the classification routine passes the parsed batches on via this channel
+	classifiedChannel := parsedLogChannel
+	// If GeoIP enrichment is enabled, the enrichment routine passes the parsed batches to the writing routine via this channel
+	insertChannel := classifiedChannel
 
 	var batchWg sync.WaitGroup
+	var classifyWg sync.WaitGroup
+	var enrichWg sync.WaitGroup
 	var insertWg sync.WaitGroup
 
-	parser, err := parser.NewParser(l, nil)
+	if cfg.ClassifyBots {
+		classifier, err := botclass.NewClassifier(l, cfg)
+		if err != nil {
+			l.Println("error creating bot classifier: ", err)
+			return
+		}
+
+		classifiedChannel = make(chan parser.Batch)
+		insertChannel = classifiedChannel
+
+		// The behaviour of the clients is tracked across batches, therefore there is only one classification routine
+		classifyWg.Add(1)
+		go classifier.ClassifyBatch(parsedLogChannel, classifiedChannel, &classifyWg)
+
+		l.Debug("bot classification routine spawned...")
+	}
+
+	if cfg.GeoIP() {
+		enricher, err := geoip.NewEnricher(l, cfg)
+		if err != nil {
+			l.Println("error creating GeoIP enricher: ", err)
+			return
+		}
+
+		defer func() {
+			if err := enricher.Close(); err != nil {
+				l.Println("error closing GeoIP databases: ", err)
+			}
+		}()
+
+		insertChannel = make(chan parser.Batch)
+
+		enrichWg.Add(1)
+		go enricher.EnrichBatch(classifiedChannel, insertChannel, &enrichWg)
+
+		l.Debug("GeoIP enrichment routine spawned...")
+	}
+
+	// The default regex is used if no custom regex is configured
+	var regex *string
+	if cfg.Regex != "" {
+		regex = &cfg.Regex
+	}
+
+	parser, err := parser.NewParser(l, cfg, regex)
 	if err != nil {
 		l.Println("error creating parser: ", err)
 		return
 	}
 
+	if err := db.StartRun(start, getVersion(), configSnapshot(cfg, parser.Regex())); err != nil {
+		l.Println("error recording ingestion run: ", err)
+		return
+	}
+
 	// Spin up routines to parse logs
 	routineCount := getRoutineCount(cfg)
 
+	// Instantiate a reader which will read from the configured input file and push raw logs into the batchChannel for parsing
+	reader := reader.NewReader(l, cfg)
+
+	// To preserve the input order, the write routine holds back batches parsed out of order. The number of batches in flight is bounded by the same memory usage limit as the number of parsing routines.
+	if cfg.PreserveOrder {
+		window := make(chan struct{}, routineCount+reservedRoutines)
+		reader.SetWindow(window)
+		db.SetWindow(window)
+	}
+
 	l.Debugf("spinning up %d log parsing routines...", routineCount)
 
 	for i := range routineCount {
@@ -80,13 +223,10 @@ func main() {
 
 	// There is only one DB write routine due to SQLite's single-writer model
 	insertWg.Add(1)
-	go db.InsertBatch(parsedLogChannel, &insertWg)
+	go db.InsertBatch(insertChannel, &insertWg)
 
 	l.Debug("batch insert routine spawned...")
 
-	// Instantiate a reader which will read from the configured input file and push raw logs into the batchChannel for parsing
-	reader := reader.NewReader(l, cfg)
-
 	if err := reader.ReadAndBatch(batchChannel); err != nil {
 		l.Printf("error while reading from log file and batching: %v", err)
 		return
@@ -96,8 +236,35 @@ func main() {
 	batchWg.Wait()
 
 	close(parsedLogChannel)
+	classifyWg.Wait()
+
+	if classifiedChannel != parsedLogChannel {
+		close(classifiedChannel)
+	}
+	enrichWg.Wait()
+
+	if insertChannel != classifiedChannel {
+		close(insertChannel)
+	}
 	insertWg.Wait()
 
+	summary := reader.Summary()
+	parserStats := parser.Stats()
+	stats := db.Stats()
+
+	// Logs violating the constraints of the strict log table are rejected by the write routine after being parsed
+	parsed := parserStats.Parsed - stats.Rejected
+	rejected := parserStats.Rejected + stats.Rejected
+
+	if err := db.FinishRun(database.RunSummary{
+		Files:    []database.RunFile{{Path: summary.Path, Size: summary.Size, SHA256: summary.SHA256}},
+		Read:     summary.Lines,
+		Parsed:   parsed,
+		Rejected: rejected,
+	}); err != nil {
+		l.Println("error recording end of ingestion run: ", err)
+	}
+
 	if err := db.CreateIndexes(); err != nil {
 		l.Println("error creating table indexes: ", err)
 	}
@@ -106,5 +273,10 @@ func main() {
 	end := time.Now()
 
 	l.Println("log parsing finished")
+	l.Printf("read lines: %d, rejected lines: %d", summary.Lines, rejected)
+	l.Printf("inserted logs: %d", stats.Inserted)
+	if cfg.Dedupe {
+		l.Printf("skipped duplicate logs: %d", stats.Duplicates)
+	}
 	l.Printf("elapsed time: %s", end.Sub(start))
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"go.vxn.dev/xilt/internal/config"
@@ -21,3 +22,20 @@ func TestGetRoutineCount(t *testing.T) {
 		t.Errorf("expected %d, got %d", expected, actual)
 	}
 }
+
+func TestConfigSnapshot(t *testing.T) {
+	cfg := &config.Config{
+		BatchSize: 5000,
+	}
+
+	snapshot := configSnapshot(cfg, `^(?<ip>\S*)$`)
+
+	expected := `"Regex":"^(?<ip>\\S*)$"`
+	if !strings.Contains(snapshot, expected) {
+		t.Errorf("expected snapshot to contain %s, got %s", expected, snapshot)
+	}
+
+	if cfg.Regex != "" {
+		t.Errorf("expected the config not to be modified, got regex %q", cfg.Regex)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/database"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+// runMerge runs the merge command, combining the logs of multiple DBs into a new one.
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: xilt merge [flags] dbFilePath... -o outputDBFilePath")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadMerge(fs, args)
+	if err != nil {
+		log.Fatalln("error loading config:", err)
+	}
+
+	l := logger.NewLogger(cfg.Verbose)
+
+	// Logs must not be merged into an existing DB, as the merged DB is created from scratch
+	if _, err := os.Stat(cfg.OutputDBFilePath); err == nil {
+		log.Fatalf("error merging databases: %s already exists", cfg.OutputDBFilePath)
+	}
+
+	sources := make([]database.MergeSource, 0, len(cfg.InputDBFilePaths))
+	for i, path := range cfg.InputDBFilePaths {
+		sources = append(sources, database.MergeSource{Path: path, Node: cfg.Nodes[i]})
+	}
+
+	// The merged DB is built in a temporary file in the same directory and renamed on success, so that a failed merge does not leave a partial DB behind
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.OutputDBFilePath), filepath.Base(cfg.OutputDBFilePath)+".*.tmp")
+	if err != nil {
+		log.Fatalln("error creating temporary database file:", err)
+	}
+	tmpPath := tmp.Name()
+
+	if err := tmp.Close(); err != nil {
+		log.Fatalln("error closing temporary database file:", err)
+	}
+
+	db := database.NewDB(l, &config.Config{DBFilePath: tmpPath})
+
+	start := time.Now()
+
+	stats, err := db.Merge(sources)
+	if closeErr := db.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close database: %w", closeErr)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, cfg.OutputDBFilePath)
+	}
+
+	if err != nil {
+		if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			l.Println("error removing temporary database file:", removeErr)
+		}
+
+		l.Println("error merging databases:", err)
+		return
+	}
+
+	l.Printf("merged logs: %d, skipped duplicate logs: %d", stats.Inserted, stats.Duplicates)
+	l.Printf("merging finished in %s", time.Since(start))
+}
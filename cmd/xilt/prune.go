@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/database"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+// runPrune runs the prune command, deleting the logs exceeding the configured age or row count limits.
+func runPrune(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: xilt prune [flags] [dbFilePath]")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadPrune(fs, args)
+	if err != nil {
+		log.Fatalln("error loading config:", err)
+	}
+
+	l := logger.NewLogger(cfg.Verbose)
+
+	db := database.NewDB(l, &config.Config{DBFilePath: cfg.DBFilePath})
+	if err := db.Open(); err != nil {
+		log.Fatalln("error opening database:", err)
+	}
+	defer db.Close()
+
+	opts := database.PruneOptions{
+		MaxRows:   cfg.MaxRows,
+		ChunkSize: cfg.ChunkSize,
+	}
+	if cfg.OlderThan > 0 {
+		opts.Before = time.Now().Add(-cfg.OlderThan)
+	}
+
+	start := time.Now()
+
+	deleted, err := db.Prune(opts)
+	if err != nil {
+		l.Println("error pruning logs:", err)
+		return
+	}
+
+	l.Printf("pruned logs: %d", deleted)
+
+	if cfg.Vacuum != "" {
+		l.Println("vacuuming database...")
+		if err := db.Vacuum(cfg.Vacuum == config.VacuumIncremental); err != nil {
+			l.Println("error vacuuming database:", err)
+			return
+		}
+	}
+
+	l.Printf("pruning finished in %s", time.Since(start))
+}
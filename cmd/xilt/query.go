@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/database"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+// runQuery runs the query command, printing the logs whose IPs belong to a CIDR range.
+func runQuery(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: xilt query -cidr range [flags] [dbFilePath]")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadQuery(fs, args)
+	if err != nil {
+		log.Fatalln("error loading config:", err)
+	}
+
+	l := logger.NewLogger(cfg.Verbose)
+
+	db := database.NewDB(l, &config.Config{DBFilePath: cfg.DBFilePath})
+	if err := db.Open(); err != nil {
+		log.Fatalln("error opening database:", err)
+	}
+	defer db.Close()
+
+	results, err := db.Query(database.LogQuery{
+		CIDR:      cfg.CIDR,
+		From:      cfg.From,
+		To:        cfg.To,
+		StatusMin: cfg.StatusMin,
+		StatusMax: cfg.StatusMax,
+		Limit:     cfg.Limit,
+	})
+	if err != nil {
+		l.Println("error querying logs:", err)
+		return
+	}
+
+	printResults(results)
+
+	l.Debugf("%d logs found", len(results))
+}
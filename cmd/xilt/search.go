@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/database"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+// runSearch runs the search command, printing the logs matching a full-text search query.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: xilt search [flags] query [dbFilePath]")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadSearch(fs, args)
+	if err != nil {
+		log.Fatalln("error loading config:", err)
+	}
+
+	l := logger.NewLogger(cfg.Verbose)
+
+	db := database.NewDB(l, &config.Config{DBFilePath: cfg.DBFilePath})
+	if err := db.Open(); err != nil {
+		log.Fatalln("error opening database:", err)
+	}
+	defer db.Close()
+
+	results, err := db.Search(database.SearchQuery{
+		Match:     cfg.Query,
+		From:      cfg.From,
+		To:        cfg.To,
+		StatusMin: cfg.StatusMin,
+		StatusMax: cfg.StatusMax,
+		Limit:     cfg.Limit,
+	})
+	if err != nil {
+		l.Println("error searching logs:", err)
+		return
+	}
+
+	printResults(results)
+
+	l.Debugf("%d logs found", len(results))
+}
+
+// printResults prints logs stored in the DB as a table to the standard output.
+func printResults(results []database.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tTIMESTAMP\tIP\tMETHOD\tROUTE\tPARAMS\tSTATUS\tBYTES\tREFERER\tAGENT")
+	for _, r := range results {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", r.ID, r.TimestampUTC, r.IP, r.Method, r.Route, r.Params, r.ResponseCode, r.BytesSent, r.Referer, r.Agent)
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/database"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+// runSessions runs the sessions command, grouping the stored logs into visitor sessions.
+func runSessions(args []string) {
+	fs := flag.NewFlagSet("sessions", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: xilt sessions [flags] [dbFilePath]")
+		fs.PrintDefaults()
+	}
+
+	cfg, err := config.LoadSessions(fs, args)
+	if err != nil {
+		log.Fatalln("error loading config:", err)
+	}
+
+	l := logger.NewLogger(cfg.Verbose)
+
+	db := database.NewDB(l, &config.Config{DBFilePath: cfg.DBFilePath})
+	if err := db.Open(); err != nil {
+		log.Fatalln("error opening database:", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+
+	sessions, err := db.Sessions(cfg.Gap)
+	if err != nil {
+		l.Println("error reconstructing sessions:", err)
+		return
+	}
+
+	l.Printf("sessions: %d", sessions)
+	l.Printf("session reconstruction finished in %s", time.Since(start))
+}
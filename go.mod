@@ -2,7 +2,11 @@ module go.vxn.dev/xilt
 
 go 1.24.1
 
-require github.com/ncruces/go-sqlite3 v0.24.0
+require (
+	github.com/ncruces/go-sqlite3 v0.24.0
+	github.com/oschwald/maxminddb-golang v1.13.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/ncruces/julianday v1.0.0 // indirect
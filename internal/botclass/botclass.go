@@ -0,0 +1,256 @@
+// Package botclass provides functionality for classifying the clients of parsed logs as humans, verified bots (crawlers whose IPs belong to the ranges published by their operators), claimed bots (clients declaring themselves bots which cannot be verified) and suspicious clients (spoofed crawlers and clients behaving like bots while posing as browsers). It runs as a pipeline stage between the parsing routines and the write routine.
+package botclass
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+const (
+	// Human is the class of clients with a browser user agent which do not behave like bots
+	Human = "human"
+	// VerifiedBot is the class of crawlers whose IPs belong to the ranges published by the operators claimed by their user agents
+	VerifiedBot = "verified-bot"
+	// ClaimedBot is the class of clients whose user agents declare them bots, which cannot be verified as no ranges of their operators are loaded
+	ClaimedBot = "claimed-bot"
+	// Suspicious is the class of crawlers whose IPs do not belong to the loaded ranges of their operators and of clients with a browser user agent fetching robots.txt or exceeding the request rate
+	Suspicious = "suspicious"
+
+	// maxClients limits the number of clients whose behaviour is tracked, so that logs from many distinct clients do not exhaust the memory
+	maxClients = 100000
+)
+
+// crawler is an operator of well-known crawlers identified by the tokens of their user agents, which publishes the IP ranges of the crawlers.
+type crawler struct {
+	operator string
+	tokens   []string
+}
+
+// crawlers lists the operators whose IP ranges can be loaded. The tokens are matched case-insensitively.
+var crawlers = []crawler{
+	{operator: "google", tokens: []string{"googlebot", "adsbot-google", "mediapartners-google", "storebot-google", "google-inspectiontool", "googleother", "feedfetcher-google", "apis-google"}},
+	{operator: "bing", tokens: []string{"bingbot", "bingpreview", "adidxbot", "msnbot"}},
+	{operator: "apple", tokens: []string{"applebot"}},
+	{operator: "duckduckgo", tokens: []string{"duckduckbot", "duckassistbot"}},
+	{operator: "openai", tokens: []string{"gptbot", "chatgpt-user", "oai-searchbot"}},
+}
+
+// ranges is a file of IP ranges in the format published by Google, Bing, Apple, OpenAI and others.
+type ranges struct {
+	Prefixes []struct {
+		IPv4Prefix string `json:"ipv4Prefix"`
+		IPv6Prefix string `json:"ipv6Prefix"`
+	} `json:"prefixes"`
+}
+
+// client holds the observed behaviour of a client, identified by its IP and user agent. The requests are counted per minute of their timestamps.
+type client struct {
+	minute     int64
+	requests   int
+	suspicious bool
+}
+
+// Classifier classifies the clients of parsed logs. The behaviour of the clients is tracked across batches, therefore it is not safe for concurrent use.
+type Classifier struct {
+	logger logger.Logger
+	// rate is the maximum number of requests per minute of a client with a browser user agent
+	rate   int
+	ranges map[string][]netip.Prefix
+
+	clients map[string]*client
+}
+
+// NewClassifier returns a new Classifier using the configured request rate and crawler IP ranges. The ranges are given as a comma-separated list of files, each prefixed by the operator of the crawlers (e.g. google=googlebot.json).
+func NewClassifier(l logger.Logger, c *config.Config) (*Classifier, error) {
+	cl := &Classifier{
+		logger:  l,
+		rate:    c.BotRate,
+		ranges:  make(map[string][]netip.Prefix),
+		clients: make(map[string]*client),
+	}
+
+	for _, entry := range strings.Split(c.CrawlerRanges, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		operator, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid crawler ranges '%s', expected operator=path", entry)
+		}
+
+		if !knownOperator(operator) {
+			return nil, fmt.Errorf("unknown crawler operator '%s'", operator)
+		}
+
+		prefixes, err := loadRanges(path)
+		if err != nil {
+			return nil, err
+		}
+
+		cl.ranges[operator] = append(cl.ranges[operator], prefixes...)
+	}
+
+	return cl, nil
+}
+
+// knownOperator reports whether the provided operator is listed in the crawlers.
+func knownOperator(operator string) bool {
+	for _, c := range crawlers {
+		if c.operator == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRanges reads the IP ranges stored in the JSON file at the provided path.
+func loadRanges(path string) ([]netip.Prefix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading crawler ranges: %w", err)
+	}
+
+	var r ranges
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error decoding crawler ranges %s: %w", path, err)
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(r.Prefixes))
+
+	for _, p := range r.Prefixes {
+		s := p.IPv4Prefix
+		if s == "" {
+			s = p.IPv6Prefix
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range '%s' in crawler ranges %s: %w", s, path, err)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Classify returns the class of the provided log. Crawlers claimed by the user agent are verified against the loaded ranges of their operators, other bots are taken at their word. Clients with a browser user agent are suspicious once they fetch robots.txt or exceed the request rate, which applies to their later logs as well. As the batches may be classified out of order, the rate is approximate.
+func (c *Classifier) Classify(l *parser.Log) string {
+	ip := l.ClientIP
+	if ip == "" {
+		ip = l.IP
+	}
+
+	if operator, ok := claimedCrawler(l.Agent); ok {
+		prefixes, loaded := c.ranges[operator]
+		if !loaded {
+			return ClaimedBot
+		}
+
+		if contains(prefixes, ip) {
+			return VerifiedBot
+		}
+		return Suspicious
+	}
+
+	if l.IsBot {
+		return ClaimedBot
+	}
+
+	if c.observe(ip, l) {
+		return Suspicious
+	}
+
+	return Human
+}
+
+// claimedCrawler returns the operator of the well-known crawler claimed by the provided user agent.
+func claimedCrawler(agent string) (string, bool) {
+	agent = strings.ToLower(agent)
+
+	for _, c := range crawlers {
+		for _, token := range c.tokens {
+			if strings.Contains(agent, token) {
+				return c.operator, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// contains reports whether the provided IP belongs to any of the ranges.
+func contains(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// observe records the log of the client with the provided IP and reports whether the client behaves like a bot.
+func (c *Classifier) observe(ip string, l *parser.Log) bool {
+	key := ip + "\x00" + l.Agent
+
+	cl, ok := c.clients[key]
+	if !ok {
+		if len(c.clients) >= maxClients {
+			clear(c.clients)
+		}
+
+		cl = &client{minute: l.TimestampUnix / 60}
+		c.clients[key] = cl
+	}
+
+	if path, _, _ := strings.Cut(l.Route, "?"); path == "/robots.txt" {
+		cl.suspicious = true
+	}
+
+	// The requests of earlier minutes from batches classified out of order are not counted
+	switch minute := l.TimestampUnix / 60; {
+	case minute > cl.minute:
+		cl.minute = minute
+		cl.requests = 1
+	case minute == cl.minute:
+		cl.requests++
+	}
+
+	if cl.requests > c.rate {
+		cl.suspicious = true
+	}
+
+	return cl.suspicious
+}
+
+// ClassifyBatch reads batches of parsed logs from an input channel, classifies their logs and sends the batches to an output channel. It is designed to run concurrently as part of a single goroutine.
+func (c *Classifier) ClassifyBatch(parsedLogChan <-chan parser.Batch, classifiedLogChan chan<- parser.Batch, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for batch := range parsedLogChan {
+		for i := range batch.Logs {
+			batch.Logs[i].BotClass = c.Classify(&batch.Logs[i])
+		}
+
+		c.logger.Debugf("classification routine classified a batch of %d logs", len(batch.Logs))
+
+		classifiedLogChan <- batch
+	}
+}
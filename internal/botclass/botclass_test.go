@@ -0,0 +1,181 @@
+package botclass
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+const (
+	googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	bingbot   = "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)"
+	browser   = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+)
+
+// writeRanges writes a crawler ranges file with the provided content and returns its path.
+func writeRanges(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ranges.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	return path
+}
+
+func testClassifier(t *testing.T) *Classifier {
+	t.Helper()
+
+	google := writeRanges(t, `{"creationTime": "2024-01-01T00:00:00.000000", "prefixes": [{"ipv6Prefix": "2001:4860:4801:10::/64"}, {"ipv4Prefix": "66.249.64.0/27"}]}`)
+
+	c, err := NewClassifier(logger.NewLogger(false), &config.Config{CrawlerRanges: "google=" + google, BotRate: 3})
+	if err != nil {
+		t.Fatalf("error creating classifier: %v", err)
+	}
+
+	return c
+}
+
+func TestClassifier_Classify(t *testing.T) {
+	c := testClassifier(t)
+
+	tests := []struct {
+		name     string
+		log      parser.Log
+		expected string
+	}{
+		{name: "verified crawler", log: parser.Log{IP: "66.249.64.1", Agent: googlebot, IsBot: true}, expected: VerifiedBot},
+		{name: "verified IPv6 crawler", log: parser.Log{IP: "2001:4860:4801:10::1", Agent: googlebot, IsBot: true}, expected: VerifiedBot},
+		{name: "IPv4-mapped crawler", log: parser.Log{IP: "::ffff:66.249.64.2", Agent: googlebot, IsBot: true}, expected: VerifiedBot},
+		{name: "spoofed crawler", log: parser.Log{IP: "203.0.113.1", Agent: googlebot, IsBot: true}, expected: Suspicious},
+		// The client IP resolved from a forwarding header is verified instead of the proxy's
+		{name: "crawler behind proxy", log: parser.Log{IP: "10.0.0.1", ClientIP: "66.249.64.3", Agent: googlebot, IsBot: true}, expected: VerifiedBot},
+		// No ranges of Bing are loaded
+		{name: "unverifiable crawler", log: parser.Log{IP: "203.0.113.1", Agent: bingbot, IsBot: true}, expected: ClaimedBot},
+		{name: "other bot", log: parser.Log{IP: "203.0.113.1", Agent: "curl/8.0", IsBot: true}, expected: ClaimedBot},
+		{name: "browser", log: parser.Log{IP: "198.51.100.1", Agent: browser, Route: "/robots.txt/"}, expected: Human},
+	}
+
+	for _, tt := range tests {
+		if actual := c.Classify(&tt.log); actual != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+func TestClassifier_ClassifyBehaviour(t *testing.T) {
+	tests := []struct {
+		name     string
+		logs     []parser.Log
+		expected []string
+	}{
+		{
+			name: "robots.txt",
+			logs: []parser.Log{
+				{IP: "198.51.100.1", Agent: browser, Route: "/", TimestampUnix: 60},
+				{IP: "198.51.100.1", Agent: browser, Route: "/robots.txt?x=1", TimestampUnix: 61},
+				{IP: "198.51.100.1", Agent: browser, Route: "/", TimestampUnix: 3600},
+				// Other clients are not affected
+				{IP: "198.51.100.2", Agent: browser, Route: "/", TimestampUnix: 62},
+				{IP: "198.51.100.1", Agent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", Route: "/", TimestampUnix: 62},
+			},
+			expected: []string{Human, Suspicious, Suspicious, Human, Human},
+		},
+		{
+			name: "request rate",
+			logs: []parser.Log{
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 60},
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 61},
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 62},
+				// Requests of a new minute are counted from scratch
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 120},
+				// Requests of earlier minutes are not counted
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 63},
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 121},
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 122},
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 123},
+				{IP: "198.51.100.1", Agent: browser, TimestampUnix: 600},
+			},
+			expected: []string{Human, Human, Human, Human, Human, Human, Human, Suspicious, Suspicious},
+		},
+		{
+			// Declared bots fetch robots.txt and make many requests by design
+			name: "bot",
+			logs: []parser.Log{
+				{IP: "198.51.100.1", Agent: "curl/8.0", IsBot: true, Route: "/robots.txt", TimestampUnix: 60},
+				{IP: "198.51.100.1", Agent: "curl/8.0", IsBot: true, TimestampUnix: 60},
+				{IP: "198.51.100.1", Agent: "curl/8.0", IsBot: true, TimestampUnix: 60},
+				{IP: "198.51.100.1", Agent: "curl/8.0", IsBot: true, TimestampUnix: 60},
+			},
+			expected: []string{ClaimedBot, ClaimedBot, ClaimedBot, ClaimedBot},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClassifier(t)
+
+			for i := range tt.logs {
+				if actual := c.Classify(&tt.logs[i]); actual != tt.expected[i] {
+					t.Errorf("log %d: expected %s, got %s", i, tt.expected[i], actual)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifier_ClassifyBatch(t *testing.T) {
+	c := testClassifier(t)
+
+	parsedLogChan := make(chan parser.Batch, 1)
+	classifiedLogChan := make(chan parser.Batch, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go c.ClassifyBatch(parsedLogChan, classifiedLogChan, &wg)
+
+	parsedLogChan <- parser.Batch{Seq: 3, Logs: []parser.Log{{IP: "66.249.64.1", Agent: googlebot, IsBot: true}, {IP: "198.51.100.1", Agent: browser}}}
+	close(parsedLogChan)
+
+	wg.Wait()
+
+	batch := <-classifiedLogChan
+
+	if batch.Seq != 3 || len(batch.Logs) != 2 {
+		t.Fatalf("expected the batch to be passed on, got %+v", batch)
+	}
+
+	if batch.Logs[0].BotClass != VerifiedBot || batch.Logs[1].BotClass != Human {
+		t.Errorf("expected %s and %s, got %s and %s", VerifiedBot, Human, batch.Logs[0].BotClass, batch.Logs[1].BotClass)
+	}
+}
+
+func TestNewClassifier_Invalid(t *testing.T) {
+	valid := writeRanges(t, `{"prefixes": [{"ipv4Prefix": "66.249.64.0/27"}]}`)
+
+	tests := []struct {
+		name   string
+		ranges string
+	}{
+		{name: "missing operator", ranges: valid},
+		{name: "unknown operator", ranges: "yahoo=" + valid},
+		{name: "missing file", ranges: "google=" + filepath.Join(t.TempDir(), "missing.json")},
+		{name: "invalid JSON", ranges: "google=" + writeRanges(t, "not JSON")},
+		{name: "invalid range", ranges: "google=" + writeRanges(t, `{"prefixes": [{"ipv4Prefix": "66.249.64.0/33"}]}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClassifier(logger.NewLogger(false), &config.Config{CrawlerRanges: tt.ranges, BotRate: 1}); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
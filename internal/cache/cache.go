@@ -0,0 +1,53 @@
+// Package cache provides a size-bounded cache of computed values, used by the pipeline stages which parse or look up values repeating over and over in access logs (such as user agents, referers and IPs).
+package cache
+
+import "sync"
+
+// DefaultSize limits the number of cached values, so that logs with many distinct values (e.g. random user agents or IPs of a scan) do not exhaust the memory.
+const DefaultSize = 100000
+
+// Cache is a map of computed values safe for concurrent use. Once it holds its maximum number of values, it is cleared as a whole, which is cheaper than tracking their usage and good enough for the repetitive values of access logs.
+type Cache[K comparable, V any] struct {
+	size int
+
+	mu     sync.RWMutex
+	values map[K]V
+}
+
+// New returns a new Cache holding at most the provided number of values.
+func New[K comparable, V any](size int) *Cache[K, V] {
+	return &Cache[K, V]{
+		size:   size,
+		values: make(map[K]V),
+	}
+}
+
+// GetOrCompute returns the cached value of the provided key. If the key is not cached, the value is computed by the provided function and cached. Concurrent calls for the same key may compute the value more than once.
+func (c *Cache[K, V]) GetOrCompute(key K, compute func(K) V) V {
+	c.mu.RLock()
+	v, ok := c.values[key]
+	c.mu.RUnlock()
+
+	if ok {
+		return v
+	}
+
+	v = compute(key)
+
+	c.mu.Lock()
+	if len(c.values) >= c.size {
+		clear(c.values)
+	}
+	c.values[key] = v
+	c.mu.Unlock()
+
+	return v
+}
+
+// Len returns the number of cached values.
+func (c *Cache[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.values)
+}
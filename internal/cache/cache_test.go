@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCache_GetOrCompute(t *testing.T) {
+	c := New[string, string](2)
+
+	computed := 0
+	upper := func(s string) string {
+		computed++
+		return strings.ToUpper(s)
+	}
+
+	for _, key := range []string{"a", "b", "a", "b"} {
+		if actual := c.GetOrCompute(key, upper); actual != strings.ToUpper(key) {
+			t.Errorf("%s: expected %s, got %s", key, strings.ToUpper(key), actual)
+		}
+	}
+
+	// The repeated keys are served from the cache
+	if computed != 2 || c.Len() != 2 {
+		t.Errorf("expected 2 computed and cached values, got %d computed and %d cached", computed, c.Len())
+	}
+
+	// The full cache is cleared before caching another value
+	c.GetOrCompute("c", upper)
+
+	if c.Len() != 1 {
+		t.Errorf("expected the cache to be cleared, got %d cached values", c.Len())
+	}
+
+	c.GetOrCompute("a", upper)
+
+	if computed != 4 {
+		t.Errorf("expected the cleared values to be computed again, got %d computed values", computed)
+	}
+}
@@ -0,0 +1,172 @@
+// Package clientip provides functionality for resolving the IP of the client which made a request passing through reverse proxies or load balancers, using the value of a forwarding header (X-Forwarded-For, X-Real-IP or Forwarded) and the list of trusted proxies.
+package clientip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	// HeaderXForwardedFor is a comma-separated list of the IPs of the client and the proxies the request passed through, each proxy appending the IP of its peer
+	HeaderXForwardedFor = "x-forwarded-for"
+	// HeaderXRealIP is the IP of the client set by a single proxy
+	HeaderXRealIP = "x-real-ip"
+	// HeaderForwarded is the standard header (RFC 7239) listing the client and the proxies in its for= parameters
+	HeaderForwarded = "forwarded"
+)
+
+// Resolver resolves the IPs of clients using the rightmost-untrusted algorithm: the chain of IPs made of the header's IPs followed by the peer IP is walked from the right, skipping trusted proxies, and the first untrusted IP is the client's. The IPs left of it may be spoofed by the client, so they are ignored.
+type Resolver struct {
+	header  string
+	trusted []netip.Prefix
+}
+
+// NewResolver returns a new Resolver of the provided header, trusting the proxies in the provided comma-separated list of CIDR ranges or IPs.
+func NewResolver(header string, trustedProxies string) (*Resolver, error) {
+	switch header {
+	case HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded:
+	default:
+		return nil, fmt.Errorf("unsupported forwarding header '%s'", header)
+	}
+
+	trusted, err := ParsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolver{
+		header:  header,
+		trusted: trusted,
+	}, nil
+}
+
+// ParsePrefixes parses a comma-separated list of CIDR ranges or IPs, a single IP being a range of its own.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0)
+
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s': %w", s, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range '%s': %w", s, err)
+		}
+
+		// The IPs are matched unmapped, therefore ranges of IPv4-mapped IPv6 addresses are converted to IPv4 ranges
+		if prefix.Addr().Is4In6() {
+			if prefix.Bits() < 96 {
+				return nil, fmt.Errorf("invalid trusted proxy range '%s': IPv4-mapped range must be at least /96", s)
+			}
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Resolve returns the IP of the client which made the request received from the peer IP with the provided header value. If the peer is not a trusted proxy, the header may be forged, therefore the peer is the client. If all the IPs are trusted, the leftmost one is the client. An invalid IP in the header stops the walk at the last valid IP. The resolved IP is in the canonical text form.
+func (r *Resolver) Resolve(peer string, value string) string {
+	addr, err := netip.ParseAddr(peer)
+	if err != nil {
+		return peer
+	}
+
+	client := addr.Unmap()
+	if !r.isTrusted(client) {
+		return client.String()
+	}
+
+	chain := r.chain(value)
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(chain[i])
+		if !ok {
+			break
+		}
+
+		client = addr
+		if !r.isTrusted(client) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// isTrusted reports whether the IP belongs to a trusted proxy.
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// chain returns the IPs listed in the header value from the left (client side) to the right (proxy side).
+func (r *Resolver) chain(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "-" {
+		return nil
+	}
+
+	switch r.header {
+	case HeaderXRealIP:
+		return []string{value}
+	case HeaderForwarded:
+		return forwardedFor(value)
+	default:
+		return strings.Split(value, ",")
+	}
+}
+
+// forwardedFor returns the values of the for= parameters of the elements of a Forwarded header value, e.g. for=192.0.2.60;proto=http, for="[2001:db8::1]:4711". Elements without the parameter are kept as empty values, as the chain cannot be followed past them.
+func forwardedFor(value string) []string {
+	elements := strings.Split(value, ",")
+	chain := make([]string, 0, len(elements))
+
+	for _, element := range elements {
+		var node string
+		for _, pair := range strings.Split(element, ";") {
+			key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				node = strings.Trim(v, `"`)
+				break
+			}
+		}
+		chain = append(chain, node)
+	}
+
+	return chain
+}
+
+// parseAddr parses an IP of the chain, which may include a port (1.2.3.4:80, [2001:db8::1]:4711) or brackets. Obfuscated identifiers (e.g. unknown or _hidden) are not valid IPs.
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
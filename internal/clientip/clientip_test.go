@@ -0,0 +1,74 @@
+package clientip
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		peer     string
+		value    string
+		expected string
+	}{
+		{name: "untrusted peer", header: HeaderXForwardedFor, peer: "203.0.113.7", value: "198.51.100.1", expected: "203.0.113.7"},
+		{name: "no header", header: HeaderXForwardedFor, peer: "10.0.0.1", value: "-", expected: "10.0.0.1"},
+		{name: "single proxy", header: HeaderXForwardedFor, peer: "10.0.0.1", value: "198.51.100.1", expected: "198.51.100.1"},
+		// The leftmost IPs may be forged by the client
+		{name: "spoofed", header: HeaderXForwardedFor, peer: "10.0.0.1", value: "1.1.1.1, 198.51.100.1, 10.0.0.2", expected: "198.51.100.1"},
+		{name: "all trusted", header: HeaderXForwardedFor, peer: "10.0.0.1", value: "10.0.0.3, 192.168.1.1", expected: "10.0.0.3"},
+		{name: "invalid entry", header: HeaderXForwardedFor, peer: "10.0.0.1", value: "198.51.100.1, garbage, 10.0.0.2", expected: "10.0.0.2"},
+		{name: "ports and mapped IPs", header: HeaderXForwardedFor, peer: "::ffff:10.0.0.1", value: "[2001:DB8::1]:4711, 10.0.0.2:8080", expected: "2001:db8::1"},
+		{name: "real IP", header: HeaderXRealIP, peer: "192.168.1.1", value: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "real IP untrusted peer", header: HeaderXRealIP, peer: "192.168.1.2", value: "198.51.100.1", expected: "192.168.1.2"},
+		{name: "forwarded", header: HeaderForwarded, peer: "10.0.0.1", value: `for=1.1.1.1, for=198.51.100.1;proto=https;by=10.0.0.2, For="[2001:db8::1]:4711"`, expected: "2001:db8::1"},
+		{name: "forwarded trusted", header: HeaderForwarded, peer: "10.0.0.1", value: `for=198.51.100.1;proto=https, for=10.0.0.2`, expected: "198.51.100.1"},
+		{name: "forwarded obfuscated", header: HeaderForwarded, peer: "10.0.0.1", value: `for=198.51.100.1, for=_hidden, for=10.0.0.2`, expected: "10.0.0.2"},
+		{name: "invalid peer", header: HeaderXForwardedFor, peer: "proxy.example.com", value: "198.51.100.1", expected: "proxy.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(tt.header, "10.0.0.0/8, 192.168.1.1")
+			if err != nil {
+				t.Fatalf("error creating resolver: %v", err)
+			}
+
+			if actual := r.Resolve(tt.peer, tt.value); actual != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes("10.1.2.3/8, 192.168.1.1,,::ffff:172.16.0.1, ::ffff:10.0.0.0/104, 2001:db8::/32")
+	if err != nil {
+		t.Fatalf("error parsing prefixes: %v", err)
+	}
+
+	expected := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+		netip.MustParsePrefix("172.16.0.1/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	if !slices.Equal(prefixes, expected) {
+		t.Errorf("expected %v, got %v", expected, prefixes)
+	}
+
+	for _, list := range []string{"10.0.0.0/33", "::ffff:10.0.0.0/64", "proxy.example.com"} {
+		if _, err := ParsePrefixes(list); err == nil {
+			t.Errorf("%s: expected error, got nil", list)
+		}
+	}
+
+	if _, err := NewResolver("via", "10.0.0.0/8"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
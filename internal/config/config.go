@@ -5,6 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"path/filepath"
+	"time"
+
+	"go.vxn.dev/xilt/internal/clientip"
 )
 
 type Config struct {
@@ -15,6 +18,35 @@ type Config struct {
 	AverageLogSizeMB float64
 	Verbose          bool
 	CreateIndexes    bool
+	Normalize        bool
+	Dedupe           bool
+	Source           string
+	RollupInterval   time.Duration
+	FullTextSearch   bool
+	Regex            string
+	Provenance       bool
+	Partition        string
+	Profile          string
+	MultiRowInsert   bool
+	PreserveOrder    bool
+	Strict           bool
+	ParseAgents      bool
+	AgentRules       string
+	GeoIPFilePath    string
+	ASNFilePath      string
+	ParseIPs         bool
+	ForwardedHeader  string
+	TrustedProxies   string
+	DecodeURLs       bool
+	ParamsTable      bool
+	RouteTemplates   bool
+	RouteRules       string
+	ParseReferers    bool
+	Sites            string
+	DurationUnit     string
+	ClassifyBots     bool
+	CrawlerRanges    string
+	BotRate          int
 }
 
 const (
@@ -25,6 +57,63 @@ const (
 	defaultAverageLogSizeMB = 0.001 // 1 KB
 	defaultVerbose          = false
 	defaultCreateIndexes    = false
+	defaultNormalize        = false
+	defaultDedupe           = false
+	defaultSource           = ""
+	defaultRollupInterval   = 0
+	defaultFullTextSearch   = false
+	defaultRegex            = ""
+	defaultProvenance       = false
+	defaultPartition        = ""
+	defaultMultiRowInsert   = false
+	defaultPreserveOrder    = false
+	defaultStrict           = false
+	defaultParseAgents      = false
+	defaultAgentRules       = ""
+	defaultGeoIPFilePath    = ""
+	defaultASNFilePath      = ""
+	defaultParseIPs         = false
+	defaultForwardedHeader  = ""
+	defaultTrustedProxies   = ""
+	defaultDecodeURLs       = false
+	defaultParamsTable      = false
+	defaultRouteTemplates   = false
+	defaultRouteRules       = ""
+	defaultParseReferers    = false
+	defaultSites            = ""
+	defaultDurationUnit     = ""
+	defaultClassifyBots     = false
+	defaultCrawlerRanges    = ""
+	defaultBotRate          = 120
+
+	// PartitionDay stores the logs of each day (UTC) in a separate table
+	PartitionDay = "day"
+	// PartitionMonth stores the logs of each month (UTC) in a separate table
+	PartitionMonth = "month"
+
+	// ProfileFast favors the write speed over durability, a crash may corrupt the DB
+	ProfileFast = "fast"
+	// ProfileSafe uses WAL with synchronous writes at checkpoints, so that a crash may only lose the last batches
+	ProfileSafe = "safe"
+	// ProfileReaders uses WAL like ProfileSafe with a smaller cache and short write transactions
+	ProfileReaders = "readers"
+
+	defaultProfile = ProfileFast
+
+	// DurationMicroseconds is the unit of Apache's %D
+	DurationMicroseconds = "us"
+	// DurationMilliseconds is the unit of Apache's %{ms}T
+	DurationMilliseconds = "ms"
+	// DurationSeconds is the unit of Apache's %T and nginx's $request_time and $upstream_response_time
+	DurationSeconds = "s"
+)
+
+var (
+	// profileBatchSizeCaps limits the batch size in the profiles using WAL, as big batches bloat the WAL file and make the write transactions long
+	profileBatchSizeCaps = map[string]int{
+		ProfileSafe:    10000,
+		ProfileReaders: 1000,
+	}
 )
 
 func defineFlags(fs *flag.FlagSet, cfg *Config) {
@@ -33,6 +122,35 @@ func defineFlags(fs *flag.FlagSet, cfg *Config) {
 	fs.Float64Var(&cfg.AverageLogSizeMB, "avgLogSize", defaultAverageLogSizeMB, "Defines the average size of one log in MB. Used for calculating the number of goroutines to spin up.")
 	fs.BoolVar(&cfg.Verbose, "v", defaultVerbose, "Defines whether verbose mode should be used.")
 	fs.BoolVar(&cfg.CreateIndexes, "i", defaultCreateIndexes, "Defines whether indexes should be created in the parsed logs' table.")
+	fs.BoolVar(&cfg.Normalize, "normalize", defaultNormalize, "Defines whether routes, referers and agents should be stored in lookup tables referenced by the parsed logs instead of being repeated in every row.")
+	fs.BoolVar(&cfg.Dedupe, "dedupe", defaultDedupe, "Defines whether logs already stored in the DB (identified by a hash of the raw log and its source) should be skipped. Allows appending logs to a DB created with this flag.")
+	fs.StringVar(&cfg.Source, "source", defaultSource, "Defines the name of the source the logs come from (e.g. the name of the web node). Used for identifying duplicate logs.")
+	fs.DurationVar(&cfg.RollupInterval, "rollup", defaultRollupInterval, "Defines the time bucket size (e.g. 1h) of the rollup tables aggregating requests, bytes and status classes per route, IP and agent. Rollup tables are not maintained if set to 0.")
+	fs.BoolVar(&cfg.FullTextSearch, "fts", defaultFullTextSearch, "Defines whether a full-text search table indexing routes, params, referers and agents should be maintained. Required by the search command.")
+	fs.StringVar(&cfg.Regex, "regex", defaultRegex, "Defines a custom regex used to parse logs. It must capture the same groups in the same order as the default regex for Common and Combined Log Formats, which is used if not set.")
+	fs.BoolVar(&cfg.Provenance, "provenance", defaultProvenance, "Defines whether the source file, line number and byte offset of each log should be stored.")
+	fs.StringVar(&cfg.Profile, "profile", defaultProfile, "Defines the durability profile of the DB writes (fast, safe, readers). The safe and readers profiles cap the batch size to 10000 and 1000 respectively.")
+	fs.StringVar(&cfg.Partition, "partition", defaultPartition, "Defines whether the logs should be stored in a separate table per day or month (day, month), combined by the logs view. Old partitions can be dropped cheaply by the prune command.")
+	fs.BoolVar(&cfg.MultiRowInsert, "multiRowInsert", defaultMultiRowInsert, "Defines whether the logs should be inserted by statements inserting as many logs at once as SQLite's limit of statement parameters allows, instead of one by one.")
+	fs.BoolVar(&cfg.PreserveOrder, "preserveOrder", defaultPreserveOrder, "Defines whether the logs should be stored in the order of the lines of the log file, so that the order of their IDs matches the line order. Batches parsed out of order are held back by the write routine within the memory usage limit.")
+	fs.BoolVar(&cfg.Strict, "strict", defaultStrict, "Defines whether the log table should be a STRICT table enforcing the column types along with checks of the response code (100-599), bytes sent (non-negative) and method (standard HTTP methods). Logs violating them are counted as rejected lines.")
+	fs.BoolVar(&cfg.ParseAgents, "parseAgents", defaultParseAgents, "Defines whether user agents should be parsed into the browser, browser version, OS, device type and bot flag of each log.")
+	fs.StringVar(&cfg.AgentRules, "agentRules", defaultAgentRules, "Defines the path to a YAML file with user agent parsing rules in the uap-core format, which replace the embedded ones. Requires the -parseAgents flag.")
+	fs.StringVar(&cfg.GeoIPFilePath, "geoipDB", defaultGeoIPFilePath, "Defines the path to a MaxMind DB file (e.g. GeoLite2-City.mmdb) used to look up the country, region and city of each IP offline. The results are stored in the ip_info table.")
+	fs.StringVar(&cfg.ASNFilePath, "asnDB", defaultASNFilePath, "Defines the path to a MaxMind DB file (e.g. GeoLite2-ASN.mmdb) used to look up the ASN and organization of each IP offline. The results are stored in the ip_info table.")
+	fs.BoolVar(&cfg.ParseIPs, "parseIPs", defaultParseIPs, "Defines whether IPs should be validated and normalized (e.g. IPv4-mapped IPv6 addresses to IPv4), storing a sortable binary form of each IP for CIDR range queries and flagging invalid IPs.")
+	fs.StringVar(&cfg.ForwardedHeader, "forwardedHeader", defaultForwardedHeader, "Defines the forwarding header (x-forwarded-for, x-real-ip, forwarded) captured by the 'forwarded' named group of the custom regex, from which the client IP of each log is resolved. Requires the -trustedProxies flag.")
+	fs.StringVar(&cfg.TrustedProxies, "trustedProxies", defaultTrustedProxies, "Defines the comma-separated CIDR ranges or IPs of the trusted proxies (e.g. load balancers). The client IP is the rightmost IP of the forwarding header and the peer IP which is not a trusted proxy.")
+	fs.BoolVar(&cfg.DecodeURLs, "decodeURLs", defaultDecodeURLs, "Defines whether the routes and query strings should be URL-decoded into the RouteDecoded and ParamsDecoded columns, flagging routes and params with invalid encodings, double encodings (e.g. %252e) and null bytes.")
+	fs.BoolVar(&cfg.ParamsTable, "paramsTable", defaultParamsTable, "Defines whether the URL-decoded query parameters of each log should be stored in the log_params table (LogID, Key, Value), so that logs can be looked up by their parameters.")
+	fs.BoolVar(&cfg.RouteTemplates, "routeTemplates", defaultRouteTemplates, "Defines whether the numeric IDs, UUIDs and hashes in the routes should be replaced by placeholders (:id, :uuid, :hash) in the RouteTemplate column, e.g. /users/:id/orders/:id.")
+	fs.StringVar(&cfg.RouteRules, "routeRules", defaultRouteRules, "Defines the path to a YAML file with custom route templating rules (regexes and their replacements), which are applied before the built-in ones. Requires the -routeTemplates flag.")
+	fs.BoolVar(&cfg.ParseReferers, "parseReferers", defaultParseReferers, "Defines whether referers should be parsed into their host, path and internal flag, along with the search engine and search query of referrals from well-known search engines.")
+	fs.StringVar(&cfg.Sites, "sites", defaultSites, "Defines the comma-separated hosts of the sites the logs belong to (e.g. example.com,www.example.com). Referrals from them are flagged as internal. Requires the -parseReferers flag.")
+	fs.StringVar(&cfg.DurationUnit, "durationUnit", defaultDurationUnit, "Defines the unit (us, ms, s) of the request time and upstream time captured by the 'request_time' and 'upstream_time' named groups of the custom regex, which are stored in microseconds along with views of their percentiles per route.")
+	fs.BoolVar(&cfg.ClassifyBots, "classifyBots", defaultClassifyBots, "Defines whether the client of each log should be classified as human, verified-bot, claimed-bot or suspicious in the BotClass column, based on the user agent, the crawler IP ranges and the behaviour of the client. Requires the -parseAgents flag.")
+	fs.StringVar(&cfg.CrawlerRanges, "crawlerRanges", defaultCrawlerRanges, "Defines the comma-separated JSON files of the IP ranges published by the crawlers' operators (google, bing, apple, duckduckgo, openai), each prefixed by the operator, e.g. google=googlebot.json. Crawlers claimed by the user agents are verified against them. Requires the -classifyBots flag.")
+	fs.IntVar(&cfg.BotRate, "botRate", defaultBotRate, "Defines the number of requests per minute of a client (IP and user agent) with a browser user agent above which it is classified as suspicious.")
 }
 
 // Load attempts to parse flags and args and update the config with the parsed values. A default value is returned for each field if no value is specified in a flag/arg. If successful, it returns the updated config. Otherwise, an error is returned.
@@ -45,6 +163,35 @@ func Load(fs *flag.FlagSet, args []string) (*Config, error) {
 		AverageLogSizeMB: defaultAverageLogSizeMB,
 		Verbose:          defaultVerbose,
 		CreateIndexes:    defaultCreateIndexes,
+		Normalize:        defaultNormalize,
+		Dedupe:           defaultDedupe,
+		Source:           defaultSource,
+		RollupInterval:   defaultRollupInterval,
+		FullTextSearch:   defaultFullTextSearch,
+		Regex:            defaultRegex,
+		Provenance:       defaultProvenance,
+		Partition:        defaultPartition,
+		Profile:          defaultProfile,
+		MultiRowInsert:   defaultMultiRowInsert,
+		PreserveOrder:    defaultPreserveOrder,
+		Strict:           defaultStrict,
+		ParseAgents:      defaultParseAgents,
+		AgentRules:       defaultAgentRules,
+		GeoIPFilePath:    defaultGeoIPFilePath,
+		ASNFilePath:      defaultASNFilePath,
+		ParseIPs:         defaultParseIPs,
+		ForwardedHeader:  defaultForwardedHeader,
+		TrustedProxies:   defaultTrustedProxies,
+		DecodeURLs:       defaultDecodeURLs,
+		ParamsTable:      defaultParamsTable,
+		RouteTemplates:   defaultRouteTemplates,
+		RouteRules:       defaultRouteRules,
+		ParseReferers:    defaultParseReferers,
+		Sites:            defaultSites,
+		DurationUnit:     defaultDurationUnit,
+		ClassifyBots:     defaultClassifyBots,
+		CrawlerRanges:    defaultCrawlerRanges,
+		BotRate:          defaultBotRate,
 	}
 
 	defineFlags(fs, cfg)
@@ -65,21 +212,29 @@ func Load(fs *flag.FlagSet, args []string) (*Config, error) {
 		}
 	}
 	if len(parsedArgs) >= 2 {
-		cleanPath := filepath.Clean(parsedArgs[1])
-		if cleanPath != "." {
-			cfg.DBFilePath = cleanPath
-		} else {
-			return nil, fmt.Errorf("the provided DB file path is invalid")
+		cleanPath, err := cleanDBFilePath(parsedArgs[1])
+		if err != nil {
+			return nil, err
 		}
+		cfg.DBFilePath = cleanPath
 	}
 
 	if err := cfg.validate(); err != nil {
 		return nil, err
 	}
 
+	if limit, ok := profileBatchSizeCaps[cfg.Profile]; ok && cfg.BatchSize > limit {
+		cfg.BatchSize = limit
+	}
+
 	return cfg, nil
 }
 
+// GeoIP reports whether the IPs of the logs are looked up in GeoIP or ASN databases.
+func (cfg *Config) GeoIP() bool {
+	return cfg.GeoIPFilePath != "" || cfg.ASNFilePath != ""
+}
+
 // validate checks that the currently configured values make sense for continuing with log processing.
 // TODO: validate file paths?
 func (cfg *Config) validate() error {
@@ -92,6 +247,45 @@ func (cfg *Config) validate() error {
 	if cfg.BatchSize <= 0 {
 		return fmt.Errorf("BatchSize must be greater than 0. Got %d", cfg.BatchSize)
 	}
+	if cfg.RollupInterval != 0 && (cfg.RollupInterval < time.Second || cfg.RollupInterval%time.Second != 0) {
+		return fmt.Errorf("RollupInterval must be a whole number of seconds. Got %s", cfg.RollupInterval)
+	}
+	if cfg.Partition != "" && cfg.Partition != PartitionDay && cfg.Partition != PartitionMonth {
+		return fmt.Errorf("Partition must be either '%s' or '%s'. Got '%s'", PartitionDay, PartitionMonth, cfg.Partition)
+	}
+	if cfg.AgentRules != "" && !cfg.ParseAgents {
+		return fmt.Errorf("AgentRules requires ParseAgents to be enabled")
+	}
+	if cfg.RouteRules != "" && !cfg.RouteTemplates {
+		return fmt.Errorf("RouteRules requires RouteTemplates to be enabled")
+	}
+	if cfg.Sites != "" && !cfg.ParseReferers {
+		return fmt.Errorf("Sites requires ParseReferers to be enabled")
+	}
+	if cfg.ClassifyBots && !cfg.ParseAgents {
+		return fmt.Errorf("ClassifyBots requires ParseAgents to be enabled")
+	}
+	if cfg.CrawlerRanges != "" && !cfg.ClassifyBots {
+		return fmt.Errorf("CrawlerRanges requires ClassifyBots to be enabled")
+	}
+	if cfg.ClassifyBots && cfg.BotRate <= 0 {
+		return fmt.Errorf("BotRate must be greater than 0. Got %d", cfg.BotRate)
+	}
+	if cfg.DurationUnit != "" && cfg.DurationUnit != DurationMicroseconds && cfg.DurationUnit != DurationMilliseconds && cfg.DurationUnit != DurationSeconds {
+		return fmt.Errorf("DurationUnit must be one of '%s', '%s' or '%s'. Got '%s'", DurationMicroseconds, DurationMilliseconds, DurationSeconds, cfg.DurationUnit)
+	}
+	if (cfg.ForwardedHeader == "") != (cfg.TrustedProxies == "") {
+		return fmt.Errorf("ForwardedHeader and TrustedProxies must be set together")
+	}
+	if cfg.ForwardedHeader != "" && cfg.ForwardedHeader != clientip.HeaderXForwardedFor && cfg.ForwardedHeader != clientip.HeaderXRealIP && cfg.ForwardedHeader != clientip.HeaderForwarded {
+		return fmt.Errorf("ForwardedHeader must be one of '%s', '%s' or '%s'. Got '%s'", clientip.HeaderXForwardedFor, clientip.HeaderXRealIP, clientip.HeaderForwarded, cfg.ForwardedHeader)
+	}
+	if _, err := clientip.ParsePrefixes(cfg.TrustedProxies); err != nil {
+		return fmt.Errorf("TrustedProxies must be a comma-separated list of CIDR ranges or IPs: %w", err)
+	}
+	if _, ok := profileBatchSizeCaps[cfg.Profile]; !ok && cfg.Profile != "" && cfg.Profile != ProfileFast {
+		return fmt.Errorf("Profile must be one of '%s', '%s' or '%s'. Got '%s'", ProfileFast, ProfileSafe, ProfileReaders, cfg.Profile)
+	}
 
 	return nil
 }
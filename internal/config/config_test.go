@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -24,6 +25,35 @@ func TestLoad(t *testing.T) {
 		AverageLogSizeMB: defaultAverageLogSizeMB,
 		Verbose:          defaultVerbose,
 		CreateIndexes:    defaultCreateIndexes,
+		Normalize:        defaultNormalize,
+		Dedupe:           defaultDedupe,
+		Source:           defaultSource,
+		RollupInterval:   defaultRollupInterval,
+		FullTextSearch:   defaultFullTextSearch,
+		Regex:            defaultRegex,
+		Provenance:       defaultProvenance,
+		Partition:        defaultPartition,
+		Profile:          defaultProfile,
+		MultiRowInsert:   defaultMultiRowInsert,
+		PreserveOrder:    defaultPreserveOrder,
+		Strict:           defaultStrict,
+		ParseAgents:      defaultParseAgents,
+		AgentRules:       defaultAgentRules,
+		GeoIPFilePath:    defaultGeoIPFilePath,
+		ASNFilePath:      defaultASNFilePath,
+		ParseIPs:         defaultParseIPs,
+		ForwardedHeader:  defaultForwardedHeader,
+		TrustedProxies:   defaultTrustedProxies,
+		DecodeURLs:       defaultDecodeURLs,
+		ParamsTable:      defaultParamsTable,
+		RouteTemplates:   defaultRouteTemplates,
+		RouteRules:       defaultRouteRules,
+		ParseReferers:    defaultParseReferers,
+		Sites:            defaultSites,
+		DurationUnit:     defaultDurationUnit,
+		ClassifyBots:     defaultClassifyBots,
+		CrawlerRanges:    defaultCrawlerRanges,
+		BotRate:          defaultBotRate,
 	}
 
 	if !reflect.DeepEqual(cfg, expected) {
@@ -41,6 +71,35 @@ func TestLoad_Flags(t *testing.T) {
 		"-avgLogSize=750",
 		"-v",
 		"-i",
+		"-normalize",
+		"-dedupe",
+		"-source=web1",
+		"-rollup=1h",
+		"-fts",
+		"-regex=^(.*)$",
+		"-provenance",
+		"-partition=month",
+		"-profile=safe",
+		"-multiRowInsert",
+		"-preserveOrder",
+		"-strict",
+		"-parseAgents",
+		"-agentRules=regexes.yaml",
+		"-geoipDB=GeoLite2-City.mmdb",
+		"-asnDB=GeoLite2-ASN.mmdb",
+		"-parseIPs",
+		"-forwardedHeader=x-forwarded-for",
+		"-trustedProxies=10.0.0.0/8,192.168.1.1",
+		"-decodeURLs",
+		"-paramsTable",
+		"-routeTemplates",
+		"-routeRules=routes.yaml",
+		"-parseReferers",
+		"-sites=example.com,www.example.com",
+		"-durationUnit=s",
+		"-classifyBots",
+		"-crawlerRanges=google=googlebot.json",
+		"-botRate=60",
 	}
 
 	cfg, err := Load(fs, args)
@@ -56,6 +115,35 @@ func TestLoad_Flags(t *testing.T) {
 		AverageLogSizeMB: 750,
 		Verbose:          true,
 		CreateIndexes:    true,
+		Normalize:        true,
+		Dedupe:           true,
+		Source:           "web1",
+		RollupInterval:   time.Hour,
+		FullTextSearch:   true,
+		Regex:            "^(.*)$",
+		Provenance:       true,
+		Partition:        PartitionMonth,
+		Profile:          ProfileSafe,
+		MultiRowInsert:   true,
+		PreserveOrder:    true,
+		Strict:           true,
+		ParseAgents:      true,
+		AgentRules:       "regexes.yaml",
+		GeoIPFilePath:    "GeoLite2-City.mmdb",
+		ASNFilePath:      "GeoLite2-ASN.mmdb",
+		ParseIPs:         true,
+		ForwardedHeader:  "x-forwarded-for",
+		TrustedProxies:   "10.0.0.0/8,192.168.1.1",
+		DecodeURLs:       true,
+		ParamsTable:      true,
+		RouteTemplates:   true,
+		RouteRules:       "routes.yaml",
+		ParseReferers:    true,
+		Sites:            "example.com,www.example.com",
+		DurationUnit:     DurationSeconds,
+		ClassifyBots:     true,
+		CrawlerRanges:    "google=googlebot.json",
+		BotRate:          60,
 	}
 
 	if !reflect.DeepEqual(cfg, expected) {
@@ -83,6 +171,35 @@ func TestLoad_TwoArgs(t *testing.T) {
 		AverageLogSizeMB: defaultAverageLogSizeMB,
 		Verbose:          defaultVerbose,
 		CreateIndexes:    defaultCreateIndexes,
+		Normalize:        defaultNormalize,
+		Dedupe:           defaultDedupe,
+		Source:           defaultSource,
+		RollupInterval:   defaultRollupInterval,
+		FullTextSearch:   defaultFullTextSearch,
+		Regex:            defaultRegex,
+		Provenance:       defaultProvenance,
+		Partition:        defaultPartition,
+		Profile:          defaultProfile,
+		MultiRowInsert:   defaultMultiRowInsert,
+		PreserveOrder:    defaultPreserveOrder,
+		Strict:           defaultStrict,
+		ParseAgents:      defaultParseAgents,
+		AgentRules:       defaultAgentRules,
+		GeoIPFilePath:    defaultGeoIPFilePath,
+		ASNFilePath:      defaultASNFilePath,
+		ParseIPs:         defaultParseIPs,
+		ForwardedHeader:  defaultForwardedHeader,
+		TrustedProxies:   defaultTrustedProxies,
+		DecodeURLs:       defaultDecodeURLs,
+		ParamsTable:      defaultParamsTable,
+		RouteTemplates:   defaultRouteTemplates,
+		RouteRules:       defaultRouteRules,
+		ParseReferers:    defaultParseReferers,
+		Sites:            defaultSites,
+		DurationUnit:     defaultDurationUnit,
+		ClassifyBots:     defaultClassifyBots,
+		CrawlerRanges:    defaultCrawlerRanges,
+		BotRate:          defaultBotRate,
 	}
 
 	if !reflect.DeepEqual(cfg, expected) {
@@ -110,6 +227,35 @@ func TestLoad_OneArg(t *testing.T) {
 		AverageLogSizeMB: defaultAverageLogSizeMB,
 		Verbose:          defaultVerbose,
 		CreateIndexes:    defaultCreateIndexes,
+		Normalize:        defaultNormalize,
+		Dedupe:           defaultDedupe,
+		Source:           defaultSource,
+		RollupInterval:   defaultRollupInterval,
+		FullTextSearch:   defaultFullTextSearch,
+		Regex:            defaultRegex,
+		Provenance:       defaultProvenance,
+		Partition:        defaultPartition,
+		Profile:          defaultProfile,
+		MultiRowInsert:   defaultMultiRowInsert,
+		PreserveOrder:    defaultPreserveOrder,
+		Strict:           defaultStrict,
+		ParseAgents:      defaultParseAgents,
+		AgentRules:       defaultAgentRules,
+		GeoIPFilePath:    defaultGeoIPFilePath,
+		ASNFilePath:      defaultASNFilePath,
+		ParseIPs:         defaultParseIPs,
+		ForwardedHeader:  defaultForwardedHeader,
+		TrustedProxies:   defaultTrustedProxies,
+		DecodeURLs:       defaultDecodeURLs,
+		ParamsTable:      defaultParamsTable,
+		RouteTemplates:   defaultRouteTemplates,
+		RouteRules:       defaultRouteRules,
+		ParseReferers:    defaultParseReferers,
+		Sites:            defaultSites,
+		DurationUnit:     defaultDurationUnit,
+		ClassifyBots:     defaultClassifyBots,
+		CrawlerRanges:    defaultCrawlerRanges,
+		BotRate:          defaultBotRate,
 	}
 
 	if !reflect.DeepEqual(cfg, expected) {
@@ -192,6 +338,153 @@ func TestValidate(t *testing.T) {
 			expectError: true,
 			errorMsg:    "BatchSize must be greater than 0. Got 0",
 		},
+		{
+			name: "invalid RollupInterval",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				RollupInterval:   1500 * time.Millisecond,
+			},
+			expectError: true,
+			errorMsg:    "RollupInterval must be a whole number of seconds. Got 1.5s",
+		},
+		{
+			name: "invalid Partition",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				Partition:        "week",
+			},
+			expectError: true,
+			errorMsg:    "Partition must be either 'day' or 'month'. Got 'week'",
+		},
+		{
+			name: "AgentRules without ParseAgents",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				AgentRules:       "regexes.yaml",
+			},
+			expectError: true,
+			errorMsg:    "AgentRules requires ParseAgents to be enabled",
+		},
+		{
+			name: "RouteRules without RouteTemplates",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				RouteRules:       "routes.yaml",
+			},
+			expectError: true,
+			errorMsg:    "RouteRules requires RouteTemplates to be enabled",
+		},
+		{
+			name: "Sites without ParseReferers",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				Sites:            "example.com",
+			},
+			expectError: true,
+			errorMsg:    "Sites requires ParseReferers to be enabled",
+		},
+		{
+			name: "Invalid DurationUnit",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				DurationUnit:     "min",
+			},
+			expectError: true,
+			errorMsg:    "DurationUnit must be one of 'us', 'ms' or 's'. Got 'min'",
+		},
+		{
+			name: "ClassifyBots without ParseAgents",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				ClassifyBots:     true,
+				BotRate:          120,
+			},
+			expectError: true,
+			errorMsg:    "ClassifyBots requires ParseAgents to be enabled",
+		},
+		{
+			name: "CrawlerRanges without ClassifyBots",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				CrawlerRanges:    "google=googlebot.json",
+			},
+			expectError: true,
+			errorMsg:    "CrawlerRanges requires ClassifyBots to be enabled",
+		},
+		{
+			name: "Invalid BotRate",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				ParseAgents:      true,
+				ClassifyBots:     true,
+			},
+			expectError: true,
+			errorMsg:    "BotRate must be greater than 0. Got 0",
+		},
+		{
+			name: "ForwardedHeader without TrustedProxies",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				ForwardedHeader:  "x-forwarded-for",
+			},
+			expectError: true,
+			errorMsg:    "ForwardedHeader and TrustedProxies must be set together",
+		},
+		{
+			name: "invalid ForwardedHeader",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				ForwardedHeader:  "via",
+				TrustedProxies:   "10.0.0.0/8",
+			},
+			expectError: true,
+			errorMsg:    "ForwardedHeader must be one of 'x-forwarded-for', 'x-real-ip' or 'forwarded'. Got 'via'",
+		},
+		{
+			name: "invalid TrustedProxies",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				ForwardedHeader:  "forwarded",
+				TrustedProxies:   "10.0.0.0/33",
+			},
+			expectError: true,
+			errorMsg:    `TrustedProxies must be a comma-separated list of CIDR ranges or IPs: invalid trusted proxy range '10.0.0.0/33': netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`,
+		},
+		{
+			name: "invalid Profile",
+			cfg: Config{
+				BatchSize:        100,
+				MaxMemoryUsageMB: 100,
+				AverageLogSizeMB: 0.001,
+				Profile:          "slow",
+			},
+			expectError: true,
+			errorMsg:    "Profile must be one of 'fast', 'safe' or 'readers'. Got 'slow'",
+		},
 	}
 
 	for _, tt := range tests {
@@ -207,6 +500,32 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestLoad_ProfileBatchSizeCap(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{args: []string{"-profile=fast", "-batchSize=50000"}, expected: 50000},
+		{args: []string{"-profile=safe", "-batchSize=50000"}, expected: 10000},
+		{args: []string{"-profile=readers"}, expected: 1000},
+		{args: []string{"-profile=readers", "-batchSize=500"}, expected: 500},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+		cfg, err := Load(fs, tt.args)
+		if err != nil {
+			t.Errorf("error loading config: %v", err)
+			continue
+		}
+
+		if cfg.BatchSize != tt.expected {
+			t.Errorf("%v: expected batch size %d, got %d", tt.args, tt.expected, cfg.BatchSize)
+		}
+	}
+}
+
 func TestLoad_InvalidFlag(t *testing.T) {
 	fs := flag.NewFlagSet("test", flag.ContinueOnError)
 	args := []string{
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MergeConfig holds the parameters of the merge command, which combines DBs created on multiple nodes into a single one.
+type MergeConfig struct {
+	InputDBFilePaths []string
+	Nodes            []string
+	OutputDBFilePath string
+	Verbose          bool
+}
+
+// LoadMerge attempts to parse the flags and args of the merge command. The args are the paths of the DBs to be merged, and the flags may be placed in between them. The node of each input DB defaults to the name of its file without the extension, or to the name of its directory if the file names are not unique (e.g. web1/logs.db and web2/logs.db). The nodes must be unique. If successful, it returns the merge config. Otherwise, an error is returned.
+func LoadMerge(fs *flag.FlagSet, args []string) (*MergeConfig, error) {
+	cfg := &MergeConfig{}
+
+	var output, nodes string
+
+	fs.StringVar(&output, "o", "", "Defines the file path of the merged DB. The file must not exist yet.")
+	fs.StringVar(&nodes, "nodes", "", "Defines the comma-separated names of the nodes the input DBs come from, in the order of the input DBs. Defaults to the names of the input DB files without the extension, or to the names of their directories if the file names are not unique.")
+	fs.BoolVar(&cfg.Verbose, "v", defaultVerbose, "Defines whether verbose mode should be used.")
+
+	// The flag package stops parsing at the first arg, therefore the parsing continues after each arg to allow flags after the input DBs
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("error parsing flags: %v", err)
+		}
+
+		if fs.NArg() == 0 {
+			break
+		}
+
+		path, err := cleanDBFilePath(fs.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		cfg.InputDBFilePaths = append(cfg.InputDBFilePaths, path)
+
+		args = fs.Args()[1:]
+	}
+
+	if len(cfg.InputDBFilePaths) == 0 {
+		return nil, fmt.Errorf("at least one input DB file path must be provided")
+	}
+
+	if output == "" {
+		return nil, fmt.Errorf("the output DB file path must be provided")
+	}
+
+	var err error
+	if cfg.OutputDBFilePath, err = cleanDBFilePath(output); err != nil {
+		return nil, err
+	}
+
+	for _, path := range cfg.InputDBFilePaths {
+		if path == cfg.OutputDBFilePath {
+			return nil, fmt.Errorf("the output DB file path must differ from the input ones")
+		}
+	}
+
+	if nodes == "" {
+		if cfg.Nodes = defaultNodes(cfg.InputDBFilePaths); cfg.Nodes == nil {
+			return nil, fmt.Errorf("the nodes cannot be told apart by the names of the input DB files or their directories, they must be provided by the -nodes flag")
+		}
+		return cfg, nil
+	}
+
+	cfg.Nodes = strings.Split(nodes, ",")
+	if len(cfg.Nodes) != len(cfg.InputDBFilePaths) {
+		return nil, fmt.Errorf("the number of nodes (%d) must match the number of input DBs (%d)", len(cfg.Nodes), len(cfg.InputDBFilePaths))
+	}
+
+	if !unique(cfg.Nodes) {
+		return nil, fmt.Errorf("the nodes must be unique. Got %s", nodes)
+	}
+
+	return cfg, nil
+}
+
+// defaultNodes returns the names of the provided DB files without the extension. If they are not unique, the names of the directories of the files are returned instead, or nil if those are not unique either.
+func defaultNodes(paths []string) []string {
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		base := filepath.Base(path)
+		names = append(names, strings.TrimSuffix(base, filepath.Ext(base)))
+	}
+
+	if unique(names) {
+		return names
+	}
+
+	dirs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil
+		}
+		dirs = append(dirs, filepath.Base(filepath.Dir(abs)))
+	}
+
+	if unique(dirs) {
+		return dirs
+	}
+
+	return nil
+}
+
+// unique reports whether none of the provided names repeats.
+func unique(names []string) bool {
+	sorted := slices.Clone(names)
+	slices.Sort(sorted)
+
+	return len(slices.Compact(sorted)) == len(names)
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestLoadMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected *MergeConfig
+	}{
+		{
+			name: "flags after inputs",
+			args: []string{"web1/logs.db", "./web2.db", "-o", "all.db"},
+			expected: &MergeConfig{
+				InputDBFilePaths: []string{"web1/logs.db", "web2.db"},
+				Nodes:            []string{"logs", "web2"},
+				OutputDBFilePath: "all.db",
+			},
+		},
+		{
+			name: "same file names",
+			args: []string{"web1/logs.db", "web2/logs.db", "-o", "all.db"},
+			expected: &MergeConfig{
+				InputDBFilePaths: []string{"web1/logs.db", "web2/logs.db"},
+				Nodes:            []string{"web1", "web2"},
+				OutputDBFilePath: "all.db",
+			},
+		},
+		{
+			name: "explicit nodes",
+			args: []string{"-o=all.db", "-nodes=web1,web2", "-v", "a.db", "b.db"},
+			expected: &MergeConfig{
+				InputDBFilePaths: []string{"a.db", "b.db"},
+				Nodes:            []string{"web1", "web2"},
+				OutputDBFilePath: "all.db",
+				Verbose:          true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+			cfg, err := LoadMerge(fs, tt.args)
+			if err != nil {
+				t.Errorf("error loading config: %v", err)
+			}
+
+			if !reflect.DeepEqual(cfg, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadMerge_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing inputs", args: []string{"-o", "all.db"}},
+		{name: "missing output", args: []string{"a.db", "b.db"}},
+		{name: "output is input", args: []string{"a.db", "-o", "./a.db"}},
+		{name: "node count mismatch", args: []string{"-nodes=web1", "a.db", "b.db", "-o", "all.db"}},
+		{name: "duplicate nodes", args: []string{"-nodes=web1,web1", "a.db", "b.db", "-o", "all.db"}},
+		{name: "same file and directory names", args: []string{"a/web/logs.db", "b/web/logs.db", "-o", "all.db"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+			if _, err := LoadMerge(fs, tt.args); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PruneConfig holds the parameters of the prune command, which deletes old logs from a DB to keep its size bounded.
+type PruneConfig struct {
+	DBFilePath string
+	OlderThan  time.Duration
+	MaxRows    int64
+	ChunkSize  int
+	Vacuum     string
+	Verbose    bool
+}
+
+const (
+	defaultPruneChunkSize = 10000
+
+	// VacuumFull rebuilds the whole DB file after pruning, which requires up to twice its size of free disk space and locks the DB until finished
+	VacuumFull = "full"
+	// VacuumIncremental only releases the pages freed by pruning, which requires a DB created with incremental auto-vacuum
+	VacuumIncremental = "incremental"
+)
+
+// LoadPrune attempts to parse the flags and args of the prune command. The only arg is the optional DB file path. At least one of the age and row count limits must be set. If successful, it returns the prune config. Otherwise, an error is returned.
+func LoadPrune(fs *flag.FlagSet, args []string) (*PruneConfig, error) {
+	cfg := &PruneConfig{
+		DBFilePath: defaultDbFilePath,
+	}
+
+	var olderThan string
+
+	fs.StringVar(&olderThan, "olderThan", "", "Defines the age (e.g. 30d or 12h) of the logs to be deleted, based on their timestamps.")
+	fs.Int64Var(&cfg.MaxRows, "maxRows", 0, "Defines the maximum number of logs to be kept. The oldest inserted logs beyond this count are deleted. Not applied if set to 0.")
+	fs.IntVar(&cfg.ChunkSize, "chunkSize", defaultPruneChunkSize, "Defines the number of logs deleted in a single transaction. Smaller chunks lock the DB for shorter periods of time.")
+	fs.StringVar(&cfg.Vacuum, "vacuum", "", "Defines whether the freed space should be released after pruning, either by rebuilding the DB file (full) or by releasing the freed pages only (incremental).")
+	fs.BoolVar(&cfg.Verbose, "v", defaultVerbose, "Defines whether verbose mode should be used.")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("error parsing flags: %v", err)
+	}
+
+	parsedArgs := fs.Args()
+	if len(parsedArgs) >= 1 {
+		path, err := cleanDBFilePath(parsedArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		cfg.DBFilePath = path
+	}
+
+	var err error
+
+	if cfg.OlderThan, err = parseAge(olderThan); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxRows < 0 {
+		return nil, fmt.Errorf("MaxRows must not be negative. Got %d", cfg.MaxRows)
+	}
+
+	if cfg.OlderThan == 0 && cfg.MaxRows == 0 {
+		return nil, fmt.Errorf("either the age or the maximum number of logs to be kept must be provided")
+	}
+
+	if cfg.ChunkSize < 1 {
+		return nil, fmt.Errorf("ChunkSize must be at least 1. Got %d", cfg.ChunkSize)
+	}
+
+	if cfg.Vacuum != "" && cfg.Vacuum != VacuumFull && cfg.Vacuum != VacuumIncremental {
+		return nil, fmt.Errorf("Vacuum must be either '%s' or '%s'. Got '%s'", VacuumFull, VacuumIncremental, cfg.Vacuum)
+	}
+
+	return cfg, nil
+}
+
+// parseAge parses an age given either as a duration (e.g. 12h) or as a number of days (e.g. 30d). An empty string results in a zero age, i.e. no limit.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	var age time.Duration
+
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age '%s', expected a duration (e.g. 12h) or a number of days (e.g. 30d)", s)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age '%s', expected a duration (e.g. 12h) or a number of days (e.g. 30d)", s)
+		}
+		age = d
+	}
+
+	if age <= 0 {
+		return 0, fmt.Errorf("invalid age '%s', it must be positive", s)
+	}
+
+	return age, nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadPrune(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	args := []string{"-olderThan=30d", "-maxRows=1000", "-chunkSize=500", "-vacuum=incremental", "test.db"}
+
+	cfg, err := LoadPrune(fs, args)
+	if err != nil {
+		t.Errorf("error loading config: %v", err)
+	}
+
+	expected := &PruneConfig{
+		DBFilePath: "test.db",
+		OlderThan:  30 * 24 * time.Hour,
+		MaxRows:    1000,
+		ChunkSize:  500,
+		Vacuum:     VacuumIncremental,
+	}
+
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+}
+
+func TestLoadPrune_Defaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	cfg, err := LoadPrune(fs, []string{"-olderThan=12h"})
+	if err != nil {
+		t.Errorf("error loading config: %v", err)
+	}
+
+	expected := &PruneConfig{
+		DBFilePath: defaultDbFilePath,
+		OlderThan:  12 * time.Hour,
+		ChunkSize:  defaultPruneChunkSize,
+	}
+
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+}
+
+func TestLoadPrune_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing limits", args: []string{"test.db"}},
+		{name: "invalid DB file path", args: []string{"-maxRows=10", "."}},
+		{name: "invalid age", args: []string{"-olderThan=month"}},
+		{name: "negative age", args: []string{"-olderThan=-1h"}},
+		{name: "negative max rows", args: []string{"-maxRows=-1"}},
+		{name: "invalid chunk size", args: []string{"-maxRows=10", "-chunkSize=0"}},
+		{name: "invalid vacuum", args: []string{"-maxRows=10", "-vacuum=always"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+			if _, err := LoadPrune(fs, tt.args); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
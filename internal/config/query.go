@@ -0,0 +1,75 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// QueryConfig holds the parameters of the query command, which lists the logs matching a set of filters.
+type QueryConfig struct {
+	DBFilePath string
+	CIDR       netip.Prefix
+	From       time.Time
+	To         time.Time
+	StatusMin  uint16
+	StatusMax  uint16
+	Limit      int
+	Verbose    bool
+}
+
+// LoadQuery attempts to parse the flags and args of the query command. The only arg is the optional DB file path. At least the CIDR range filter must be set. If successful, it returns the query config. Otherwise, an error is returned.
+func LoadQuery(fs *flag.FlagSet, args []string) (*QueryConfig, error) {
+	cfg := &QueryConfig{
+		DBFilePath: defaultDbFilePath,
+	}
+
+	var cidr, from, to, status string
+
+	fs.StringVar(&cidr, "cidr", "", "Defines the CIDR range (e.g. 10.0.0.0/8 or 2001:db8::/32) the IPs of the logs to be listed must belong to.")
+	fs.StringVar(&from, "from", "", "Defines the time (RFC 3339 or YYYY-MM-DD, UTC if no offset is given) from which logs should be listed.")
+	fs.StringVar(&to, "to", "", "Defines the time (RFC 3339 or YYYY-MM-DD, UTC if no offset is given) until which logs should be listed.")
+	fs.StringVar(&status, "status", "", "Defines the response code (e.g. 404) or the response code class (e.g. 4xx) of the logs to be listed.")
+	fs.IntVar(&cfg.Limit, "limit", defaultSearchLimit, "Defines the maximum number of logs to be returned. No limit is applied if set to 0.")
+	fs.BoolVar(&cfg.Verbose, "v", defaultVerbose, "Defines whether verbose mode should be used.")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("error parsing flags: %v", err)
+	}
+
+	parsedArgs := fs.Args()
+	if len(parsedArgs) >= 1 {
+		path, err := cleanDBFilePath(parsedArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		cfg.DBFilePath = path
+	}
+
+	if cidr == "" {
+		return nil, fmt.Errorf("the CIDR range must be provided")
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR range '%s': %w", cidr, err)
+	}
+	cfg.CIDR = prefix.Masked()
+
+	if cfg.From, err = parseTime(from); err != nil {
+		return nil, err
+	}
+	if cfg.To, err = parseTime(to); err != nil {
+		return nil, err
+	}
+	if cfg.StatusMin, cfg.StatusMax, err = parseStatus(status); err != nil {
+		return nil, err
+	}
+
+	if cfg.Limit < 0 {
+		return nil, fmt.Errorf("Limit must not be negative. Got %d", cfg.Limit)
+	}
+
+	return cfg, nil
+}
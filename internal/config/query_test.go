@@ -0,0 +1,56 @@
+package config
+
+import (
+	"flag"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadQuery(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	args := []string{"--cidr=10.1.2.3/8", "-from=2024-01-01", "-status=404", "-limit=0", "test.db"}
+
+	cfg, err := LoadQuery(fs, args)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	// The host bits of the range are cleared
+	expected := &QueryConfig{
+		DBFilePath: "test.db",
+		CIDR:       netip.MustParsePrefix("10.0.0.0/8"),
+		From:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		StatusMin:  404,
+		StatusMax:  404,
+	}
+
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+}
+
+func TestLoadQuery_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing CIDR range", args: []string{"test.db"}},
+		{name: "invalid CIDR range", args: []string{"-cidr=10.0.0.0"}},
+		{name: "invalid DB file path", args: []string{"-cidr=10.0.0.0/8", "."}},
+		{name: "invalid time", args: []string{"-cidr=10.0.0.0/8", "-to=tomorrow"}},
+		{name: "invalid status", args: []string{"-cidr=10.0.0.0/8", "-status=abc"}},
+		{name: "negative limit", args: []string{"-cidr=10.0.0.0/8", "-limit=-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+			if _, err := LoadQuery(fs, tt.args); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchConfig holds the parameters of the search command, which runs full-text search queries against a DB created with full-text search enabled.
+type SearchConfig struct {
+	Query      string
+	DBFilePath string
+	From       time.Time
+	To         time.Time
+	StatusMin  uint16
+	StatusMax  uint16
+	Limit      int
+	Verbose    bool
+}
+
+const (
+	defaultSearchLimit = 100
+)
+
+var (
+	// timeLayouts lists the layouts accepted by time filters
+	timeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+)
+
+// LoadSearch attempts to parse the flags and args of the search command. The first arg is the full-text search query (in the SQLite FTS5 query syntax), the second one is the optional DB file path. If successful, it returns the search config. Otherwise, an error is returned.
+func LoadSearch(fs *flag.FlagSet, args []string) (*SearchConfig, error) {
+	cfg := &SearchConfig{
+		DBFilePath: defaultDbFilePath,
+	}
+
+	var from, to, status string
+
+	fs.StringVar(&from, "from", "", "Defines the time (RFC 3339 or YYYY-MM-DD, UTC if no offset is given) from which logs should be searched.")
+	fs.StringVar(&to, "to", "", "Defines the time (RFC 3339 or YYYY-MM-DD, UTC if no offset is given) until which logs should be searched.")
+	fs.StringVar(&status, "status", "", "Defines the response code (e.g. 404) or the response code class (e.g. 4xx) of the logs to be searched.")
+	fs.IntVar(&cfg.Limit, "limit", defaultSearchLimit, "Defines the maximum number of logs to be returned. No limit is applied if set to 0.")
+	fs.BoolVar(&cfg.Verbose, "v", defaultVerbose, "Defines whether verbose mode should be used.")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("error parsing flags: %v", err)
+	}
+
+	parsedArgs := fs.Args()
+	if len(parsedArgs) < 1 || strings.TrimSpace(parsedArgs[0]) == "" {
+		return nil, fmt.Errorf("the search query must be provided")
+	}
+	cfg.Query = parsedArgs[0]
+
+	if len(parsedArgs) >= 2 {
+		path, err := cleanDBFilePath(parsedArgs[1])
+		if err != nil {
+			return nil, err
+		}
+		cfg.DBFilePath = path
+	}
+
+	var err error
+
+	if cfg.From, err = parseTime(from); err != nil {
+		return nil, err
+	}
+	if cfg.To, err = parseTime(to); err != nil {
+		return nil, err
+	}
+	if cfg.StatusMin, cfg.StatusMax, err = parseStatus(status); err != nil {
+		return nil, err
+	}
+
+	if cfg.Limit < 0 {
+		return nil, fmt.Errorf("Limit must not be negative. Got %d", cfg.Limit)
+	}
+
+	return cfg, nil
+}
+
+// cleanDBFilePath cleans the provided DB file path and checks that it is valid.
+func cleanDBFilePath(path string) (string, error) {
+	cleanPath := filepath.Clean(path)
+	if cleanPath == "." {
+		return "", fmt.Errorf("the provided DB file path is invalid")
+	}
+	return cleanPath, nil
+}
+
+// parseTime parses a time filter in one of the accepted layouts. An empty string results in a zero time, i.e. no filter.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time '%s', expected RFC 3339 or YYYY-MM-DD", s)
+}
+
+// parseStatus parses a response code (e.g. 404) or a response code class (e.g. 4xx) filter into the range of matching response codes. An empty string results in a zero range, i.e. no filter.
+func parseStatus(s string) (uint16, uint16, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") {
+		class, err := strconv.ParseUint(s[:1], 10, 16)
+		if err == nil && class >= 1 && class <= 5 {
+			return uint16(class * 100), uint16(class*100 + 99), nil
+		}
+	}
+
+	code, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, fmt.Errorf("invalid status '%s', expected a response code (e.g. 404) or a response code class (e.g. 4xx)", s)
+	}
+
+	return uint16(code), uint16(code), nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadSearch(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	args := []string{"-from=2024-01-01", "-to=2024-02-01T12:00:00+01:00", "-status=4xx", "-limit=10", "wp-login", "test.db"}
+
+	cfg, err := LoadSearch(fs, args)
+	if err != nil {
+		t.Errorf("error loading config: %v", err)
+	}
+
+	expected := &SearchConfig{
+		Query:      "wp-login",
+		DBFilePath: "test.db",
+		From:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2024, 2, 1, 12, 0, 0, 0, time.FixedZone("", 3600)),
+		StatusMin:  400,
+		StatusMax:  499,
+		Limit:      10,
+	}
+
+	if !cfg.From.Equal(expected.From) || !cfg.To.Equal(expected.To) {
+		t.Errorf("expected time range %s - %s, got %s - %s", expected.From, expected.To, cfg.From, cfg.To)
+	}
+
+	cfg.From, cfg.To = expected.From, expected.To
+
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+}
+
+func TestLoadSearch_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing query", args: []string{}},
+		{name: "invalid DB file path", args: []string{"query", "."}},
+		{name: "invalid time", args: []string{"-from=yesterday", "query"}},
+		{name: "invalid status", args: []string{"-status=6xx", "query"}},
+		{name: "negative limit", args: []string{"-limit=-1", "query"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+			if _, err := LoadSearch(fs, tt.args); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		min    uint16
+		max    uint16
+	}{
+		{status: "", min: 0, max: 0},
+		{status: "404", min: 404, max: 404},
+		{status: "5xx", min: 500, max: 599},
+		{status: "2XX", min: 200, max: 299},
+	}
+
+	for _, tt := range tests {
+		min, max, err := parseStatus(tt.status)
+		if err != nil {
+			t.Errorf("did not expect error, got %v", err)
+		}
+		if min != tt.min || max != tt.max {
+			t.Errorf("expected %d-%d for %q, got %d-%d", tt.min, tt.max, tt.status, min, max)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// SessionsConfig holds the parameters of the sessions command, which groups the stored logs into visitor sessions.
+type SessionsConfig struct {
+	DBFilePath string
+	Gap        time.Duration
+	Verbose    bool
+}
+
+const (
+	defaultSessionGap = 30 * time.Minute
+)
+
+// LoadSessions attempts to parse the flags and args of the sessions command. The only arg is the optional DB file path. If successful, it returns the sessions config. Otherwise, an error is returned.
+func LoadSessions(fs *flag.FlagSet, args []string) (*SessionsConfig, error) {
+	cfg := &SessionsConfig{
+		DBFilePath: defaultDbFilePath,
+	}
+
+	fs.DurationVar(&cfg.Gap, "gap", defaultSessionGap, "Defines the inactivity gap (e.g. 30m) after which the next request of the same client IP and user agent starts a new session.")
+	fs.BoolVar(&cfg.Verbose, "v", defaultVerbose, "Defines whether verbose mode should be used.")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("error parsing flags: %v", err)
+	}
+
+	parsedArgs := fs.Args()
+	if len(parsedArgs) >= 1 {
+		path, err := cleanDBFilePath(parsedArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		cfg.DBFilePath = path
+	}
+
+	// The timestamps of the logs have a resolution of seconds
+	if cfg.Gap < time.Second {
+		return nil, fmt.Errorf("Gap must be at least 1s. Got %s", cfg.Gap)
+	}
+
+	return cfg, nil
+}
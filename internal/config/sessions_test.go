@@ -0,0 +1,67 @@
+package config
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadSessions(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	cfg, err := LoadSessions(fs, []string{"-gap=15m", "-v", "test.db"})
+	if err != nil {
+		t.Errorf("error loading config: %v", err)
+	}
+
+	expected := &SessionsConfig{
+		DBFilePath: "test.db",
+		Gap:        15 * time.Minute,
+		Verbose:    true,
+	}
+
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+}
+
+func TestLoadSessions_Defaults(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	cfg, err := LoadSessions(fs, nil)
+	if err != nil {
+		t.Errorf("error loading config: %v", err)
+	}
+
+	expected := &SessionsConfig{
+		DBFilePath: defaultDbFilePath,
+		Gap:        defaultSessionGap,
+	}
+
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+}
+
+func TestLoadSessions_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "invalid DB file path", args: []string{"."}},
+		{name: "invalid gap", args: []string{"-gap=30"}},
+		{name: "zero gap", args: []string{"-gap=0s"}},
+		{name: "sub-second gap", args: []string{"-gap=500ms"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+
+			if _, err := LoadSessions(fs, tt.args); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
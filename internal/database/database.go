@@ -3,9 +3,13 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"maps"
+	"strings"
 	"sync"
 
+	"github.com/ncruces/go-sqlite3"
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
 	"go.vxn.dev/xilt/internal/config"
@@ -14,15 +18,15 @@ import (
 )
 
 const (
-	createLogTableScript = `CREATE TABLE "logs" ("ID" INTEGER NOT NULL, "IP"	TEXT, "Identity" TEXT,"UserID"	TEXT, "Time"	TEXT, "TimestampUTC" TEXT , "Method"	TEXT, "Route"	TEXT, "Params"	TEXT,  "ResponseCode"	INTEGER, "BytesSent"	INTEGER, "Referer" TEXT, "Agent" TEXT, PRIMARY KEY("id" AUTOINCREMENT));`
-	insertLogStatement   = "INSERT INTO logs (IP, Identity, UserID, Time, TimestampUTC, Method, Route, Params, ResponseCode, BytesSent, Referer, Agent) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
-	createIndexesScript  = `
-	CREATE INDEX idx_logs_ip ON logs(IP);
-	CREATE INDEX idx_logs_ts ON logs(TimestampUTC);
-	CREATE INDEX idx_logs_method ON logs(Method);
-	CREATE INDEX idx_logs_route ON logs(Route);
-	CREATE INDEX idx_logs_referer ON logs(Referer);
-	CREATE INDEX idx_logs_ts_ip ON logs(TimestampUTC, IP);
+	// The time bucket view exposes epoch-based buckets (the start of the minute/hour/day in unix seconds) and the weekday (0 = Sunday) of each log for time-series queries
+	createViewsScript = `
+	CREATE VIEW log_time_buckets AS SELECT
+		logs.*,
+		(TimestampUnix / 60) * 60 AS Minute,
+		(TimestampUnix / 3600) * 3600 AS Hour,
+		(TimestampUnix / 86400) * 86400 AS Day,
+		CAST(strftime('%w', TimestampUnix, 'unixepoch') AS INTEGER) AS Weekday
+	FROM logs;
 	`
 )
 
@@ -30,6 +34,24 @@ type db struct {
 	conn   *sql.DB
 	logger logger.Logger
 	config *config.Config
+	schema *schema
+	dims   *dimensionCache
+	stats  Stats
+	runID  int64
+	// partitions holds the names of the existing partitions and nextID the ID assigned to the next partitioned log
+	partitions map[string]bool
+	nextID     int64
+	// paramLogs holds the inserted logs of the batch being written whose query parameters are to be stored
+	paramLogs []rowLog
+	// window is released once a batch is written if the input order is preserved
+	window <-chan struct{}
+}
+
+// Stats holds the counts of logs processed by the write routine. Rejected logs violate the constraints of the strict log table.
+type Stats struct {
+	Inserted   int64
+	Duplicates int64
+	Rejected   int64
 }
 
 type Database interface {
@@ -40,16 +62,20 @@ type Database interface {
 
 // NewDB returns a new instance of a DB struct initialized with the provided logger and config.
 func NewDB(l logger.Logger, c *config.Config) *db {
+	s := newSchema(c)
+
 	return &db{
 		logger: l,
 		config: c,
+		schema: s,
+		dims:   newDimensionCache(s.dimensions()),
 	}
 }
 
-// Init initializes the DB struct. It attempts to connect to the database, configure it to better optimize write performance and creates a table for storage of parsed logs.
+// Init initializes the DB struct. It attempts to connect to the database, configure it according to the durability profile and creates a table for storage of parsed logs.
 func (d *db) Init() error {
 	// Connect to DB
-	db, err := sql.Open("sqlite3", "file:"+d.config.DBFilePath)
+	db, err := sql.Open("sqlite3", dataSourceName(d.config.DBFilePath, profileParams(d.config.Profile)...))
 	if err != nil {
 		return fmt.Errorf("failed to connect to DB: %w", err)
 	}
@@ -65,30 +91,144 @@ func (d *db) Init() error {
 		return originalErr
 	}
 
-	// Optimize SQLite for writes
-	_, err = d.conn.Exec("PRAGMA synchronous = OFF;")
+	// The PRAGMAs of the durability profile are applied when connecting, therefore the connection is checked right away
+	if err := d.conn.Ping(); err != nil {
+		return handleFailure(fmt.Errorf("failed to connect to DB: %w", err))
+	}
+
+	// Logs can only be appended to an existing DB if duplicates are skipped, as re-imported logs would be stored twice otherwise
+	if d.config.Dedupe {
+		existing, err := d.existingSchema()
+		if err != nil {
+			return handleFailure(err)
+		}
+
+		if existing != nil {
+			// The sessions are not configured but assigned after ingestion, the appended logs have no session until the sessions command is run again
+			d.schema.sessions = existing.sessions
+			d.schema.build()
+
+			// The parsing routines prepare the logs according to the config, therefore the existing schema has to match it
+			if !maps.Equal(existing.meta(), d.schema.meta()) {
+				return handleFailure(fmt.Errorf("the schema options of the existing DB %v do not match the configured ones %v", existing.meta(), d.schema.meta()))
+			}
+
+			if err := d.loadPartitions(); err != nil {
+				return handleFailure(err)
+			}
+
+			d.logger.Debug("existing DB initialized...")
+
+			return nil
+		}
+	}
+
+	if err := d.create(); err != nil {
+		return handleFailure(err)
+	}
+
+	if err := d.loadPartitions(); err != nil {
+		return handleFailure(err)
+	}
+
+	d.logger.Debug("DB initialized...")
+
+	return nil
+}
+
+// create creates the tables and views of the schema in an empty DB and stores the schema options in the meta table.
+func (d *db) create() error {
+	// Allow the space freed by pruning logs to be released without rebuilding the whole DB file. It only takes effect before the first table is created and not at all in WAL mode, therefore the durability profiles set it when connecting as well.
+	_, err := d.conn.Exec("PRAGMA auto_vacuum = INCREMENTAL;")
 	if err != nil {
-		return handleFailure(fmt.Errorf("failed to set PRAGMA synchronous: %w", err))
+		return fmt.Errorf("failed to set PRAGMA auto_vacuum: %w", err)
 	}
 
-	// WAL mode has issues with saving big (50k+) batch sizes and creating indexes on tables containing large amounts of logs, therefore the default rollback journal method is used instead.
-	// https://github.com/Tencent/wcdb/issues/243
-	// _, err = d.conn.Exec("PRAGMA journal_mode = WAL;")
-	// if err != nil {
-	// 	return handleFailure(fmt.Errorf("failed to set journal_mode: %w", err))
-	// }
+	// Create a new table to store parsed logs (and the lookup tables if using the normalized schema)
+	_, err = d.conn.Exec(d.schema.createScript())
+	if err != nil {
+		return fmt.Errorf("failed to create log table: %w", err)
+	}
 
-	// Create a new table to store parsed logs
-	_, err = d.conn.Exec(createLogTableScript)
+	// Store the schema mode so that it can be reconstructed by other commands working with the DB
+	if err := d.schema.writeMeta(d.conn); err != nil {
+		return err
+	}
+
+	// Create helper views for time-series queries
+	_, err = d.conn.Exec(createViewsScript)
 	if err != nil {
-		return handleFailure(fmt.Errorf("failed to create log table: %w", err))
+		return fmt.Errorf("failed to create views: %w", err)
 	}
 
-	d.logger.Debug("DB initialized...")
+	return nil
+}
+
+// Open connects to an existing database created by xilt and loads its schema, so that the stored logs can be queried or maintained.
+func (d *db) Open() error {
+	// The DB must not be created if it does not exist
+	db, err := sql.Open("sqlite3", dataSourceName(d.config.DBFilePath, "mode=rw"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to DB: %w", err)
+	}
+
+	d.conn = db
+
+	existing, err := d.existingSchema()
+	if err == nil && existing == nil {
+		err = fmt.Errorf("the DB was not created by xilt")
+	}
+	if err != nil {
+		if closeErr := d.conn.Close(); closeErr != nil {
+			d.logger.Println("failed to close DB after error: ", closeErr)
+		}
+		return err
+	}
+
+	d.schema = existing
+	d.dims = newDimensionCache(existing.dimensions())
+
+	if err := d.loadPartitions(); err != nil {
+		if closeErr := d.conn.Close(); closeErr != nil {
+			d.logger.Println("failed to close DB after error: ", closeErr)
+		}
+		return err
+	}
+
+	d.logger.Debug("DB opened...")
 
 	return nil
 }
 
+// existingSchema returns the schema of the DB if it was already initialized by a previous run. If the DB is empty, nil is returned.
+func (d *db) existingSchema() (*schema, error) {
+	var count int
+	if err := d.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'meta';").Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to query sqlite_master: %w", err)
+	}
+
+	if count == 0 {
+		return nil, nil
+	}
+
+	return loadSchema(d.conn)
+}
+
+// dataSourceName returns the data source name of the DB file at the provided path with the provided URI parameters appended.
+func dataSourceName(path string, params ...string) string {
+	dsn := "file:" + path
+
+	for _, param := range params {
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + param
+		} else {
+			dsn += "?" + param
+		}
+	}
+
+	return dsn
+}
+
 // Close closes the connection of the DB struct if it is not nil.
 func (d *db) Close() error {
 	if d.conn == nil {
@@ -98,56 +238,276 @@ func (d *db) Close() error {
 }
 
 // InsertBatch inserts a batch of logs from an output channel to the database. It is optimized for concurrent usage in goroutines.
-func (d *db) InsertBatch(parsedLogChan <-chan []parser.Log, wg *sync.WaitGroup) {
+func (d *db) InsertBatch(parsedLogChan <-chan parser.Batch, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for parsedLogBatch := range parsedLogChan {
-		d.logger.Debug("write routine beginning insert")
-		tx, err := d.conn.Begin()
+	if d.config.PreserveOrder {
+		d.insertInOrder(parsedLogChan)
+		return
+	}
+
+	for batch := range parsedLogChan {
+		d.insert(&batch)
+	}
+}
+
+// SetWindow sets the window bounding the number of batches in flight in the order-preserving mode. A slot of the window is released each time a batch is written.
+func (d *db) SetWindow(window <-chan struct{}) {
+	d.window = window
+}
+
+// insertInOrder writes the batches in the order of their sequence numbers, holding back the batches received before their predecessors. The number of held back batches is bounded by the window shared with the reader.
+func (d *db) insertInOrder(parsedLogChan <-chan parser.Batch) {
+	pending := make(map[int64]parser.Batch)
+	var next int64
+
+	for batch := range parsedLogChan {
+		pending[batch.Seq] = batch
+
+		for {
+			batch, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			next++
+
+			d.insert(&batch)
+
+			if d.window != nil {
+				<-d.window
+			}
+		}
+	}
+
+	if len(pending) > 0 {
+		d.logger.Printf("write routine failed to insert %d batches following missing batch %d", len(pending), next)
+	}
+}
+
+// insert writes a single batch and updates the stats. A batch which fails to be written is logged and skipped.
+func (d *db) insert(batch *parser.Batch) {
+	d.logger.Debug("write routine beginning insert")
+
+	batchStats, err := d.writeBatch(batch)
+	if err != nil {
+		d.logger.Printf("write routine failed to insert batch: %v", err)
+		// The IDs of lookup values inserted in the rolled back transaction are no longer valid
+		d.dims.reset()
+		// Neither are the partitions created and the IDs assigned in it
+		if err := d.loadPartitions(); err != nil {
+			d.logger.Printf("write routine failed to reload partitions: %v", err)
+		}
+		return
+	}
+
+	d.stats.Inserted += batchStats.Inserted
+	d.stats.Duplicates += batchStats.Duplicates
+	d.stats.Rejected += batchStats.Rejected
+
+	d.logger.Debugf("write routine successfully inserted batch of %d logs", batchStats.Inserted)
+}
+
+// writeBatch writes a batch of logs along with their query parameters and merges its rollups in a single transaction, which is rolled back if any of the writes fail.
+func (d *db) writeBatch(batch *parser.Batch) (stats Stats, err error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer func() {
 		if err != nil {
-			d.logger.Printf("write routine failed to start transaction: %v", err)
-			continue
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				d.logger.Printf("write routine failed to roll back transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	d.paramLogs = d.paramLogs[:0]
+
+	if err := d.dims.prepare(tx); err != nil {
+		return stats, fmt.Errorf("failed to prepare lookup statements: %w", err)
+	}
+	defer d.dims.close()
+
+	if d.config.MultiRowInsert {
+		err = d.insertMultiRow(tx, batch, &stats)
+	} else {
+		err = d.insertRows(tx, batch, &stats)
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	if len(d.paramLogs) > 0 {
+		if err := insertParams(tx, d.paramLogs); err != nil {
+			return stats, err
+		}
+	}
+
+	if len(batch.IPInfo) > 0 {
+		if err := upsertIPInfo(tx, batch.IPInfo); err != nil {
+			return stats, err
+		}
+	}
+
+	if batch.Rollups != nil {
+		if err := d.mergeRollups(tx, batch.Rollups); err != nil {
+			return stats, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return stats, nil
+}
+
+// insertRows inserts the logs of a batch one by one within the provided transaction, updating the stats. Skipped duplicates are subtracted from the batch's rollups.
+func (d *db) insertRows(tx *sql.Tx, batch *parser.Batch, stats *Stats) error {
+	statements := make(map[string]*sql.Stmt)
+	defer func() {
+		for _, stmt := range statements {
+			stmt.Close()
 		}
+	}()
 
-		stmt, err := tx.Prepare(insertLogStatement)
+	for i := range batch.Logs {
+		parsedLog := &batch.Logs[i]
+
+		table, err := d.tableFor(tx, parsedLog.TimestampUnix)
 		if err != nil {
-			d.logger.Printf("write routine failed to prepare statement: %v", err)
-			if err = tx.Rollback(); err != nil {
-				d.logger.Printf("write routine failed to roll back transaction: %v", err)
+			return err
+		}
+
+		stmt, ok := statements[table]
+		if !ok {
+			if stmt, err = tx.Prepare(d.schema.insertStatement(table)); err != nil {
+				return fmt.Errorf("failed to prepare statement: %w", err)
 			}
+			statements[table] = stmt
+		}
+
+		args, err := d.logArgs(parsedLog)
+		if err != nil {
+			return err
+		}
+
+		if d.schema.assignsIDs() {
+			args = append([]any{d.nextID}, args...)
+		}
+
+		res, err := stmt.Exec(args...)
+		if isRejected(err) {
+			d.reject(parsedLog, err, batch.Rollups, stats)
 			continue
 		}
 
-		for _, parsedLog := range parsedLogBatch {
-			_, err := stmt.Exec(parsedLog.IP, parsedLog.Identity, parsedLog.User, parsedLog.Time, parsedLog.TimestampUTC, parsedLog.Method, parsedLog.Route, parsedLog.Params, parsedLog.ResponseCode, parsedLog.BytesSent, parsedLog.Referer, parsedLog.Agent)
-			if err != nil {
-				d.logger.Printf("write routine failed to insert: %v", err)
-				if err = tx.Rollback(); err != nil {
-					d.logger.Printf("write routine failed to roll back transaction: %v", err)
-				}
-				break
-			}
+		inserted, err := stats.count(res, err)
+		if err != nil {
+			return fmt.Errorf("failed to insert: %w", err)
 		}
 
-		if err := stmt.Close(); err != nil {
-			d.logger.Println("write routine failed to close statement: %v", err)
+		if inserted && d.schema.params {
+			d.paramLogs = append(d.paramLogs, rowLog{Log: parsedLog, id: d.nextID})
 		}
 
-		if err := tx.Commit(); err != nil {
-			d.logger.Println("write routine failed to commit transaction: %v", err)
-		} else {
-			d.logger.Debugf("write routine successfully inserted batch of %d logs", len(parsedLogBatch))
+		if inserted && d.schema.assignsIDs() {
+			d.nextID++
+		}
+
+		// Skipped duplicates must not be counted in the rollups
+		if !inserted && batch.Rollups != nil {
+			batch.Rollups.Subtract(parsedLog, d.schema.rollupInterval)
+		}
+	}
+
+	return nil
+}
+
+// isRejected reports whether an insert failed because the log violates the column types or the check constraints of the strict log table.
+func isRejected(err error) bool {
+	return errors.Is(err, sqlite3.CONSTRAINT_CHECK) || errors.Is(err, sqlite3.CONSTRAINT_DATATYPE)
+}
+
+// reject counts a log violating the constraints of the strict log table as rejected and subtracts it from the batch's rollups.
+func (d *db) reject(l *parser.Log, err error, rollups parser.Rollups, stats *Stats) {
+	d.logger.Printf("write routine rejected log from line %d: %v", l.LineNumber, err)
+
+	stats.Rejected++
+
+	if rollups != nil {
+		rollups.Subtract(l, d.schema.rollupInterval)
+	}
+}
+
+// Stats returns the counts of logs processed by the write routine. It must not be called before the write routine is finished.
+func (d *db) Stats() Stats {
+	return d.stats
+}
+
+// count updates the stats with the result of a single insert and reports whether the log was inserted. A log which was not inserted without an error being returned was ignored as a duplicate.
+func (s *Stats) count(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if affected == 0 {
+		s.Duplicates++
+		return false, nil
+	}
+
+	s.Inserted++
+	return true, nil
+}
+
+// logArgs returns the values of a parsed log in the order of the schema's columns, resolving the values stored in lookup tables to their IDs.
+func (d *db) logArgs(l *parser.Log) ([]any, error) {
+	args := make([]any, 0, len(d.schema.columns))
+	r := &record{Log: l, runID: d.runID}
+
+	for _, c := range d.schema.columns {
+		value := c.value(r)
+
+		if d.schema.normalized && c.dimension != "" {
+			id, err := d.dims.id(c.dimension, value.(string))
+			if err != nil {
+				return nil, err
+			}
+			value = id
 		}
+
+		args = append(args, value)
 	}
+
+	return args, nil
 }
 
-// CreateIndexes creates indexes on the log table if enabled in the config provided to the DB struct.
+// CreateIndexes creates indexes on the log table (or all partitions) and the query parameters table if enabled in the config provided to the DB struct.
 func (d *db) CreateIndexes() error {
 	if d.config.CreateIndexes {
 		d.logger.Println("creating table indexes...")
-		if _, err := d.conn.Exec(createIndexesScript); err != nil {
+		tables, err := d.logTables()
+		if err != nil {
 			return err
 		}
+		for _, table := range tables {
+			if _, err := d.conn.Exec(d.schema.indexesScript(table)); err != nil {
+				return err
+			}
+		}
+		if d.schema.params {
+			if _, err := d.conn.Exec(createParamsIndexScript); err != nil {
+				return err
+			}
+		}
 		d.logger.Println("table indexes created...")
 		return nil
 	}
@@ -1,9 +1,13 @@
 package database
 
 import (
+	"fmt"
+	"math"
 	"os"
-	"sync"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"go.vxn.dev/xilt/internal/config"
 	"go.vxn.dev/xilt/internal/parser"
@@ -219,44 +223,36 @@ func TestDB_InsertBatch(t *testing.T) {
 	}
 
 	parsedLogs := []parser.Log{{
-		IP:           "127.0.0.1",
-		Identity:     "user-identifier",
-		User:         "frank",
-		Time:         "10/Oct/2000:13:55:36 -0700",
-		TimestampUTC: "2000-10-10T20:55:36Z",
-		Method:       "GET",
-		Route:        "/apache_pb.gif",
-		Params:       "param1=test",
-		ResponseCode: 200,
-		BytesSent:    2326,
-		Referer:      "referrer",
-		Agent:        "agent",
+		IP:            "127.0.0.1",
+		Identity:      "user-identifier",
+		User:          "frank",
+		Time:          "10/Oct/2000:13:55:36 -0700",
+		TimestampUTC:  "2000-10-10T20:55:36Z",
+		TimestampUnix: 971211336,
+		Method:        "GET",
+		Route:         "/apache_pb.gif",
+		Params:        "param1=test",
+		ResponseCode:  200,
+		BytesSent:     2326,
+		Referer:       "referrer",
+		Agent:         "agent",
 	}, {
-		IP:           "127.0.0.1",
-		Identity:     "user-identifier",
-		User:         "frank",
-		Time:         "10/Oct/2000:13:55:36 -0700",
-		TimestampUTC: "2000-10-10T20:55:36Z",
-		Method:       "GET",
-		Route:        "/apache_pb.gif",
-		Params:       "param1=test",
-		ResponseCode: 200,
-		BytesSent:    2326,
-		Referer:      "referrer",
-		Agent:        "agent",
+		IP:            "127.0.0.1",
+		Identity:      "user-identifier",
+		User:          "frank",
+		Time:          "10/Oct/2000:13:55:36 -0700",
+		TimestampUTC:  "2000-10-10T20:55:36Z",
+		TimestampUnix: 971211336,
+		Method:        "GET",
+		Route:         "/apache_pb.gif",
+		Params:        "param1=test",
+		ResponseCode:  200,
+		BytesSent:     2326,
+		Referer:       "referrer",
+		Agent:         "agent",
 	}}
 
-	parsedLogChan := make(chan []parser.Log)
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	go db.InsertBatch(parsedLogChan, &wg)
-
-	parsedLogChan <- parsedLogs
-	close(parsedLogChan)
-
-	wg.Wait()
+	insertTestBatches(db, parser.Batch{Logs: parsedLogs})
 
 	rows, err := db.conn.Query("SELECT id FROM logs;")
 	if err != nil {
@@ -279,3 +275,586 @@ func TestDB_InsertBatch(t *testing.T) {
 		t.Errorf("expected 2 log IDs to be returned from DB, got %d ID(s) instead", len(logIDs))
 	}
 }
+
+func TestDB_InsertBatchBytesSent(t *testing.T) {
+	db := NewDB(&mockLogger{}, &config.Config{
+		DBFilePath:     filepath.Join(t.TempDir(), "bytes.db"),
+		RollupInterval: time.Hour,
+		Strict:         true,
+	})
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	// Values over 4 GiB up to the maximum stored by SQLite
+	expected := []uint64{53687091200, math.MaxInt64 - 53687091200}
+
+	parsedLogs := make([]parser.Log, 0, len(expected))
+	for _, bytesSent := range expected {
+		parsedLogs = append(parsedLogs, parser.Log{
+			TimestampUnix: 971211336,
+			Method:        "GET",
+			Route:         "/video.mp4",
+			ResponseCode:  200,
+			BytesSent:     bytesSent,
+		})
+	}
+
+	rollups := make(parser.Rollups)
+	for i := range parsedLogs {
+		rollups.Add(&parsedLogs[i], 3600)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: parsedLogs, Rollups: rollups})
+
+	rows, err := db.conn.Query("SELECT BytesSent FROM logs ORDER BY ID;")
+	if err != nil {
+		t.Fatalf("error querying logs: %v", err)
+	}
+	defer rows.Close()
+
+	actual := make([]uint64, 0, len(expected))
+
+	for rows.Next() {
+		var bytesSent uint64
+		if err := rows.Scan(&bytesSent); err != nil {
+			t.Errorf("error scanning log rows: %v", err)
+		}
+		actual = append(actual, bytesSent)
+	}
+
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	var bytes int64
+	if err := db.conn.QueryRow("SELECT Bytes FROM rollup_routes WHERE Route = '/video.mp4';").Scan(&bytes); err != nil {
+		t.Errorf("error querying rollups: %v", err)
+	}
+	if bytes != math.MaxInt64 {
+		t.Errorf("expected the rollups to sum %d bytes, got %d", int64(math.MaxInt64), bytes)
+	}
+}
+
+func TestDB_TimeBucketView(t *testing.T) {
+	config := &config.Config{
+		Verbose:    false,
+		DBFilePath: ":memory:?cache=shared",
+	}
+
+	logger := &mockLogger{}
+
+	db := NewDB(logger, config)
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: []parser.Log{{
+		IP:            "127.0.0.1",
+		Time:          "10/Oct/2000:13:55:36 -0700",
+		TimestampUTC:  "2000-10-10T20:55:36Z",
+		TimestampUnix: 971211336,
+		Method:        "GET",
+		Route:         "/apache_pb.gif",
+		ResponseCode:  200,
+	}}})
+
+	var minute, hour, day, weekday int64
+
+	row := db.conn.QueryRow("SELECT Minute, Hour, Day, Weekday FROM log_time_buckets;")
+	if err := row.Scan(&minute, &hour, &day, &weekday); err != nil {
+		t.Errorf("error querying time buckets: %v", err)
+	}
+
+	// 2000-10-10T20:55:36Z is a Tuesday
+	if minute != 971211300 || hour != 971208000 || day != 971136000 || weekday != 2 {
+		t.Errorf("unexpected buckets: minute=%d, hour=%d, day=%d, weekday=%d", minute, hour, day, weekday)
+	}
+}
+
+func TestDB_InsertBatchNormalized(t *testing.T) {
+	config := &config.Config{
+		Verbose:    false,
+		DBFilePath: ":memory:?cache=shared",
+		Normalize:  true,
+	}
+
+	logger := &mockLogger{}
+
+	db := NewDB(logger, config)
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+
+	parsedLogs := []parser.Log{{
+		IP:      "127.0.0.1",
+		Method:  "GET",
+		Route:   "/apache_pb.gif",
+		Referer: "referrer",
+		Agent:   "agent",
+	}, {
+		IP:      "127.0.0.2",
+		Method:  "GET",
+		Route:   "/apache_pb.gif",
+		Referer: "referrer",
+		Agent:   "other agent",
+	}}
+
+	insertTestBatches(db, parser.Batch{Logs: parsedLogs})
+
+	var agents, routes int
+
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM agents;").Scan(&agents); err != nil {
+		t.Errorf("error querying agents: %v", err)
+	}
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM routes;").Scan(&routes); err != nil {
+		t.Errorf("error querying routes: %v", err)
+	}
+
+	if agents != 2 || routes != 1 {
+		t.Errorf("expected 2 agents and 1 route to be stored, got %d agents and %d routes", agents, routes)
+	}
+
+	rows, err := db.conn.Query("SELECT IP, Route, Referer, Agent FROM logs ORDER BY ID;")
+	if err != nil {
+		t.Errorf("error querying logs view: %v", err)
+	}
+	defer rows.Close()
+
+	actual := make([]parser.Log, 0)
+
+	for rows.Next() {
+		var l parser.Log
+
+		if err := rows.Scan(&l.IP, &l.Route, &l.Referer, &l.Agent); err != nil {
+			t.Errorf("error scanning log rows: %v", err)
+		}
+
+		actual = append(actual, l)
+	}
+
+	for i, l := range actual {
+		if l.IP != parsedLogs[i].IP || l.Route != parsedLogs[i].Route || l.Referer != parsedLogs[i].Referer || l.Agent != parsedLogs[i].Agent {
+			t.Errorf("expected %+v, got %+v", parsedLogs[i], l)
+		}
+	}
+
+	if len(actual) != 2 {
+		t.Errorf("expected 2 logs to be returned from the logs view, got %d", len(actual))
+	}
+}
+
+func TestDB_InsertBatchProvenance(t *testing.T) {
+	config := &config.Config{
+		Verbose:    false,
+		DBFilePath: ":memory:?cache=shared",
+		Normalize:  true,
+		Provenance: true,
+	}
+
+	logger := &mockLogger{}
+
+	db := NewDB(logger, config)
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+
+	parsedLogs := []parser.Log{{
+		IP:         "127.0.0.1",
+		SourceFile: "access.log",
+		LineNumber: 1,
+		ByteOffset: 0,
+	}, {
+		IP:         "127.0.0.2",
+		SourceFile: "access.log",
+		LineNumber: 3,
+		ByteOffset: 142,
+	}}
+
+	insertTestBatches(db, parser.Batch{Logs: parsedLogs})
+
+	var sourceFiles int
+
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM source_files;").Scan(&sourceFiles); err != nil {
+		t.Errorf("error querying source files: %v", err)
+	}
+
+	if sourceFiles != 1 {
+		t.Errorf("expected 1 source file to be stored, got %d", sourceFiles)
+	}
+
+	rows, err := db.conn.Query("SELECT SourceFile, LineNumber, ByteOffset FROM logs ORDER BY ID;")
+	if err != nil {
+		t.Errorf("error querying logs view: %v", err)
+	}
+	defer rows.Close()
+
+	actual := make([]parser.Log, 0)
+
+	for rows.Next() {
+		var l parser.Log
+
+		if err := rows.Scan(&l.SourceFile, &l.LineNumber, &l.ByteOffset); err != nil {
+			t.Errorf("error scanning log rows: %v", err)
+		}
+
+		actual = append(actual, l)
+	}
+
+	if len(actual) != 2 {
+		t.Fatalf("expected 2 logs to be returned from the logs view, got %d", len(actual))
+	}
+
+	for i, l := range actual {
+		if l.SourceFile != parsedLogs[i].SourceFile || l.LineNumber != parsedLogs[i].LineNumber || l.ByteOffset != parsedLogs[i].ByteOffset {
+			t.Errorf("expected %+v, got %+v", parsedLogs[i], l)
+		}
+	}
+}
+
+func TestDB_InsertBatchPreserveOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		partition string
+		multiRow  bool
+	}{
+		{name: "flat"},
+		{name: "partitioned", partition: config.PartitionDay},
+		{name: "partitioned multi-row", partition: config.PartitionDay, multiRow: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB(&mockLogger{}, &config.Config{
+				DBFilePath:     filepath.Join(t.TempDir(), "order.db"),
+				Provenance:     true,
+				Partition:      tt.partition,
+				MultiRowInsert: tt.multiRow,
+				PreserveOrder:  true,
+			})
+			defer db.Close()
+
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			// The logs of each batch alternate between two days, so that the IDs have to follow the line order across the partitions
+			batches := make([]parser.Batch, 4)
+			for i := range batches {
+				batches[i].Seq = int64(i)
+				for j := range 3 {
+					line := int64(i*3 + j + 1)
+					batches[i].Logs = append(batches[i].Logs, parser.Log{
+						IP:            "127.0.0.1",
+						TimestampUnix: 971211336 + line%2*86400,
+						SourceFile:    "access.log",
+						LineNumber:    line,
+					})
+				}
+			}
+
+			// The slots of the window are taken by the reader before the batches are pushed
+			window := make(chan struct{}, len(batches))
+			for range batches {
+				window <- struct{}{}
+			}
+			db.SetWindow(window)
+
+			insertTestBatches(db, batches[2], batches[0], batches[3], batches[1])
+
+			if len(window) != 0 {
+				t.Errorf("expected all slots of the window to be released, %d left", len(window))
+			}
+
+			rows, err := db.conn.Query("SELECT LineNumber FROM logs ORDER BY ID;")
+			if err != nil {
+				t.Fatalf("error querying logs view: %v", err)
+			}
+			defer rows.Close()
+
+			var expected int64 = 1
+
+			for rows.Next() {
+				var line int64
+				if err := rows.Scan(&line); err != nil {
+					t.Errorf("error scanning log rows: %v", err)
+				}
+
+				if line != expected {
+					t.Errorf("expected line %d, got %d", expected, line)
+				}
+				expected++
+			}
+
+			if expected != 13 {
+				t.Errorf("expected 12 logs, got %d", expected-1)
+			}
+		})
+	}
+}
+
+func TestDB_InsertBatchStrict(t *testing.T) {
+	for _, multiRow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("multiRow=%t", multiRow), func(t *testing.T) {
+			db := NewDB(&mockLogger{}, &config.Config{
+				DBFilePath:     filepath.Join(t.TempDir(), "strict.db"),
+				Dedupe:         true,
+				RollupInterval: time.Hour,
+				Strict:         true,
+				MultiRowInsert: multiRow,
+			})
+			defer db.Close()
+
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			var strict bool
+			if err := db.conn.QueryRow("SELECT strict FROM pragma_table_list WHERE name = 'logs';").Scan(&strict); err != nil {
+				t.Errorf("error querying table list: %v", err)
+			}
+			if !strict {
+				t.Error("expected the logs table to be strict")
+			}
+
+			parsedLogs := []parser.Log{{
+				TimestampUnix: 971211336,
+				Method:        "GET",
+				Route:         "/valid",
+				ResponseCode:  200,
+				Hash:          []byte("valid"),
+			}, {
+				TimestampUnix: 971211336,
+				Method:        "GET",
+				Route:         "/valid",
+				ResponseCode:  200,
+				Hash:          []byte("valid"),
+			}, {
+				TimestampUnix: 971211336,
+				Method:        "GET",
+				Route:         "/status",
+				ResponseCode:  0,
+				Hash:          []byte("status"),
+			}, {
+				TimestampUnix: 971211336,
+				Method:        "FETCH",
+				Route:         "/method",
+				ResponseCode:  200,
+				Hash:          []byte("method"),
+			}}
+
+			rollups := make(parser.Rollups)
+			for i := range parsedLogs {
+				rollups.Add(&parsedLogs[i], 3600)
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: parsedLogs, Rollups: rollups})
+
+			// The violating logs are rejected instead of failing the batch or being skipped as duplicates
+			if expected := (Stats{Inserted: 1, Duplicates: 1, Rejected: 2}); db.Stats() != expected {
+				t.Errorf("expected %+v, got %+v", expected, db.Stats())
+			}
+
+			var requests int64
+			if err := db.conn.QueryRow("SELECT SUM(Requests) FROM rollup_routes;").Scan(&requests); err != nil {
+				t.Errorf("error querying rollups: %v", err)
+			}
+			if requests != 1 {
+				t.Errorf("expected the rollups to count 1 request, got %d", requests)
+			}
+		})
+	}
+}
+
+func TestDB_InsertBatchBotClass(t *testing.T) {
+	db := NewDB(&mockLogger{}, &config.Config{
+		DBFilePath:   filepath.Join(t.TempDir(), "botclass.db"),
+		ParseAgents:  true,
+		ClassifyBots: true,
+		Strict:       true,
+	})
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: []parser.Log{
+		{TimestampUnix: 971211336, Method: "GET", Route: "/", ResponseCode: 200, BotClass: "verified-bot"},
+		{TimestampUnix: 971211337, Method: "GET", Route: "/", ResponseCode: 200, BotClass: "human"},
+		{TimestampUnix: 971211338, Method: "GET", Route: "/", ResponseCode: 200, BotClass: "robot"},
+	}})
+
+	// Unknown classes violate the check of the strict table
+	if expected := (Stats{Inserted: 2, Rejected: 1}); db.Stats() != expected {
+		t.Errorf("expected %+v, got %+v", expected, db.Stats())
+	}
+
+	var classes string
+	if err := db.conn.QueryRow("SELECT GROUP_CONCAT(BotClass, ',') FROM (SELECT BotClass FROM logs ORDER BY ID);").Scan(&classes); err != nil {
+		t.Errorf("error querying bot classes: %v", err)
+	}
+	if classes != "verified-bot,human" {
+		t.Errorf("expected verified-bot,human, got %s", classes)
+	}
+}
+
+func TestDB_InsertBatchDedupe(t *testing.T) {
+	config := &config.Config{
+		Verbose:    false,
+		DBFilePath: "testdedupe.db",
+		Dedupe:     true,
+	}
+
+	// Remove the created test DB file after the test is finished
+	defer func() {
+		if err := os.Remove("./testdedupe.db"); err != nil {
+			t.Errorf("error deleting test DB file: %v", err)
+		}
+	}()
+
+	logger := &mockLogger{}
+
+	parsedLogs := []parser.Log{{
+		IP:    "127.0.0.1",
+		Route: "/apache_pb.gif",
+		Hash:  []byte("first"),
+	}, {
+		IP:    "127.0.0.1",
+		Route: "/apache_pb.gif",
+		Hash:  []byte("first"),
+	}, {
+		IP:    "127.0.0.1",
+		Route: "/apache_pb.gif",
+		Hash:  []byte("second"),
+	}}
+
+	// Import the same batch twice, the second run appending to the DB created by the first one
+	expected := []Stats{{Inserted: 2, Duplicates: 1}, {Inserted: 0, Duplicates: 3}}
+
+	for i := range expected {
+		db := NewDB(logger, config)
+
+		if err := db.Init(); err != nil {
+			t.Errorf("Init failed: %v", err)
+		}
+
+		insertTestBatches(db, parser.Batch{Logs: parsedLogs})
+
+		if stats := db.Stats(); stats != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], stats)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing DB: %v", err)
+		}
+	}
+}
+
+func TestDB_InsertBatchRollups(t *testing.T) {
+	config := &config.Config{
+		Verbose:        false,
+		DBFilePath:     ":memory:?cache=shared",
+		Dedupe:         true,
+		RollupInterval: time.Hour,
+	}
+
+	logger := &mockLogger{}
+
+	db := NewDB(logger, config)
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+
+	parsedLogs := []parser.Log{{
+		IP:            "127.0.0.1",
+		TimestampUnix: 971211336,
+		Route:         "/apache_pb.gif",
+		ResponseCode:  200,
+		BytesSent:     2326,
+		Hash:          []byte("first"),
+	}, {
+		IP:            "127.0.0.1",
+		TimestampUnix: 971211336,
+		Route:         "/apache_pb.gif",
+		ResponseCode:  200,
+		BytesSent:     2326,
+		Hash:          []byte("first"),
+	}, {
+		IP:            "127.0.0.1",
+		TimestampUnix: 971211400,
+		Route:         "/apache_pb.gif",
+		ResponseCode:  500,
+		BytesSent:     10,
+		Hash:          []byte("second"),
+	}}
+
+	rollups := make(parser.Rollups)
+	for i := range parsedLogs {
+		rollups.Add(&parsedLogs[i], 3600)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: parsedLogs, Rollups: rollups})
+
+	var bucket, requests, bytes, status2xx, status5xx int64
+
+	row := db.conn.QueryRow("SELECT Bucket, Requests, Bytes, Status2xx, Status5xx FROM rollup_routes WHERE Route = '/apache_pb.gif';")
+	if err := row.Scan(&bucket, &requests, &bytes, &status2xx, &status5xx); err != nil {
+		t.Errorf("error querying rollups: %v", err)
+	}
+
+	// The duplicate log must not be counted
+	if bucket != 971208000 || requests != 2 || bytes != 2336 || status2xx != 1 || status5xx != 1 {
+		t.Errorf("unexpected rollup: bucket=%d, requests=%d, bytes=%d, status2xx=%d, status5xx=%d", bucket, requests, bytes, status2xx, status5xx)
+	}
+}
+
+func TestDB_Open(t *testing.T) {
+	cfg := &config.Config{
+		Verbose:    false,
+		DBFilePath: "testopen.db",
+		Normalize:  true,
+	}
+
+	// Remove the created test DB file after the test is finished
+	defer func() {
+		if err := os.Remove("./testopen.db"); err != nil {
+			t.Errorf("error deleting test DB file: %v", err)
+		}
+	}()
+
+	logger := &mockLogger{}
+
+	// Opening a DB which does not exist must fail instead of creating it
+	db := NewDB(logger, cfg)
+	if err := db.Open(); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	db = NewDB(logger, cfg)
+	if err := db.Init(); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+	db.Close()
+
+	// The schema of an opened DB is loaded from the DB instead of the config
+	db = NewDB(logger, &config.Config{DBFilePath: "testopen.db"})
+	if err := db.Open(); err != nil {
+		t.Errorf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if !db.schema.normalized {
+		t.Error("expected the normalized schema to be loaded from the DB")
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// maxCachedDimensionValues limits the number of values cached per lookup table to keep the memory usage of the write routine bounded
+	maxCachedDimensionValues = 1 << 20
+
+	upsertDimensionStatement = `INSERT INTO %s (Value) VALUES (?) ON CONFLICT(Value) DO UPDATE SET Value = excluded.Value RETURNING ID`
+)
+
+// dimensionCache interns strings stored in lookup tables of the normalized schema. It is only ever used by the single write routine, therefore it does not need to be synchronized.
+type dimensionCache struct {
+	ids        map[string]map[string]int64
+	statements map[string]*sql.Stmt
+}
+
+// newDimensionCache returns an empty cache for the provided lookup tables.
+func newDimensionCache(dimensions []string) *dimensionCache {
+	ids := make(map[string]map[string]int64, len(dimensions))
+	for _, dimension := range dimensions {
+		ids[dimension] = make(map[string]int64)
+	}
+
+	return &dimensionCache{
+		ids: ids,
+	}
+}
+
+// prepare prepares the upsert statements of all lookup tables within the provided transaction.
+func (c *dimensionCache) prepare(tx *sql.Tx) error {
+	c.statements = make(map[string]*sql.Stmt, len(c.ids))
+
+	for dimension := range c.ids {
+		stmt, err := tx.Prepare(fmt.Sprintf(upsertDimensionStatement, dimension))
+		if err != nil {
+			c.close()
+			return err
+		}
+		c.statements[dimension] = stmt
+	}
+
+	return nil
+}
+
+// close closes the statements prepared for the current transaction.
+func (c *dimensionCache) close() {
+	for _, stmt := range c.statements {
+		stmt.Close()
+	}
+	c.statements = nil
+}
+
+// id returns the ID of the value in the provided lookup table, inserting the value if it is not stored yet.
+func (c *dimensionCache) id(dimension, value string) (int64, error) {
+	ids := c.ids[dimension]
+
+	if id, ok := ids[value]; ok {
+		return id, nil
+	}
+
+	var id int64
+	if err := c.statements[dimension].QueryRow(value).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to store value in %s: %w", dimension, err)
+	}
+
+	if len(ids) >= maxCachedDimensionValues {
+		clear(ids)
+	}
+	ids[value] = id
+
+	return id, nil
+}
+
+// reset drops all cached values. It has to be called after a transaction is rolled back, as the IDs of the values inserted in it are no longer valid.
+func (c *dimensionCache) reset() {
+	for _, ids := range c.ids {
+		clear(ids)
+	}
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// The percentiles are computed by the nearest-rank method using window functions only, so that the views work in any SQLite client. The rank of the pth percentile is the smallest one with rank * 100 >= p * n.
+	createDurationViewScript = `CREATE VIEW %[1]s AS
+	WITH ranked AS (
+		SELECT %[2]s, RequestTimeUs,
+			ROW_NUMBER() OVER (PARTITION BY %[2]s ORDER BY RequestTimeUs) AS Position,
+			COUNT(*) OVER (PARTITION BY %[2]s) AS Requests
+		FROM logs WHERE RequestTimeUs IS NOT NULL
+	)
+	SELECT %[2]s,
+		MAX(Requests) AS Requests,
+		CAST(AVG(RequestTimeUs) AS INTEGER) AS AvgUs,
+		MIN(CASE WHEN Position * 100 >= 50 * Requests THEN RequestTimeUs END) AS P50Us,
+		MIN(CASE WHEN Position * 100 >= 95 * Requests THEN RequestTimeUs END) AS P95Us,
+		MIN(CASE WHEN Position * 100 >= 99 * Requests THEN RequestTimeUs END) AS P99Us,
+		MAX(RequestTimeUs) AS MaxUs
+	FROM ranked GROUP BY %[2]s;`
+)
+
+// durationViewsScript returns the SQL script creating the views of the request time percentiles per route and, if the routes are templated, per route template.
+func (s *schema) durationViewsScript() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, createDurationViewScript+"\n", "route_response_times", "Route")
+
+	if s.routeTemplate {
+		fmt.Fprintf(&b, createDurationViewScript+"\n", "route_template_response_times", routeTemplateColumn.name)
+	}
+
+	return b.String()
+}
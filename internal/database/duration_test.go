@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+func TestDB_InsertBatchDurations(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{name: "flat", cfg: config.Config{DurationUnit: config.DurationMilliseconds}},
+		{name: "templated", cfg: config.Config{DurationUnit: config.DurationMilliseconds, RouteTemplates: true, MultiRowInsert: true}},
+		{name: "partitioned", cfg: config.Config{DurationUnit: config.DurationMilliseconds, Partition: config.PartitionDay, Normalize: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.DBFilePath = filepath.Join(t.TempDir(), "durations.db")
+
+			db := NewDB(&mockLogger{}, &cfg)
+			defer db.Close()
+
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			// 100 requests of /a taking 1..100 ms, 2 requests of /b, one of which is not timed, and an untimed /c
+			logs := make([]parser.Log, 0, 103)
+			for i := range 100 {
+				logs = append(logs, parser.Log{TimestampUnix: 971211336 + int64(i), Method: "GET", Route: "/a", RouteTemplate: "/a", ResponseCode: 200, RequestTimeUs: int64(100-i) * 1000, UpstreamTimeUs: -1})
+			}
+			logs = append(logs,
+				parser.Log{TimestampUnix: 971211336, Method: "GET", Route: "/b", RouteTemplate: "/b", ResponseCode: 200, RequestTimeUs: 2500, UpstreamTimeUs: 2000},
+				parser.Log{TimestampUnix: 971211337, Method: "GET", Route: "/b", RouteTemplate: "/b", ResponseCode: 200, RequestTimeUs: -1, UpstreamTimeUs: -1},
+				parser.Log{TimestampUnix: 971211338, Method: "GET", Route: "/c", RouteTemplate: "/c", ResponseCode: 200, RequestTimeUs: -1, UpstreamTimeUs: -1},
+			)
+
+			insertTestBatches(db, parser.Batch{Logs: logs})
+
+			var nulls int
+			if err := db.conn.QueryRow("SELECT COUNT(*) FROM logs WHERE RequestTimeUs IS NULL AND UpstreamTimeUs IS NULL;").Scan(&nulls); err != nil || nulls != 2 {
+				t.Errorf("expected 2 untimed logs, got %d (%v)", nulls, err)
+			}
+
+			var upstream sql.NullInt64
+			if err := db.conn.QueryRow("SELECT UpstreamTimeUs FROM logs WHERE RequestTimeUs = 2500;").Scan(&upstream); err != nil || upstream.Int64 != 2000 {
+				t.Errorf("expected upstream time 2000, got %v (%v)", upstream, err)
+			}
+
+			views := []string{"route_response_times"}
+			if cfg.RouteTemplates {
+				views = append(views, "route_template_response_times")
+			}
+
+			// Requests, average, p50, p95, p99 and maximum
+			expected := []string{
+				"/a 100 50500 50000 95000 99000 100000",
+				"/b 1 2500 2500 2500 2500 2500",
+			}
+
+			for _, view := range views {
+				rows, err := db.conn.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY 1;", view))
+				if err != nil {
+					t.Fatalf("error querying %s: %v", view, err)
+				}
+
+				actual := make([]string, 0)
+				for rows.Next() {
+					var route string
+					var requests, avg, p50, p95, p99, max int64
+					if err := rows.Scan(&route, &requests, &avg, &p50, &p95, &p99, &max); err != nil {
+						t.Fatalf("error scanning %s: %v", view, err)
+					}
+					actual = append(actual, fmt.Sprintf("%s %d %d %d %d %d %d", route, requests, avg, p50, p95, p99, max))
+				}
+				rows.Close()
+
+				if !slices.Equal(actual, expected) {
+					t.Errorf("%s: expected %v, got %v", view, expected, actual)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"sync"
+
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+// insertTestBatches writes the provided batches to the DB by the write routine in the order they are provided, and waits for the routine to finish.
+func insertTestBatches(db *db, batches ...parser.Batch) {
+	parsedLogChan := make(chan parser.Batch)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go db.InsertBatch(parsedLogChan, &wg)
+
+	for _, batch := range batches {
+		parsedLogChan <- batch
+	}
+	close(parsedLogChan)
+
+	wg.Wait()
+}
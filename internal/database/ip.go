@@ -0,0 +1,82 @@
+package database
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/ncruces/go-sqlite3"
+)
+
+const (
+	// ipInCIDRFunction is the name of the SQL function checking whether an IP belongs to a CIDR range
+	ipInCIDRFunction = "ip_in_cidr"
+)
+
+// The SQL functions of xilt are registered on every connection opened by the app, so that they can be used by the commands and in the DB files created by it
+func init() {
+	sqlite3.AutoExtension(func(c *sqlite3.Conn) error {
+		return c.CreateFunction(ipInCIDRFunction, 2, sqlite3.DETERMINISTIC|sqlite3.INNOCUOUS, ipInCIDR)
+	})
+}
+
+// ipInCIDR implements ip_in_cidr(ip, cidr), which returns 1 if the IP (either in the text or in the 16-byte binary form) belongs to the CIDR range (IPv4 addresses belonging to the IPv6 ranges containing their IPv4-mapped form, e.g. ::/0), 0 if it does not or if it is invalid, and NULL if any of the arguments is NULL. An invalid CIDR range results in an error.
+func ipInCIDR(ctx sqlite3.Context, arg ...sqlite3.Value) {
+	if arg[0].Type() == sqlite3.NULL || arg[1].Type() == sqlite3.NULL {
+		ctx.ResultNull()
+		return
+	}
+
+	// The CIDR range is usually constant, therefore it is parsed only once per statement
+	prefix, ok := ctx.GetAuxData(1).(netip.Prefix)
+	if !ok {
+		var err error
+		if prefix, err = netip.ParsePrefix(arg[1].Text()); err != nil {
+			ctx.ResultError(fmt.Errorf("invalid CIDR range: %w", err))
+			return
+		}
+		prefix = prefix.Masked()
+		ctx.SetAuxData(1, prefix)
+	}
+
+	var addr netip.Addr
+
+	if arg[0].Type() == sqlite3.BLOB {
+		addr, ok = netip.AddrFromSlice(arg[0].RawBlob())
+	} else {
+		var err error
+		addr, err = netip.ParseAddr(arg[0].Text())
+		ok = err == nil
+	}
+
+	if !ok {
+		ctx.ResultBool(false)
+		return
+	}
+
+	// IPv4 addresses belong to IPv6 ranges as IPv4-mapped addresses, matching the ordering of the binary IPs
+	addr = addr.Unmap()
+	if prefix.Addr().Is6() && addr.Is4() {
+		addr = netip.AddrFrom16(addr.As16())
+	}
+
+	ctx.ResultBool(prefix.Contains(addr))
+}
+
+// prefixRange returns the first and the last IP of the CIDR range in the 16-byte binary form, which are the bounds of the range of the binary IPs belonging to it.
+func prefixRange(prefix netip.Prefix) ([]byte, []byte) {
+	prefix = prefix.Masked()
+
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+
+	first := prefix.Addr().As16()
+	last := first
+
+	for i := bits; i < 128; i++ {
+		last[i/8] |= 1 << (7 - i%8)
+	}
+
+	return first[:], last[:]
+}
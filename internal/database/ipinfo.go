@@ -0,0 +1,42 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+const (
+	// The IP information is stored once per IP and joined with the logs on the IP column. IPs not found in the GeoIP databases have no row.
+	createIPInfoTableScript = `CREATE TABLE "ip_info" ("IP" TEXT NOT NULL, "Country" TEXT, "Region" TEXT, "City" TEXT, "ASN" INTEGER, "Organization" TEXT, PRIMARY KEY("IP"));`
+	// Newer GeoIP databases used when appending logs update the information of the IPs already stored
+	upsertIPInfoStatement = `INSERT INTO ip_info (IP, Country, Region, City, ASN, Organization) VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(IP) DO UPDATE SET Country = excluded.Country, Region = excluded.Region, City = excluded.City, ASN = excluded.ASN, Organization = excluded.Organization`
+	copyIPInfoStatement = "INSERT INTO main.ip_info (IP, Country, Region, City, ASN, Organization) SELECT IP, Country, Region, City, ASN, Organization FROM src.ip_info WHERE true ON CONFLICT(IP) DO NOTHING"
+)
+
+// upsertIPInfo stores the information about the IPs of a batch within the provided transaction.
+func upsertIPInfo(tx *sql.Tx, ips map[string]parser.IPInfo) error {
+	stmt, err := tx.Prepare(upsertIPInfoStatement)
+	if err != nil {
+		return fmt.Errorf("failed to prepare IP information statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for ip, info := range ips {
+		if _, err := stmt.Exec(ip, nullIfEmpty(info.Country), nullIfEmpty(info.Region), nullIfEmpty(info.City), nullIfZero(int64(info.ASN)), nullIfEmpty(info.Organization)); err != nil {
+			return fmt.Errorf("failed to insert IP information: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// nullIfEmpty converts an empty string to NULL, so that the fields missing in the GeoIP databases are distinguishable from empty ones.
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+func TestDB_InsertBatchIPInfo(t *testing.T) {
+	db := NewDB(&mockLogger{}, &config.Config{
+		DBFilePath:    filepath.Join(t.TempDir(), "ipinfo.db"),
+		GeoIPFilePath: "GeoLite2-City.mmdb",
+	})
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	insertTestBatches(db,
+		parser.Batch{
+			Logs: []parser.Log{{IP: "81.2.69.142", Method: "GET"}, {IP: "127.0.0.1", Method: "GET"}},
+			IPInfo: map[string]parser.IPInfo{
+				"81.2.69.142": {Country: "GB", Region: "England", City: "London"},
+			},
+		},
+		// The information of IPs already stored is updated by later batches
+		parser.Batch{
+			Logs: []parser.Log{{IP: "81.2.69.142", Method: "GET"}},
+			IPInfo: map[string]parser.IPInfo{
+				"81.2.69.142": {Country: "GB", Region: "England", City: "London", ASN: 20712, Organization: "Andrews & Arnold Ltd"},
+			},
+		},
+	)
+
+	type row struct {
+		IP           string
+		Country      sql.NullString
+		ASN          sql.NullInt64
+		Organization sql.NullString
+	}
+
+	// IPs not found in the GeoIP databases have no information
+	expected := []row{
+		{IP: "127.0.0.1"},
+		{IP: "81.2.69.142", Country: sql.NullString{String: "GB", Valid: true}, ASN: sql.NullInt64{Int64: 20712, Valid: true}, Organization: sql.NullString{String: "Andrews & Arnold Ltd", Valid: true}},
+		{IP: "81.2.69.142", Country: sql.NullString{String: "GB", Valid: true}, ASN: sql.NullInt64{Int64: 20712, Valid: true}, Organization: sql.NullString{String: "Andrews & Arnold Ltd", Valid: true}},
+	}
+
+	rows, err := db.conn.Query("SELECT l.IP, i.Country, i.ASN, i.Organization FROM logs l LEFT JOIN ip_info i ON i.IP = l.IP ORDER BY l.IP, l.ID;")
+	if err != nil {
+		t.Fatalf("error querying logs: %v", err)
+	}
+	defer rows.Close()
+
+	var actual []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.IP, &r.Country, &r.ASN, &r.Organization); err != nil {
+			t.Errorf("error scanning rows: %v", err)
+		}
+		actual = append(actual, r)
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], actual[i])
+		}
+	}
+
+	var count int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM ip_info;").Scan(&count); err != nil {
+		t.Errorf("error counting IP information: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 IP with information, got %d", count)
+	}
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"strings"
+
+	"go.vxn.dev/xilt/internal/config"
+)
+
+const (
+	attachSourceStatement = "ATTACH DATABASE ? AS src"
+	detachSourceStatement = "DETACH DATABASE src"
+
+	copyRunsStatement      = "INSERT INTO main.ingest_runs (ID, StartedAt, FinishedAt, Version, Config, LinesRead, LinesParsed, LinesRejected, LogsInserted, DuplicatesSkipped) SELECT ID + ?, StartedAt, FinishedAt, Version, Config, LinesRead, LinesParsed, LinesRejected, LogsInserted, DuplicatesSkipped FROM src.ingest_runs ORDER BY ID"
+	copyRunFilesStatement  = "INSERT INTO main.ingest_run_files (RunID, Path, SizeBytes, SHA256) SELECT RunID + ?, Path, SizeBytes, SHA256 FROM src.ingest_run_files"
+	copyDimensionStatement = "INSERT INTO main.%s (Value) SELECT Value FROM src.%s WHERE true ON CONFLICT(Value) DO NOTHING"
+)
+
+// MergeSource is a DB to be merged along with the name of the node its logs come from.
+type MergeSource struct {
+	Path string
+	Node string
+}
+
+// Merge creates the DB from the logs of the provided source DBs, recording the node each log comes from in the Node column. The logs get new IDs, and the values of the lookup tables and the ingestion runs are remapped accordingly. If the sources store hashes of the logs, duplicates of the same node are skipped. All sources must share the same schema options, which are used for the merged DB. Sources which are merged DBs themselves keep the nodes of their logs.
+func (d *db) Merge(sources []MergeSource) (Stats, error) {
+	var stats Stats
+
+	schemas, err := d.sourceSchemas(sources)
+	if err != nil {
+		return stats, err
+	}
+
+	merged := *schemas[0]
+	merged.node = true
+	// The session IDs of the sources would collide, therefore the sessions of the merged DB have to be reconstructed by the sessions command
+	merged.sessions = false
+	merged.build()
+	d.schema = &merged
+
+	conn, err := sql.Open("sqlite3", dataSourceName(d.config.DBFilePath))
+	if err != nil {
+		return stats, fmt.Errorf("failed to connect to DB: %w", err)
+	}
+
+	d.conn = conn
+
+	// The sources are attached to the connection, therefore all queries have to use the same one
+	d.conn.SetMaxOpenConns(1)
+
+	if _, err := d.conn.Exec("PRAGMA synchronous = OFF;"); err != nil {
+		return stats, fmt.Errorf("failed to set PRAGMA synchronous: %w", err)
+	}
+
+	if err := d.create(); err != nil {
+		return stats, err
+	}
+
+	if err := d.loadPartitions(); err != nil {
+		return stats, err
+	}
+
+	for i, source := range sources {
+		sourceStats, err := d.mergeSource(source, schemas[i])
+		if err != nil {
+			return stats, fmt.Errorf("failed to merge %s: %w", source.Path, err)
+		}
+
+		stats.Inserted += sourceStats.Inserted
+		stats.Duplicates += sourceStats.Duplicates
+
+		d.logger.Debugf("merged %d logs from %s", sourceStats.Inserted, source.Path)
+	}
+
+	// Skipped duplicates must not be counted, therefore the rollups are aggregated from the merged logs
+	if d.schema.rollupInterval > 0 {
+		if err := d.aggregateRollups(d.conn, flatLogTable, 1); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+// sourceSchemas loads the schemas of the source DBs and checks that their options match.
+func (d *db) sourceSchemas(sources []MergeSource) ([]*schema, error) {
+	schemas := make([]*schema, 0, len(sources))
+
+	var expected map[string]string
+
+	for _, source := range sources {
+		src := NewDB(d.logger, &config.Config{DBFilePath: source.Path})
+		if err := src.Open(); err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", source.Path, err)
+		}
+
+		if err := src.Close(); err != nil {
+			return nil, fmt.Errorf("failed to close %s: %w", source.Path, err)
+		}
+
+		// Merged DBs can be merged again, as their nodes are kept. The sessions are not merged.
+		meta := src.schema.meta()
+		delete(meta, metaNode)
+		delete(meta, metaSessions)
+
+		if expected == nil {
+			expected = meta
+		} else if !maps.Equal(meta, expected) {
+			return nil, fmt.Errorf("the schema options of %s %v do not match the ones of %s %v", source.Path, meta, sources[0].Path, expected)
+		}
+
+		schemas = append(schemas, src.schema)
+	}
+
+	return schemas, nil
+}
+
+// mergeSource copies the logs along with their query parameters, lookup values, IP information and ingestion runs of a single source DB within a single transaction.
+func (d *db) mergeSource(source MergeSource, src *schema) (stats Stats, err error) {
+	if _, err := d.conn.Exec(attachSourceStatement, dataSourceName(source.Path, "mode=ro")); err != nil {
+		return stats, fmt.Errorf("failed to attach DB: %w", err)
+	}
+
+	defer func() {
+		if _, detachErr := d.conn.Exec(detachSourceStatement); detachErr != nil && err == nil {
+			err = fmt.Errorf("failed to detach DB: %w", detachErr)
+		}
+	}()
+
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				d.logger.Printf("failed to roll back transaction: %v", rollbackErr)
+			}
+			if loadErr := d.loadPartitions(); loadErr != nil {
+				d.logger.Printf("failed to reload partitions: %v", loadErr)
+			}
+		}
+	}()
+
+	// The IDs of the copied runs are shifted past the IDs of the runs copied from the previous sources
+	var runOffset int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(ID), 0) FROM main.ingest_runs;").Scan(&runOffset); err != nil {
+		return stats, fmt.Errorf("failed to query ingestion runs: %w", err)
+	}
+
+	if _, err := tx.Exec(copyRunsStatement, runOffset); err != nil {
+		return stats, fmt.Errorf("failed to copy ingestion runs: %w", err)
+	}
+	if _, err := tx.Exec(copyRunFilesStatement, runOffset); err != nil {
+		return stats, fmt.Errorf("failed to copy ingestion run files: %w", err)
+	}
+
+	for _, dimension := range src.dimensions() {
+		if _, err := tx.Exec(fmt.Sprintf(copyDimensionStatement, dimension, dimension)); err != nil {
+			return stats, fmt.Errorf("failed to copy %s: %w", dimension, err)
+		}
+	}
+
+	if src.geoip {
+		if _, err := tx.Exec(copyIPInfoStatement); err != nil {
+			return stats, fmt.Errorf("failed to copy IP information: %w", err)
+		}
+	}
+
+	// Sources storing the nodes themselves keep them
+	args := []any{runOffset, nil}
+
+	if !src.node {
+		var node any = source.Node
+
+		if d.schema.normalized {
+			if err := tx.QueryRow(fmt.Sprintf(upsertDimensionStatement, nodeColumn.dimension), source.Node).Scan(&node); err != nil {
+				return stats, fmt.Errorf("failed to insert node: %w", err)
+			}
+		}
+
+		args[1] = node
+	}
+
+	partitions := []partition{{name: src.logTable()}}
+
+	// The IDs of partitioned logs and of logs with stored query parameters are shifted past the IDs of the logs copied from the previous sources, as they are assigned explicitly
+	idOffset := d.nextID - 1
+
+	if src.partition != "" {
+		if partitions, err = listPartitions(tx, "src"); err != nil {
+			return stats, err
+		}
+	}
+
+	if src.assignsIDs() {
+		args = append(args, idOffset)
+	}
+
+	var total int64
+
+	for _, p := range partitions {
+		if src.partition != "" && !d.partitions[p.name] {
+			if err := d.createPartition(tx, p); err != nil {
+				return stats, err
+			}
+		}
+
+		var count, maxID sql.NullInt64
+		if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*), MAX(ID) FROM src.%s;", p.name)).Scan(&count, &maxID); err != nil {
+			return stats, fmt.Errorf("failed to query logs: %w", err)
+		}
+
+		total += count.Int64
+
+		res, err := tx.Exec(d.schema.mergeStatement(p.name, src.node), args...)
+		if err != nil {
+			return stats, fmt.Errorf("failed to copy logs: %w", err)
+		}
+
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return stats, fmt.Errorf("failed to copy logs: %w", err)
+		}
+		stats.Inserted += inserted
+
+		if src.params {
+			if _, err := tx.Exec(fmt.Sprintf(copyParamsStatement, p.name), idOffset); err != nil {
+				return stats, fmt.Errorf("failed to copy query parameters: %w", err)
+			}
+		}
+
+		if src.assignsIDs() && idOffset+maxID.Int64 >= d.nextID {
+			d.nextID = idOffset + maxID.Int64 + 1
+		}
+	}
+
+	stats.Duplicates = total - stats.Inserted
+
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return stats, nil
+}
+
+// mergeStatement returns the statement copying the logs of the provided table of the attached source DB with the same schema options into the table of the same name of the merged DB. The IDs of the lookup values are remapped by joining the lookup tables of both DBs on the values. The first parameter is the offset of the run IDs, the second one is the node (or its ID in the normalized schema mode), which is only used if the source does not store the nodes itself (in which case it qualifies the hashes as well), and the third one is the offset of the IDs assigned explicitly.
+func (s *schema) mergeStatement(table string, sourceNode bool) string {
+	names := make([]string, 0, len(s.columns)+1)
+	values := make([]string, 0, len(s.columns)+1)
+
+	if s.assignsIDs() {
+		names = append(names, "ID")
+		values = append(values, "l.ID + ?3")
+	}
+
+	var joins strings.Builder
+
+	// joinDimension joins the lookup tables of both DBs, so that the ID of the value in the merged DB can be selected
+	joinDimension := func(dimension, stored string) {
+		fmt.Fprintf(&joins, " LEFT JOIN src.%s s_%s ON s_%s.ID = l.%s LEFT JOIN main.%s m_%s ON m_%s.Value = s_%s.Value", dimension, dimension, dimension, stored, dimension, dimension, dimension, dimension)
+	}
+
+	for _, c := range s.columns {
+		stored := s.storedName(c)
+		names = append(names, stored)
+
+		switch {
+		case c.name == nodeColumn.name && !sourceNode:
+			values = append(values, "?2")
+		case c.name == hashColumn.name && !sourceNode:
+			// Identical logs of different nodes must not be skipped as duplicates, therefore the hashes are qualified by the node
+			node := "?2"
+			if s.normalized {
+				node = "(SELECT Value FROM main." + nodeColumn.dimension + " WHERE ID = ?2)"
+			}
+			values = append(values, "CAST(CAST("+node+" AS BLOB) || X'00' || l.Hash AS BLOB)")
+		case c.name == nodeColumn.name && s.normalized:
+			joinDimension(c.dimension, stored)
+			values = append(values, "COALESCE(m_"+c.dimension+".ID, ?2)")
+		case c.name == nodeColumn.name:
+			values = append(values, "COALESCE(l."+stored+", ?2)")
+		case c.name == "RunID":
+			values = append(values, "l.RunID + ?1")
+		case s.normalized && c.dimension != "":
+			joinDimension(c.dimension, stored)
+			values = append(values, "m_"+c.dimension+".ID")
+		default:
+			values = append(values, "l."+stored)
+		}
+	}
+
+	statement := fmt.Sprintf("INSERT INTO main.%s (%s) SELECT %s FROM src.%s l%s ORDER BY l.ID", table, strings.Join(names, ", "), strings.Join(values, ", "), table, joins.String())
+	if s.dedupe {
+		statement += " ON CONFLICT(Hash) DO NOTHING"
+	}
+
+	return statement
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+func TestDB_Merge(t *testing.T) {
+	tests := []struct {
+		normalize bool
+		partition string
+	}{
+		{normalize: false},
+		{normalize: true},
+		{normalize: false, partition: config.PartitionDay},
+		{normalize: true, partition: config.PartitionDay},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		sourceLogs := [][]parser.Log{{
+			{IP: "127.0.0.1", TimestampUnix: 971211336, Route: "/", ResponseCode: 200, Agent: "curl", Hash: []byte("first")},
+			{IP: "127.0.0.2", TimestampUnix: 971211400, Route: "/login", ResponseCode: 404, Agent: "curl", Hash: []byte("second")},
+		}, {
+			{IP: "127.0.0.2", TimestampUnix: 971211400, Route: "/login", ResponseCode: 404, Agent: "curl", Hash: []byte("second")},
+			{IP: "127.0.0.3", TimestampUnix: 971211500, Route: "/admin", ResponseCode: 500, Agent: "wget", Hash: []byte("third")},
+		}, {
+			{IP: "127.0.0.2", TimestampUnix: 971211400, Route: "/login", ResponseCode: 404, Agent: "curl", Hash: []byte("second")},
+		}}
+
+		sources := []MergeSource{
+			{Path: filepath.Join(dir, "a.db"), Node: "web1"},
+			{Path: filepath.Join(dir, "b.db"), Node: "web2"},
+			{Path: filepath.Join(dir, "c.db"), Node: "web1"},
+		}
+
+		for i, source := range sources {
+			db := NewDB(&mockLogger{}, &config.Config{
+				DBFilePath:     source.Path,
+				Normalize:      tt.normalize,
+				Partition:      tt.partition,
+				Dedupe:         true,
+				RollupInterval: time.Hour,
+				FullTextSearch: true,
+			})
+
+			if err := db.Init(); err != nil {
+				t.Errorf("Init failed: %v", err)
+			}
+
+			if err := db.StartRun(time.Now(), "test", "{}"); err != nil {
+				t.Errorf("StartRun failed: %v", err)
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: sourceLogs[i]})
+
+			if err := db.Close(); err != nil {
+				t.Errorf("error closing DB: %v", err)
+			}
+		}
+
+		db := NewDB(&mockLogger{}, &config.Config{DBFilePath: filepath.Join(dir, "all.db")})
+
+		stats, err := db.Merge(sources)
+		if err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+
+		if expected := (Stats{Inserted: 4, Duplicates: 1}); stats != expected {
+			t.Errorf("expected %+v, got %+v", expected, stats)
+		}
+
+		rows, err := db.conn.Query("SELECT Node, Route, Agent, RunID FROM logs ORDER BY ID;")
+		if err != nil {
+			t.Fatalf("error querying logs: %v", err)
+		}
+
+		type mergedLog struct {
+			node, route, agent string
+			runID              int64
+		}
+
+		actual := make([]mergedLog, 0)
+
+		for rows.Next() {
+			var l mergedLog
+
+			if err := rows.Scan(&l.node, &l.route, &l.agent, &l.runID); err != nil {
+				t.Errorf("error scanning log rows: %v", err)
+			}
+
+			actual = append(actual, l)
+		}
+		rows.Close()
+
+		expected := []mergedLog{
+			{node: "web1", route: "/", agent: "curl", runID: 1},
+			{node: "web1", route: "/login", agent: "curl", runID: 1},
+			{node: "web2", route: "/login", agent: "curl", runID: 2},
+			{node: "web2", route: "/admin", agent: "wget", runID: 2},
+		}
+
+		if len(actual) != len(expected) {
+			t.Fatalf("expected %+v, got %+v", expected, actual)
+		}
+		for i := range expected {
+			if actual[i] != expected[i] {
+				t.Errorf("expected %+v, got %+v", expected[i], actual[i])
+			}
+		}
+
+		var runs, requests, errors int
+
+		if err := db.conn.QueryRow("SELECT COUNT(*) FROM ingest_runs;").Scan(&runs); err != nil {
+			t.Errorf("error querying runs: %v", err)
+		}
+		if err := db.conn.QueryRow("SELECT SUM(Requests), SUM(Status5xx) FROM rollup_agents;").Scan(&requests, &errors); err != nil {
+			t.Errorf("error querying rollups: %v", err)
+		}
+
+		if runs != 3 || requests != 4 || errors != 1 {
+			t.Errorf("expected 3 runs and 4 requests with 1 server error in the rollups, got %d runs, %d requests and %d server errors", runs, requests, errors)
+		}
+
+		results, err := db.Search(SearchQuery{Match: "wget"})
+		if err != nil {
+			t.Errorf("search failed: %v", err)
+		}
+		if len(results) != 1 || results[0].Route != "/admin" {
+			t.Errorf("expected the merged log to be found, got %+v", results)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing DB: %v", err)
+		}
+	}
+}
+
+func TestDB_MergeSchemaMismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	sources := []MergeSource{
+		{Path: filepath.Join(dir, "a.db"), Node: "web1"},
+		{Path: filepath.Join(dir, "b.db"), Node: "web2"},
+	}
+
+	for i, source := range sources {
+		db := NewDB(&mockLogger{}, &config.Config{DBFilePath: source.Path, Normalize: i == 0})
+
+		if err := db.Init(); err != nil {
+			t.Errorf("Init failed: %v", err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing DB: %v", err)
+		}
+	}
+
+	db := NewDB(&mockLogger{}, &config.Config{DBFilePath: filepath.Join(dir, "all.db")})
+	defer db.Close()
+
+	if _, err := db.Merge(sources); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+// maxVariables is the maximum number of host parameters in a single statement (SQLITE_MAX_VARIABLE_NUMBER) of SQLite since 3.32.0.
+const maxVariables = 32766
+
+// rowLog is a log to be inserted along with its ID, which is only used if the IDs are assigned by the write routine.
+type rowLog struct {
+	*parser.Log
+	id int64
+}
+
+// rowsStatement identifies a prepared statement inserting a number of logs into a log table.
+type rowsStatement struct {
+	table string
+	rows  int
+}
+
+// rowsPerStatement returns the maximum number of logs inserted by a single multi-row statement, so that the number of its parameters does not exceed the limit of SQLite.
+func (s *schema) rowsPerStatement() int {
+	perRow := len(s.columns)
+	if s.assignsIDs() {
+		perRow++
+	}
+
+	return maxVariables / perRow
+}
+
+// insertMultiRow inserts the logs of a batch within the provided transaction using statements inserting as many logs at once as the parameter limit of SQLite allows, updating the stats. Skipped duplicates are subtracted from the batch's rollups.
+func (d *db) insertMultiRow(tx *sql.Tx, batch *parser.Batch, stats *Stats) error {
+	statements := make(map[rowsStatement]*sql.Stmt)
+	defer func() {
+		for _, stmt := range statements {
+			stmt.Close()
+		}
+	}()
+
+	// The logs are grouped by the tables they are stored in, keeping their order within each table. The IDs assigned by the write routine are assigned beforehand, so that they follow the order of the batch across the partitions. Gaps are left in the IDs of logs skipped as duplicates.
+	tables := make([]string, 0, 1)
+	groups := make(map[string][]rowLog)
+
+	for i := range batch.Logs {
+		parsedLog := &batch.Logs[i]
+
+		table, err := d.tableFor(tx, parsedLog.TimestampUnix)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := groups[table]; !ok {
+			tables = append(tables, table)
+		}
+		groups[table] = append(groups[table], rowLog{Log: parsedLog, id: d.nextID})
+
+		if d.schema.assignsIDs() {
+			d.nextID++
+		}
+	}
+
+	size := d.schema.rowsPerStatement()
+
+	for _, table := range tables {
+		logs := groups[table]
+
+		for start := 0; start < len(logs); start += size {
+			chunk := logs[start:min(start+size, len(logs))]
+
+			key := rowsStatement{table: table, rows: len(chunk)}
+
+			stmt, ok := statements[key]
+			if !ok {
+				var err error
+				if stmt, err = tx.Prepare(d.schema.insertRowsStatement(table, len(chunk), batch.Rollups != nil || d.schema.params)); err != nil {
+					return fmt.Errorf("failed to prepare statement: %w", err)
+				}
+				statements[key] = stmt
+			}
+
+			err := d.insertChunk(stmt, chunk, batch.Rollups, stats)
+			if isRejected(err) {
+				// A violating log aborts the whole statement, therefore the chunk is inserted log by log so that only the violating logs are rejected
+				err = d.insertChunkRows(tx, table, chunk, batch.Rollups, stats)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// insertChunk inserts the logs using a single multi-row statement. The statement returns the hashes of the inserted logs if duplicates are to be subtracted from the provided rollups or the query parameters of the inserted logs are to be stored.
+func (d *db) insertChunk(stmt *sql.Stmt, logs []rowLog, rollups parser.Rollups, stats *Stats) error {
+	args := make([]any, 0, len(logs)*(len(d.schema.columns)+1))
+
+	for _, l := range logs {
+		logArgs, err := d.logArgs(l.Log)
+		if err != nil {
+			return err
+		}
+
+		if d.schema.assignsIDs() {
+			args = append(args, l.id)
+		}
+
+		args = append(args, logArgs...)
+	}
+
+	if !d.schema.dedupe || (rollups == nil && !d.schema.params) {
+		res, err := stmt.Exec(args...)
+		if err != nil {
+			return fmt.Errorf("failed to insert: %w", err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to insert: %w", err)
+		}
+
+		stats.Inserted += affected
+		stats.Duplicates += int64(len(logs)) - affected
+
+		// Without duplicates being skipped, all the logs are inserted
+		if d.schema.params {
+			d.paramLogs = append(d.paramLogs, logs...)
+		}
+
+		return nil
+	}
+
+	// The logs whose hashes were not returned were skipped as duplicates, a hash may occur multiple times within the chunk
+	inserted, err := insertedHashes(stmt, args)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range logs {
+		if inserted[string(l.Hash)] > 0 {
+			inserted[string(l.Hash)]--
+			stats.Inserted++
+
+			if d.schema.params {
+				d.paramLogs = append(d.paramLogs, l)
+			}
+			continue
+		}
+
+		stats.Duplicates++
+
+		if rollups != nil {
+			rollups.Subtract(l.Log, d.schema.rollupInterval)
+		}
+	}
+
+	return nil
+}
+
+// insertChunkRows inserts the logs of a chunk one by one, rejecting the logs violating the constraints of the strict log table.
+func (d *db) insertChunkRows(tx *sql.Tx, table string, logs []rowLog, rollups parser.Rollups, stats *Stats) error {
+	stmt, err := tx.Prepare(d.schema.insertStatement(table))
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, l := range logs {
+		args, err := d.logArgs(l.Log)
+		if err != nil {
+			return err
+		}
+
+		if d.schema.assignsIDs() {
+			args = append([]any{l.id}, args...)
+		}
+
+		res, err := stmt.Exec(args...)
+		if isRejected(err) {
+			d.reject(l.Log, err, rollups, stats)
+			continue
+		}
+
+		inserted, err := stats.count(res, err)
+		if err != nil {
+			return fmt.Errorf("failed to insert: %w", err)
+		}
+
+		if inserted && d.schema.params {
+			d.paramLogs = append(d.paramLogs, l)
+		}
+
+		if !inserted && rollups != nil {
+			rollups.Subtract(l.Log, d.schema.rollupInterval)
+		}
+	}
+
+	return nil
+}
+
+// insertedHashes executes the multi-row statement returning the hashes of the inserted logs and counts them.
+func insertedHashes(stmt *sql.Stmt, args []any) (map[string]int, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert: %w", err)
+	}
+	defer rows.Close()
+
+	inserted := make(map[string]int)
+
+	for rows.Next() {
+		var hash []byte
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("failed to scan inserted hash: %w", err)
+		}
+		inserted[string(hash)]++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to insert: %w", err)
+	}
+
+	return inserted, nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+// multiRowTestLogs returns logs spanning two days, every third of which is a duplicate of the previous log.
+func multiRowTestLogs(count int) []parser.Log {
+	logs := make([]parser.Log, count)
+
+	for i := range logs {
+		n := i - i%3/2
+		logs[i] = parser.Log{
+			IP:            "127.0.0.1",
+			TimestampUnix: 971211336 + int64(n)*60,
+			Method:        "GET",
+			Route:         fmt.Sprintf("/route%d", n%10),
+			ResponseCode:  200,
+			BytesSent:     10,
+			Hash:          []byte(fmt.Sprintf("log%d", n)),
+		}
+	}
+
+	return logs
+}
+
+func TestSchema_InsertRowsStatement(t *testing.T) {
+	s := newSchema(&config.Config{Dedupe: true, Partition: config.PartitionDay})
+
+	perRow := len(s.columns) + 1
+	if rows := s.rowsPerStatement(); rows*perRow > maxVariables || (rows+1)*perRow <= maxVariables {
+		t.Errorf("unexpected rows per statement %d for %d parameters per row", rows, perRow)
+	}
+
+	statement := s.insertRowsStatement("logs_20001010", 2, true)
+
+	expected := "INSERT INTO logs_20001010 (ID, IP, Identity, UserID, Time, TimestampUTC, TimestampUnix, Method, Route, Params, ResponseCode, BytesSent, Referer, Agent, RunID, Hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(Hash) DO NOTHING RETURNING Hash"
+	if statement != expected {
+		t.Errorf("expected %s, got %s", expected, statement)
+	}
+}
+
+func TestDB_InsertBatchMultiRow(t *testing.T) {
+	tests := []struct {
+		name      string
+		normalize bool
+		partition string
+	}{
+		{name: "flat"},
+		{name: "normalized", normalize: true},
+		{name: "partitioned", partition: config.PartitionDay},
+	}
+
+	// More logs than fit in a single statement, so that the batch is split
+	logs := multiRowTestLogs(6000)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var results [2]struct {
+				stats    Stats
+				count    int64
+				requests int64
+			}
+
+			// The logs are inserted one by one first and by multi-row statements then, both of which must give the same results
+			for i, multiRow := range []bool{false, true} {
+				db := NewDB(&mockLogger{}, &config.Config{
+					DBFilePath:     filepath.Join(t.TempDir(), "multirow.db"),
+					Normalize:      tt.normalize,
+					Dedupe:         true,
+					RollupInterval: time.Hour,
+					Partition:      tt.partition,
+					MultiRowInsert: multiRow,
+				})
+
+				if err := db.Init(); err != nil {
+					t.Fatalf("Init failed: %v", err)
+				}
+
+				batchLogs := make([]parser.Log, len(logs))
+				copy(batchLogs, logs)
+
+				rollups := make(parser.Rollups)
+				for j := range batchLogs {
+					rollups.Add(&batchLogs[j], 3600)
+				}
+
+				insertTestBatches(db, parser.Batch{Logs: batchLogs, Rollups: rollups})
+
+				results[i].stats = db.Stats()
+
+				if err := db.conn.QueryRow("SELECT COUNT(*) FROM logs;").Scan(&results[i].count); err != nil {
+					t.Errorf("error counting logs: %v", err)
+				}
+				if err := db.conn.QueryRow("SELECT SUM(Requests) FROM rollup_routes;").Scan(&results[i].requests); err != nil {
+					t.Errorf("error querying rollups: %v", err)
+				}
+
+				if err := db.Close(); err != nil {
+					t.Errorf("error closing DB: %v", err)
+				}
+			}
+
+			if results[1] != results[0] {
+				t.Errorf("expected %+v, got %+v", results[0], results[1])
+			}
+
+			if results[1].stats != (Stats{Inserted: 4000, Duplicates: 2000}) || results[1].count != 4000 || results[1].requests != 4000 {
+				t.Errorf("unexpected results %+v", results[1])
+			}
+		})
+	}
+}
+
+// BenchmarkDB_InsertBatchMultiRow compares the write throughput of inserting the logs one by one and by multi-row statements.
+func BenchmarkDB_InsertBatchMultiRow(b *testing.B) {
+	const logCount = 100000
+
+	logs := make([]parser.Log, logCount)
+	for i := range logs {
+		logs[i] = parser.Log{
+			IP:            "127.0.0.1",
+			Identity:      "user-identifier",
+			User:          "frank",
+			Time:          "10/Oct/2000:13:55:36 -0700",
+			TimestampUTC:  "2000-10-10T20:55:36Z",
+			TimestampUnix: 971211336,
+			Method:        "GET",
+			Route:         "/apache_pb.gif",
+			Params:        "-",
+			ResponseCode:  200,
+			BytesSent:     2326,
+			Referer:       "-",
+			Agent:         "-",
+		}
+	}
+
+	modes := []struct {
+		name string
+		args []string
+	}{
+		{name: "one-by-one"},
+		{name: "multi-row", args: []string{"-multiRowInsert"}},
+	}
+
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			fs := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+
+			cfg, err := config.Load(fs, mode.args)
+			if err != nil {
+				b.Fatalf("error loading config: %v", err)
+			}
+
+			batches := make([]parser.Batch, 0, len(logs)/cfg.BatchSize+1)
+			for start := 0; start < len(logs); start += cfg.BatchSize {
+				batches = append(batches, parser.Batch{Logs: logs[start:min(start+cfg.BatchSize, len(logs))]})
+			}
+
+			for i := 0; i < b.N; i++ {
+				cfg.DBFilePath = filepath.Join(b.TempDir(), fmt.Sprintf("benchmark%d.db", i))
+
+				db := NewDB(&mockLogger{}, cfg)
+				if err := db.Init(); err != nil {
+					b.Fatalf("Init failed: %v", err)
+				}
+
+				insertTestBatches(db, batches...)
+
+				if err := db.Close(); err != nil {
+					b.Errorf("error closing DB: %v", err)
+				}
+			}
+
+			b.ReportMetric(float64(logCount*b.N)/b.Elapsed().Seconds(), "logs/s")
+		})
+	}
+}
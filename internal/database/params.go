@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// The query parameters reference the logs by their IDs and are kept in the order of the query strings. The index on the log IDs is needed by the triggers deleting the parameters along with the logs, therefore it is created regardless of the indexes being enabled.
+	createParamsTableScript = `CREATE TABLE "log_params" ("LogID" INTEGER NOT NULL, "Key" TEXT NOT NULL, "Value" TEXT NOT NULL);
+	CREATE INDEX idx_log_params_log_id ON log_params(LogID);`
+	createParamsTriggerScript = `CREATE TRIGGER %s_params_delete AFTER DELETE ON %s BEGIN DELETE FROM log_params WHERE LogID = old.ID; END;`
+	createParamsIndexScript   = "CREATE INDEX IF NOT EXISTS idx_log_params_key_value ON log_params(Key, Value);"
+	insertParamStatement      = "INSERT INTO log_params (LogID, Key, Value) VALUES (?, ?, ?)"
+	// The parameters of the logs skipped as duplicates are skipped as well, as no log with their shifted IDs is copied
+	copyParamsStatement = "INSERT INTO main.log_params (LogID, Key, Value) SELECT p.LogID + ?1, p.Key, p.Value FROM src.log_params p JOIN main.%s l ON l.ID = p.LogID + ?1 ORDER BY p.rowid"
+)
+
+// paramsTriggerScript returns the SQL script creating the trigger deleting the query parameters of the logs deleted from the provided log table.
+func paramsTriggerScript(table string) string {
+	return fmt.Sprintf(createParamsTriggerScript+"\n", table, table)
+}
+
+// insertParams stores the query parameters of the inserted logs within the provided transaction.
+func insertParams(tx *sql.Tx, logs []rowLog) error {
+	stmt, err := tx.Prepare(insertParamStatement)
+	if err != nil {
+		return fmt.Errorf("failed to prepare query parameters statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, l := range logs {
+		for _, param := range l.QueryParams {
+			if _, err := stmt.Exec(l.id, param.Key, param.Value); err != nil {
+				return fmt.Errorf("failed to insert query parameters: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
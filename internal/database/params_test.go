@@ -0,0 +1,163 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+// paramsTestLogs returns logs with query parameters, the second one being a duplicate of the first one.
+func paramsTestLogs() []parser.Log {
+	return []parser.Log{
+		{TimestampUnix: 971211336, Method: "GET", Route: "/", ResponseCode: 200, Hash: []byte("first"), QueryParams: []parser.Param{{Key: "utm_source", Value: "news letter"}, {Key: "q", Value: "a"}}},
+		{TimestampUnix: 971211336, Method: "GET", Route: "/", ResponseCode: 200, Hash: []byte("first"), QueryParams: []parser.Param{{Key: "utm_source", Value: "news letter"}, {Key: "q", Value: "a"}}},
+		{TimestampUnix: 971211400, Method: "GET", Route: "/login", ResponseCode: 200, Hash: []byte("second")},
+		{TimestampUnix: 971400000, Method: "GET", Route: "/search", ResponseCode: 200, Hash: []byte("third"), QueryParams: []parser.Param{{Key: "q", Value: "b\x00"}}},
+	}
+}
+
+// queryParams returns the stored query parameters along with the routes of their logs.
+func queryParams(t *testing.T, db *db) []string {
+	t.Helper()
+
+	rows, err := db.conn.Query("SELECT l.Route, p.Key, p.Value FROM log_params p JOIN logs l ON l.ID = p.LogID ORDER BY p.LogID, p.rowid;")
+	if err != nil {
+		t.Fatalf("error querying query parameters: %v", err)
+	}
+	defer rows.Close()
+
+	params := make([]string, 0)
+	for rows.Next() {
+		var route, key, value string
+		if err := rows.Scan(&route, &key, &value); err != nil {
+			t.Fatalf("error scanning query parameters: %v", err)
+		}
+		params = append(params, fmt.Sprintf("%s %s=%q", route, key, value))
+	}
+
+	return params
+}
+
+func TestDB_InsertBatchParams(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{name: "flat", cfg: config.Config{ParamsTable: true}},
+		{name: "dedupe", cfg: config.Config{ParamsTable: true, Dedupe: true}},
+		{name: "multi-row dedupe", cfg: config.Config{ParamsTable: true, Dedupe: true, MultiRowInsert: true, Normalize: true}},
+		{name: "multi-row rejected", cfg: config.Config{ParamsTable: true, MultiRowInsert: true, Strict: true}},
+		{name: "partitioned", cfg: config.Config{ParamsTable: true, Dedupe: true, Partition: config.PartitionDay, FullTextSearch: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.DBFilePath = filepath.Join(t.TempDir(), "params.db")
+
+			logs := paramsTestLogs()
+			expected := []string{`/ utm_source="news letter"`, `/ q="a"`, `/ utm_source="news letter"`, `/ q="a"`, `/search q="b\x00"`}
+			if cfg.Dedupe {
+				expected = slices.Delete(expected, 2, 4)
+			}
+			if cfg.Strict {
+				// The violating log is rejected along with its parameters
+				logs[1].Method = "INVALID"
+				expected = slices.Delete(expected, 2, 4)
+			}
+
+			db := NewDB(&mockLogger{}, &cfg)
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: logs[:3]}, parser.Batch{Logs: logs[3:]})
+
+			if actual := queryParams(t, db); !slices.Equal(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatalf("error closing DB: %v", err)
+			}
+
+			if !cfg.Dedupe {
+				return
+			}
+
+			// The IDs of the logs appended to the DB follow the stored ones
+			db = NewDB(&mockLogger{}, &cfg)
+			defer db.Close()
+
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: append(paramsTestLogs(), parser.Log{TimestampUnix: 971400001, Method: "GET", Route: "/new", ResponseCode: 200, Hash: []byte("fourth"), QueryParams: []parser.Param{{Key: "page", Value: "2"}}})})
+
+			expected = append(expected, `/new page="2"`)
+			if actual := queryParams(t, db); !slices.Equal(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+
+			// The parameters are deleted along with their logs
+			if _, err := db.Prune(PruneOptions{Before: time.Unix(971300000, 0), ChunkSize: 1}); err != nil {
+				t.Fatalf("Prune failed: %v", err)
+			}
+
+			expected = expected[2:]
+			if actual := queryParams(t, db); !slices.Equal(actual, expected) {
+				t.Errorf("expected %v after pruning, got %v", expected, actual)
+			}
+
+			var count int
+			if err := db.conn.QueryRow("SELECT COUNT(*) FROM log_params;").Scan(&count); err != nil || count != len(expected) {
+				t.Errorf("expected %d query parameters after pruning, got %d (%v)", len(expected), count, err)
+			}
+		})
+	}
+}
+
+func TestDB_MergeParams(t *testing.T) {
+	for _, partition := range []string{"", config.PartitionDay} {
+		dir := t.TempDir()
+
+		sources := []MergeSource{
+			{Path: filepath.Join(dir, "a.db"), Node: "web1"},
+			{Path: filepath.Join(dir, "b.db"), Node: "web1"},
+		}
+
+		for i, source := range sources {
+			db := NewDB(&mockLogger{}, &config.Config{DBFilePath: source.Path, ParamsTable: true, Dedupe: true, Partition: partition})
+
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			// The second source is another DB of the same node sharing the first log of the first one
+			logs := paramsTestLogs()
+			insertTestBatches(db, parser.Batch{Logs: []parser.Log{logs[i], logs[i+2]}})
+
+			if err := db.Close(); err != nil {
+				t.Fatalf("error closing DB: %v", err)
+			}
+		}
+
+		db := NewDB(&mockLogger{}, &config.Config{DBFilePath: filepath.Join(dir, "all.db")})
+		defer db.Close()
+
+		if _, err := db.Merge(sources); err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+
+		expected := []string{`/ utm_source="news letter"`, `/ q="a"`, `/search q="b\x00"`}
+		if actual := queryParams(t, db); !slices.Equal(actual, expected) {
+			t.Errorf("%s: expected %v, got %v", partition, expected, actual)
+		}
+	}
+}
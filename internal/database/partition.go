@@ -0,0 +1,250 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+)
+
+const (
+	createPartitionsTableScript = `CREATE TABLE "partitions" ("Name" TEXT NOT NULL, "Start" INTEGER NOT NULL, "End" INTEGER NOT NULL, PRIMARY KEY("Name"));`
+	insertPartitionStatement    = "INSERT INTO partitions (Name, Start, End) VALUES (?, ?, ?)"
+	deletePartitionStatement    = "DELETE FROM partitions WHERE Name = ?"
+	selectPartitionsStatement   = "SELECT Name, Start, End FROM %s.partitions ORDER BY Start"
+
+	// maxCompoundTerms limits the number of SELECTs combined by a single UNION ALL. SQLite rejects compounds of more than 500 terms, and long compounds exhaust the stack of the embedded SQLite already at a few hundred terms, therefore the partitions are combined by nested compounds.
+	maxCompoundTerms = 100
+)
+
+// partition is a table storing the logs of a single day or month. Start and End are the bounds of its time span in unix seconds, End being exclusive.
+type partition struct {
+	name  string
+	start int64
+	end   int64
+}
+
+// queryer is implemented by both DB connections and transactions.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// partitionOf returns the partition the log with the provided timestamp belongs to.
+func (s *schema) partitionOf(timestamp int64) partition {
+	t := time.Unix(timestamp, 0).UTC()
+
+	if s.partition == config.PartitionMonth {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return partition{name: s.logTable() + "_" + start.Format("200601"), start: start.Unix(), end: start.AddDate(0, 1, 0).Unix()}
+	}
+
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return partition{name: s.logTable() + "_" + start.Format("20060102"), start: start.Unix(), end: start.AddDate(0, 0, 1).Unix()}
+}
+
+// emptySelect returns a SELECT statement with the flat logs shape returning no rows, which is used for the logs view before any partition is created.
+func (s *schema) emptySelect() string {
+	values := []string{"NULL AS ID"}
+	for _, c := range s.columns {
+		values = append(values, "NULL AS "+c.name)
+	}
+
+	return "SELECT " + strings.Join(values, ", ") + " WHERE 0"
+}
+
+// partitionViewScript returns the SQL script recreating the logs view as a combination of the provided partitions.
+func (s *schema) partitionViewScript(partitions []partition) string {
+	selects := make([]string, 0, len(partitions))
+
+	for _, p := range partitions {
+		if s.normalized {
+			selects = append(selects, s.flatSelect(p.name))
+		} else {
+			selects = append(selects, "SELECT * FROM "+p.name)
+		}
+	}
+
+	if len(selects) == 0 {
+		selects = append(selects, s.emptySelect())
+	}
+
+	return fmt.Sprintf("DROP VIEW %s;\nCREATE VIEW %s AS %s;", flatLogTable, flatLogTable, unionAll(selects))
+}
+
+// unionAll combines the provided SELECT statements by UNION ALL. If there are more than maxCompoundTerms of them, they are grouped into subqueries of up to maxCompoundTerms terms, repeatedly until the outermost compound is short enough.
+func unionAll(selects []string) string {
+	for len(selects) > maxCompoundTerms {
+		groups := make([]string, 0, (len(selects)+maxCompoundTerms-1)/maxCompoundTerms)
+
+		for start := 0; start < len(selects); start += maxCompoundTerms {
+			groups = append(groups, "SELECT * FROM ("+strings.Join(selects[start:min(start+maxCompoundTerms, len(selects))], " UNION ALL ")+")")
+		}
+
+		selects = groups
+	}
+
+	return strings.Join(selects, " UNION ALL ")
+}
+
+// listPartitions returns the partitions stored in the provided (e.g. main or an attached) DB ordered by their time spans.
+func listPartitions(q queryer, database string) ([]partition, error) {
+	rows, err := q.Query(fmt.Sprintf(selectPartitionsStatement, database))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partitions: %w", err)
+	}
+	defer rows.Close()
+
+	partitions := make([]partition, 0)
+
+	for rows.Next() {
+		var p partition
+
+		if err := rows.Scan(&p.name, &p.start, &p.end); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+
+		partitions = append(partitions, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query partitions: %w", err)
+	}
+
+	return partitions, nil
+}
+
+// loadPartitions loads the partitions stored in the DB and the next ID to be assigned to a log if the IDs are assigned by the write routine.
+func (d *db) loadPartitions() error {
+	d.partitions = make(map[string]bool)
+	d.nextID = 1
+
+	if d.schema.partition == "" {
+		if d.schema.params {
+			return d.loadNextID(d.schema.logTable())
+		}
+		return nil
+	}
+
+	partitions, err := listPartitions(d.conn, "main")
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		d.partitions[p.name] = true
+
+		if err := d.loadNextID(p.name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadNextID advances the next ID to be assigned to a log past the IDs of the logs stored in the provided table.
+func (d *db) loadNextID(table string) error {
+	var maxID sql.NullInt64
+	if err := d.conn.QueryRow(fmt.Sprintf("SELECT MAX(ID) FROM %s;", table)).Scan(&maxID); err != nil {
+		return fmt.Errorf("failed to query %s: %w", table, err)
+	}
+
+	if maxID.Int64 >= d.nextID {
+		d.nextID = maxID.Int64 + 1
+	}
+
+	return nil
+}
+
+// createPartition creates the table of the partition along with its full-text search and query parameters triggers and recreates the logs view to include it.
+func (d *db) createPartition(q queryer, p partition) error {
+	script := d.schema.logTableScript(p.name)
+	if d.schema.fts {
+		script += d.schema.ftsTriggersScript(p.name)
+	}
+	if d.schema.params {
+		script += paramsTriggerScript(p.name)
+	}
+
+	if _, err := q.Exec(script); err != nil {
+		return fmt.Errorf("failed to create partition %s: %w", p.name, err)
+	}
+
+	if _, err := q.Exec(insertPartitionStatement, p.name, p.start, p.end); err != nil {
+		return fmt.Errorf("failed to record partition %s: %w", p.name, err)
+	}
+
+	d.partitions[p.name] = true
+
+	d.logger.Debugf("partition %s created...", p.name)
+
+	return d.rebuildPartitionView(q)
+}
+
+// dropPartition drops the table of the partition and recreates the logs view without it.
+func (d *db) dropPartition(q queryer, name string) error {
+	if _, err := q.Exec(fmt.Sprintf("DROP TABLE %s;", name)); err != nil {
+		return fmt.Errorf("failed to drop partition %s: %w", name, err)
+	}
+
+	if _, err := q.Exec(deletePartitionStatement, name); err != nil {
+		return fmt.Errorf("failed to delete partition %s: %w", name, err)
+	}
+
+	delete(d.partitions, name)
+
+	return d.rebuildPartitionView(q)
+}
+
+// rebuildPartitionView recreates the logs view combining all stored partitions.
+func (d *db) rebuildPartitionView(q queryer) error {
+	partitions, err := listPartitions(q, "main")
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.Exec(d.schema.partitionViewScript(partitions)); err != nil {
+		return fmt.Errorf("failed to recreate logs view: %w", err)
+	}
+
+	return nil
+}
+
+// logTables returns the names of the tables storing the logs, i.e. the partitions ordered by their time spans if the logs are partitioned.
+func (d *db) logTables() ([]string, error) {
+	if d.schema.partition == "" {
+		return []string{d.schema.logTable()}, nil
+	}
+
+	partitions, err := listPartitions(d.conn, "main")
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		tables = append(tables, p.name)
+	}
+
+	return tables, nil
+}
+
+// tableFor returns the name of the table the log with the provided timestamp is stored in. The partition of the log is created within the transaction if it does not exist yet.
+func (d *db) tableFor(tx *sql.Tx, timestamp int64) (string, error) {
+	if d.schema.partition == "" {
+		return d.schema.logTable(), nil
+	}
+
+	p := d.schema.partitionOf(timestamp)
+
+	if !d.partitions[p.name] {
+		if err := d.createPartition(tx, p); err != nil {
+			return "", err
+		}
+	}
+
+	return p.name, nil
+}
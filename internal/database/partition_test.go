@@ -0,0 +1,222 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+func TestSchema_PartitionOf(t *testing.T) {
+	tests := []struct {
+		partition string
+		expected  partition
+	}{
+		{partition: config.PartitionDay, expected: partition{name: "logs_20001010", start: 971136000, end: 971222400}},
+		{partition: config.PartitionMonth, expected: partition{name: "logs_200010", start: 970358400, end: 973036800}},
+	}
+
+	for _, tt := range tests {
+		s := newSchema(&config.Config{Partition: tt.partition})
+
+		if actual := s.partitionOf(971211336); actual != tt.expected {
+			t.Errorf("expected %+v, got %+v", tt.expected, actual)
+		}
+	}
+}
+
+// insertPartitionTestLogs inserts the search test logs, which span two days, into a new partitioned DB.
+func insertPartitionTestLogs(t *testing.T, cfg *config.Config) *db {
+	db := NewDB(&mockLogger{}, cfg)
+
+	if err := db.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	rollups := make(parser.Rollups)
+	for i := range searchTestLogs {
+		rollups.Add(&searchTestLogs[i], 3600)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: searchTestLogs, Rollups: rollups})
+
+	return db
+}
+
+func TestDB_InsertBatchPartitioned(t *testing.T) {
+	for _, normalize := range []bool{false, true} {
+		db := insertPartitionTestLogs(t, &config.Config{
+			Verbose:        false,
+			DBFilePath:     ":memory:?cache=shared",
+			Normalize:      normalize,
+			FullTextSearch: true,
+			RollupInterval: time.Hour,
+			CreateIndexes:  true,
+			Partition:      config.PartitionDay,
+		})
+
+		tables, err := db.logTables()
+		if err != nil {
+			t.Errorf("error listing partitions: %v", err)
+		}
+
+		prefix := db.schema.logTable()
+		if len(tables) != 2 || tables[0] != prefix+"_20001010" || tables[1] != prefix+"_20001011" {
+			t.Errorf("expected 2 partitions, got %v", tables)
+		}
+
+		if err := db.CreateIndexes(); err != nil {
+			t.Errorf("CreateIndexes failed: %v", err)
+		}
+
+		var indexes int
+		if err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_%_ts_unix';").Scan(&indexes); err != nil {
+			t.Errorf("error querying indexes: %v", err)
+		}
+		if indexes != 2 {
+			t.Errorf("expected the indexes to be created on both partitions, got %d", indexes)
+		}
+
+		// The IDs are unique across the partitions, so the logs can be found via the full-text search table
+		results, err := db.Search(SearchQuery{Match: `"wp-login"`})
+		if err != nil {
+			t.Errorf("search failed: %v", err)
+		}
+		if len(results) != 2 || results[0].ID != 1 || results[1].ID != 3 {
+			t.Errorf("expected logs 1 and 3 to be found, got %+v", results)
+		}
+
+		deleted, err := db.Prune(PruneOptions{Before: time.Unix(971222400, 0), ChunkSize: 1})
+		if err != nil {
+			t.Errorf("Prune failed: %v", err)
+		}
+		if deleted != 2 {
+			t.Errorf("expected the first partition of 2 logs to be dropped, got %d pruned logs", deleted)
+		}
+
+		if tables, err = db.logTables(); err != nil || len(tables) != 1 {
+			t.Errorf("expected 1 partition to be left, got %v (%v)", tables, err)
+		}
+
+		var logs, requests int
+
+		if err := db.conn.QueryRow("SELECT COUNT(*) FROM logs;").Scan(&logs); err != nil {
+			t.Errorf("error querying logs: %v", err)
+		}
+		if err := db.conn.QueryRow("SELECT SUM(Requests) FROM rollup_routes;").Scan(&requests); err != nil {
+			t.Errorf("error querying rollups: %v", err)
+		}
+		if logs != 1 || requests != 1 {
+			t.Errorf("expected 1 log and 1 request in the rollups to be left, got %d logs and %d requests", logs, requests)
+		}
+
+		if results, err = db.Search(SearchQuery{Match: `"curl"`}); err != nil || len(results) != 0 {
+			t.Errorf("expected the dropped logs to be removed from the full-text search table, got %+v (%v)", results, err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing DB: %v", err)
+		}
+	}
+}
+
+func TestDB_InsertBatchPartitionedAppend(t *testing.T) {
+	cfg := &config.Config{
+		DBFilePath: filepath.Join(t.TempDir(), "partitioned.db"),
+		Dedupe:     true,
+		Partition:  config.PartitionMonth,
+	}
+
+	logs := []parser.Log{
+		{TimestampUnix: 971211336, Hash: []byte("first")},
+		{TimestampUnix: 973036800, Hash: []byte("second")},
+	}
+
+	expected := []Stats{{Inserted: 2}, {Inserted: 1, Duplicates: 2}}
+
+	for i := range expected {
+		db := NewDB(&mockLogger{}, cfg)
+
+		if err := db.Init(); err != nil {
+			t.Fatalf("Init failed: %v", err)
+		}
+
+		insertTestBatches(db, parser.Batch{Logs: logs})
+
+		if stats := db.Stats(); stats != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], stats)
+		}
+
+		if i == 1 {
+			// The appended log gets the next ID after the ones assigned by the previous run
+			var id int64
+			if err := db.conn.QueryRow("SELECT ID FROM logs WHERE Hash = ?;", []byte("third")).Scan(&id); err != nil {
+				t.Errorf("error querying logs: %v", err)
+			}
+			if id != 3 {
+				t.Errorf("expected the appended log to get ID 3, got %d", id)
+			}
+		}
+
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing DB: %v", err)
+		}
+
+		logs = append(logs, parser.Log{TimestampUnix: 973036801, Hash: []byte("third")})
+	}
+}
+
+func TestDB_InsertBatchManyPartitions(t *testing.T) {
+	// More partitions than SQLite allows terms in a single compound SELECT
+	const days = 510
+
+	for _, normalize := range []bool{false, true} {
+		t.Run(fmt.Sprintf("normalize=%t", normalize), func(t *testing.T) {
+			db := NewDB(&mockLogger{}, &config.Config{
+				DBFilePath: filepath.Join(t.TempDir(), "partitioned.db"),
+				Normalize:  normalize,
+				Partition:  config.PartitionDay,
+			})
+			defer db.Close()
+
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			logs := make([]parser.Log, days)
+			for i := range logs {
+				logs[i] = parser.Log{TimestampUnix: 971211336 + int64(i)*86400, Method: "GET", Route: fmt.Sprintf("/%d", i), ResponseCode: 200}
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: logs})
+
+			if stats := db.Stats(); stats.Inserted != days {
+				t.Fatalf("expected %d inserted logs, got %+v", days, stats)
+			}
+
+			var count, maxID int64
+			if err := db.conn.QueryRow("SELECT COUNT(*), MAX(ID) FROM logs;").Scan(&count, &maxID); err != nil || count != days || maxID != days {
+				t.Errorf("expected %d logs in the logs view, got %d with the maximum ID %d (%v)", days, count, maxID, err)
+			}
+
+			// The unused routes of the dropped partitions are deleted by a query referencing all partitions
+			deleted, err := db.Prune(PruneOptions{Before: time.Unix(971211336+100*86400, 0), ChunkSize: 1000})
+			if err != nil {
+				t.Fatalf("Prune failed: %v", err)
+			}
+			if deleted != 100 {
+				t.Errorf("expected 100 pruned logs, got %d", deleted)
+			}
+
+			if normalize {
+				var routes int64
+				if err := db.conn.QueryRow("SELECT COUNT(*) FROM routes;").Scan(&routes); err != nil || routes != days-100 {
+					t.Errorf("expected %d routes to be left, got %d (%v)", days-100, routes, err)
+				}
+			}
+		})
+	}
+}
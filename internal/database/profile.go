@@ -0,0 +1,57 @@
+package database
+
+import (
+	"go.vxn.dev/xilt/internal/config"
+)
+
+// profilePragmas lists the PRAGMAs set on every connection of the write routine per durability profile. The auto-vacuum mode and the page size only take effect when the DB is created, and neither can be changed once the DB is in WAL mode, therefore they are set first, the page size before the auto-vacuum mode initializes the DB.
+//
+// The fast profile keeps the rollback journal, as WAL mode has issues with saving big (50k+) batch sizes and creating indexes on tables containing large amounts of logs.
+// https://github.com/Tencent/wcdb/issues/243
+// The other profiles use WAL with capped batch sizes instead. The readers profile additionally uses a smaller cache, keeps temporary tables in memory and memory-maps the DB.
+var profilePragmas = map[string][]string{
+	config.ProfileFast: {
+		"page_size(16384)",
+		"auto_vacuum(INCREMENTAL)",
+		"busy_timeout(60000)",
+		"journal_mode(DELETE)",
+		"synchronous(OFF)",
+		"cache_size(-65536)",
+		"temp_store(MEMORY)",
+		"mmap_size(268435456)",
+	},
+	config.ProfileSafe: {
+		"page_size(4096)",
+		"auto_vacuum(INCREMENTAL)",
+		"busy_timeout(60000)",
+		"journal_mode(WAL)",
+		"synchronous(NORMAL)",
+		"cache_size(-32768)",
+		"temp_store(DEFAULT)",
+		"mmap_size(0)",
+	},
+	config.ProfileReaders: {
+		"page_size(4096)",
+		"auto_vacuum(INCREMENTAL)",
+		"busy_timeout(60000)",
+		"journal_mode(WAL)",
+		"synchronous(NORMAL)",
+		"cache_size(-16384)",
+		"temp_store(MEMORY)",
+		"mmap_size(268435456)",
+	},
+}
+
+// profileParams returns the URI parameters setting the PRAGMAs of the provided durability profile. The fast profile is used if none is set.
+func profileParams(profile string) []string {
+	if profile == "" {
+		profile = config.ProfileFast
+	}
+
+	params := make([]string, 0, len(profilePragmas[profile]))
+	for _, pragma := range profilePragmas[profile] {
+		params = append(params, "_pragma="+pragma)
+	}
+
+	return params
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+func TestDB_InitProfiles(t *testing.T) {
+	tests := []struct {
+		profile     string
+		journalMode string
+		synchronous int
+		pageSize    int
+	}{
+		{profile: config.ProfileFast, journalMode: "delete", synchronous: 0, pageSize: 16384},
+		{profile: config.ProfileSafe, journalMode: "wal", synchronous: 1, pageSize: 4096},
+		{profile: config.ProfileReaders, journalMode: "wal", synchronous: 1, pageSize: 4096},
+	}
+
+	for _, tt := range tests {
+		db := NewDB(&mockLogger{}, &config.Config{
+			DBFilePath: filepath.Join(t.TempDir(), "profile.db"),
+			Profile:    tt.profile,
+		})
+
+		if err := db.Init(); err != nil {
+			t.Fatalf("%s: Init failed: %v", tt.profile, err)
+		}
+
+		var journalMode string
+		var synchronous, pageSize, autoVacuum int
+
+		if err := db.conn.QueryRow("PRAGMA journal_mode;").Scan(&journalMode); err != nil {
+			t.Errorf("%s: error querying journal_mode: %v", tt.profile, err)
+		}
+		if err := db.conn.QueryRow("PRAGMA synchronous;").Scan(&synchronous); err != nil {
+			t.Errorf("%s: error querying synchronous: %v", tt.profile, err)
+		}
+		if err := db.conn.QueryRow("PRAGMA page_size;").Scan(&pageSize); err != nil {
+			t.Errorf("%s: error querying page_size: %v", tt.profile, err)
+		}
+
+		if err := db.conn.QueryRow("PRAGMA auto_vacuum;").Scan(&autoVacuum); err != nil {
+			t.Errorf("%s: error querying auto_vacuum: %v", tt.profile, err)
+		}
+
+		if journalMode != tt.journalMode || synchronous != tt.synchronous || pageSize != tt.pageSize {
+			t.Errorf("%s: expected journal_mode %s, synchronous %d and page_size %d, got %s, %d and %d", tt.profile, tt.journalMode, tt.synchronous, tt.pageSize, journalMode, synchronous, pageSize)
+		}
+
+		// Incremental auto-vacuum is enabled by every profile, including the ones switching to WAL mode
+		if autoVacuum != 2 {
+			t.Errorf("%s: expected incremental auto_vacuum, got %d", tt.profile, autoVacuum)
+		}
+		if err := db.Vacuum(true); err != nil {
+			t.Errorf("%s: incremental Vacuum failed: %v", tt.profile, err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Errorf("%s: error closing DB: %v", tt.profile, err)
+		}
+	}
+}
+
+// BenchmarkDB_InsertBatchProfiles compares the write throughput of the durability profiles, using the capped batch size of each profile.
+func BenchmarkDB_InsertBatchProfiles(b *testing.B) {
+	const logCount = 100000
+
+	logs := make([]parser.Log, logCount)
+	for i := range logs {
+		logs[i] = parser.Log{
+			IP:            "127.0.0.1",
+			Identity:      "user-identifier",
+			User:          "frank",
+			Time:          "10/Oct/2000:13:55:36 -0700",
+			TimestampUTC:  "2000-10-10T20:55:36Z",
+			TimestampUnix: 971211336,
+			Method:        "GET",
+			Route:         "/apache_pb.gif",
+			Params:        "-",
+			ResponseCode:  200,
+			BytesSent:     2326,
+			Referer:       "-",
+			Agent:         "-",
+		}
+	}
+
+	for _, profile := range []string{config.ProfileFast, config.ProfileSafe, config.ProfileReaders} {
+		b.Run(profile, func(b *testing.B) {
+			fs := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+
+			cfg, err := config.Load(fs, []string{"-profile=" + profile, "-batchSize=5000"})
+			if err != nil {
+				b.Fatalf("error loading config: %v", err)
+			}
+
+			batches := make([]parser.Batch, 0, len(logs)/cfg.BatchSize+1)
+			for start := 0; start < len(logs); start += cfg.BatchSize {
+				batches = append(batches, parser.Batch{Logs: logs[start:min(start+cfg.BatchSize, len(logs))]})
+			}
+
+			for i := 0; i < b.N; i++ {
+				cfg.DBFilePath = filepath.Join(b.TempDir(), fmt.Sprintf("benchmark%d.db", i))
+
+				db := NewDB(&mockLogger{}, cfg)
+				if err := db.Init(); err != nil {
+					b.Fatalf("Init failed: %v", err)
+				}
+
+				insertTestBatches(db, batches...)
+
+				if err := db.Close(); err != nil {
+					b.Errorf("error closing DB: %v", err)
+				}
+			}
+
+			b.ReportMetric(float64(logCount*b.N)/b.Elapsed().Seconds(), "logs/s")
+		})
+	}
+}
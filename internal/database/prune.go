@@ -0,0 +1,313 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+const (
+	selectPruneStatement       = "SELECT ID, IP, TimestampUnix, Route, Agent, ResponseCode, BytesSent FROM %s WHERE %s ORDER BY ID LIMIT ?"
+	deletePruneStatement       = "DELETE FROM %s WHERE ID <= ? AND (%s)"
+	deleteOrphansStatement     = "DELETE FROM %s WHERE ID NOT IN (%s)"
+	deleteEmptyRollupStatement = "DELETE FROM %s WHERE Requests <= 0"
+)
+
+// PruneOptions holds the limits of the logs kept in the DB. Logs older than Before or beyond the MaxRows most recently inserted logs are deleted in transactions of ChunkSize logs. Zero values of the limits are ignored.
+type PruneOptions struct {
+	Before    time.Time
+	MaxRows   int64
+	ChunkSize int
+}
+
+// Prune deletes the logs exceeding the provided limits and returns their count. The logs are deleted in chunks, so that the DB is never locked for long, and the rollup, full-text search and query parameters tables are updated along with them. Partitions older than the age limit are dropped as a whole. In the normalized schema mode, the lookup values no longer referenced by any log are deleted at the end.
+func (d *db) Prune(opts PruneOptions) (int64, error) {
+	where, args, err := d.pruneCondition(opts)
+	if err != nil {
+		return 0, err
+	}
+	if where == "" {
+		return 0, nil
+	}
+
+	partitions := []partition{{name: d.schema.logTable()}}
+
+	if d.schema.partition != "" {
+		if partitions, err = listPartitions(d.conn, "main"); err != nil {
+			return 0, err
+		}
+	}
+
+	var deleted int64
+
+	for _, p := range partitions {
+		var n int64
+
+		if d.schema.partition != "" && !opts.Before.IsZero() && p.end <= opts.Before.Unix() {
+			n, err = d.prunePartition(p.name)
+		} else {
+			n, err = d.pruneTable(p.name, where, args, opts.ChunkSize)
+		}
+
+		deleted += n
+
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	if deleted > 0 && d.schema.normalized {
+		if err := d.pruneDimensions(); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// pruneCondition returns the condition matching the logs which exceed the provided limits along with its args. An empty condition is returned if no log exceeds them.
+func (d *db) pruneCondition(opts PruneOptions) (string, []any, error) {
+	conditions := make([]string, 0, 2)
+	args := make([]any, 0, 2)
+
+	if !opts.Before.IsZero() {
+		conditions = append(conditions, "TimestampUnix < ?")
+		args = append(args, opts.Before.Unix())
+	}
+
+	if opts.MaxRows > 0 {
+		// The most recently inserted log beyond the kept ones is looked up once in the logs view, which covers all partitions, so that logs inserted during pruning are not affected
+		var lastID int64
+
+		err := d.conn.QueryRow(fmt.Sprintf("SELECT ID FROM %s ORDER BY ID DESC LIMIT 1 OFFSET ?", flatLogTable), opts.MaxRows).Scan(&lastID)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return "", nil, fmt.Errorf("failed to query logs: %w", err)
+		default:
+			conditions = append(conditions, "ID <= ?")
+			args = append(args, lastID)
+		}
+	}
+
+	return strings.Join(conditions, " OR "), args, nil
+}
+
+// pruneTable deletes the logs of the table matching the condition in chunks of the provided size.
+func (d *db) pruneTable(table, where string, args []any, size int) (int64, error) {
+	var deleted int64
+
+	for {
+		n, err := d.pruneChunk(table, where, args, size)
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += n
+
+		d.logger.Debugf("pruned chunk of %d logs from %s", n, table)
+
+		if n < int64(size) {
+			return deleted, nil
+		}
+	}
+}
+
+// pruneChunk deletes up to size of the oldest inserted logs of the table matching the condition in a single transaction and subtracts them from the rollup tables. It returns the number of deleted logs.
+func (d *db) pruneChunk(table, where string, args []any, size int) (deleted int64, err error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				d.logger.Printf("failed to roll back transaction: %v", rollbackErr)
+			}
+		}
+	}()
+
+	rows, err := tx.Query(fmt.Sprintf(selectPruneStatement, d.schema.flatSource(table), where), append(args, size)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query logs: %w", err)
+	}
+
+	var (
+		lastID  int64
+		rollups parser.Rollups
+	)
+
+	if d.schema.rollupInterval > 0 {
+		rollups = make(parser.Rollups)
+	}
+
+	for rows.Next() {
+		var l parser.Log
+
+		if err := rows.Scan(&lastID, &l.IP, &l.TimestampUnix, &l.Route, &l.Agent, &l.ResponseCode, &l.BytesSent); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan log: %w", err)
+		}
+
+		if rollups != nil {
+			rollups.Subtract(&l, d.schema.rollupInterval)
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query logs: %w", err)
+	}
+
+	if lastID == 0 {
+		return 0, tx.Commit()
+	}
+
+	// The full-text search table is kept in sync by the delete trigger
+	res, err := tx.Exec(fmt.Sprintf(deletePruneStatement, table, where), append([]any{lastID}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete logs: %w", err)
+	}
+
+	if deleted, err = res.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("failed to delete logs: %w", err)
+	}
+
+	if rollups != nil {
+		if err := d.mergeRollups(tx, rollups); err != nil {
+			return 0, err
+		}
+
+		if err := deleteEmptyRollups(tx); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// prunePartition drops the partition in a single transaction after subtracting its logs from the rollup tables. It returns the number of deleted logs.
+func (d *db) prunePartition(name string) (deleted int64, err error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				d.logger.Printf("failed to roll back transaction: %v", rollbackErr)
+			}
+			if loadErr := d.loadPartitions(); loadErr != nil {
+				d.logger.Printf("failed to reload partitions: %v", loadErr)
+			}
+		}
+	}()
+
+	if err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s;", name)).Scan(&deleted); err != nil {
+		return 0, fmt.Errorf("failed to query partition %s: %w", name, err)
+	}
+
+	if d.schema.rollupInterval > 0 {
+		if err := d.aggregateRollups(tx, d.schema.flatSource(name), -1); err != nil {
+			return 0, err
+		}
+
+		if err := deleteEmptyRollups(tx); err != nil {
+			return 0, err
+		}
+	}
+
+	// Dropping a table does not fire the delete triggers, therefore the logs have to be deleted from the full-text search and query parameters tables first
+	if d.schema.fts || d.schema.params {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s;", name)); err != nil {
+			return 0, fmt.Errorf("failed to delete logs: %w", err)
+		}
+	}
+
+	if err := d.dropPartition(tx, name); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	d.logger.Debugf("dropped partition %s of %d logs", name, deleted)
+
+	return deleted, nil
+}
+
+// deleteEmptyRollups deletes the rollup rows which no longer aggregate any log.
+func deleteEmptyRollups(q queryer) error {
+	for _, t := range rollupTables {
+		if _, err := q.Exec(fmt.Sprintf(deleteEmptyRollupStatement, t.name)); err != nil {
+			return fmt.Errorf("failed to delete empty rollups: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pruneDimensions deletes the values of the lookup tables which are no longer referenced by any log.
+func (d *db) pruneDimensions() error {
+	tables, err := d.logTables()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range d.schema.columns {
+		if c.dimension == "" {
+			continue
+		}
+
+		name := d.schema.storedName(c)
+
+		// All values are unused once all partitions are dropped
+		references := []string{"SELECT NULL WHERE 0"}
+		for _, table := range tables {
+			references = append(references, fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL", name, table, name))
+		}
+
+		if _, err := d.conn.Exec(fmt.Sprintf(deleteOrphansStatement, c.dimension, unionAll(references))); err != nil {
+			return fmt.Errorf("failed to delete unused values of %s: %w", c.dimension, err)
+		}
+	}
+
+	return nil
+}
+
+// Vacuum releases the space freed by deleted logs. A full vacuum rebuilds the whole DB file, while an incremental one only releases the free pages, which requires the DB to be created with incremental auto-vacuum.
+func (d *db) Vacuum(incremental bool) error {
+	if !incremental {
+		if _, err := d.conn.Exec("VACUUM;"); err != nil {
+			return fmt.Errorf("failed to vacuum DB: %w", err)
+		}
+		return nil
+	}
+
+	var mode int
+	if err := d.conn.QueryRow("PRAGMA auto_vacuum;").Scan(&mode); err != nil {
+		return fmt.Errorf("failed to query auto_vacuum: %w", err)
+	}
+
+	// 2 stands for incremental auto-vacuum
+	if mode != 2 {
+		return fmt.Errorf("the DB was not created with incremental auto-vacuum enabled, a full vacuum has to be used instead")
+	}
+
+	if _, err := d.conn.Exec("PRAGMA incremental_vacuum;"); err != nil {
+		return fmt.Errorf("failed to vacuum DB: %w", err)
+	}
+
+	return nil
+}
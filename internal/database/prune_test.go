@@ -0,0 +1,112 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+func TestDB_Prune(t *testing.T) {
+	tests := []struct {
+		name      string
+		normalize bool
+		partition string
+	}{
+		{name: "flat"},
+		{name: "normalized", normalize: true},
+		{name: "partitioned", partition: config.PartitionDay},
+		{name: "normalized partitioned", normalize: true, partition: config.PartitionDay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewDB(&mockLogger{}, &config.Config{
+				Verbose:        false,
+				DBFilePath:     filepath.Join(t.TempDir(), "prune.db"),
+				FullTextSearch: true,
+				RollupInterval: time.Hour,
+				Normalize:      tt.normalize,
+				Partition:      tt.partition,
+			})
+
+			if err := db.Init(); err != nil {
+				t.Errorf("Init failed: %v", err)
+			}
+
+			rollups := make(parser.Rollups)
+			for i := range searchTestLogs {
+				rollups.Add(&searchTestLogs[i], 3600)
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: searchTestLogs, Rollups: rollups})
+
+			// Only the first log is older, the chunk size of 1 makes sure that pruning stops once no log is left to be deleted
+			deleted, err := db.Prune(PruneOptions{Before: time.Unix(971211400, 0), ChunkSize: 1})
+			if err != nil {
+				t.Errorf("Prune failed: %v", err)
+			}
+			if deleted != 1 {
+				t.Errorf("expected 1 log to be pruned, got %d", deleted)
+			}
+
+			var requests, wpLoginRows int
+
+			if err := db.conn.QueryRow("SELECT SUM(Requests) FROM rollup_routes;").Scan(&requests); err != nil {
+				t.Errorf("error querying rollups: %v", err)
+			}
+			if err := db.conn.QueryRow("SELECT COUNT(*) FROM rollup_routes WHERE Route = '/wp-login.php';").Scan(&wpLoginRows); err != nil {
+				t.Errorf("error querying rollups: %v", err)
+			}
+
+			// The rollup row of the pruned log is deleted as it no longer aggregates any log
+			if requests != 2 || wpLoginRows != 1 {
+				t.Errorf("expected 2 requests in 2 rollup rows, got %d requests and %d rows of /wp-login.php", requests, wpLoginRows)
+			}
+
+			results, err := db.Search(SearchQuery{Match: `"curl"`})
+			if err != nil {
+				t.Errorf("search failed: %v", err)
+			}
+			if len(results) != 0 {
+				t.Errorf("expected pruned logs to be removed from the full-text search table, got %+v", results)
+			}
+
+			if tt.normalize {
+				var agents int
+				if err := db.conn.QueryRow("SELECT COUNT(*) FROM agents;").Scan(&agents); err != nil {
+					t.Errorf("error querying agents: %v", err)
+				}
+				if agents != 2 {
+					t.Errorf("expected the unused agent to be pruned, got %d agents", agents)
+				}
+			}
+
+			deleted, err = db.Prune(PruneOptions{MaxRows: 1, ChunkSize: 10})
+			if err != nil {
+				t.Errorf("Prune failed: %v", err)
+			}
+			if deleted != 1 {
+				t.Errorf("expected 1 log to be pruned, got %d", deleted)
+			}
+
+			var id int64
+			if err := db.conn.QueryRow("SELECT ID FROM logs;").Scan(&id); err != nil {
+				t.Errorf("error querying logs: %v", err)
+			}
+			if id != 3 {
+				t.Errorf("expected the most recently inserted log to be kept, got log %d", id)
+			}
+
+			if err := db.Vacuum(true); err != nil {
+				t.Errorf("Vacuum failed: %v", err)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Errorf("error closing DB: %v", err)
+			}
+		})
+	}
+}
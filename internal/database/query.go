@@ -0,0 +1,55 @@
+package database
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+const (
+	queryStatement = "SELECT " + resultColumns + " FROM %s l WHERE %s"
+)
+
+// LogQuery holds the filters of a query listing the stored logs. Zero values of the filters are ignored, except for the CIDR range, which is required.
+type LogQuery struct {
+	CIDR      netip.Prefix
+	From      time.Time
+	To        time.Time
+	StatusMin uint16
+	StatusMax uint16
+	Limit     int
+}
+
+// Query returns the logs whose IPs belong to the CIDR range and which match the other filters, ordered by their IDs. If the DB stores the binary IPs, the range is looked up by them (using their index if created), otherwise every IP is checked by the ip_in_cidr function.
+func (d *db) Query(q LogQuery) ([]Result, error) {
+	if !q.CIDR.IsValid() {
+		return nil, fmt.Errorf("the CIDR range must be provided")
+	}
+
+	var condition string
+	var args []any
+
+	if d.schema.ips {
+		first, last := prefixRange(q.CIDR)
+		condition = "l.IPBinary BETWEEN ? AND ?"
+		args = []any{first, last}
+	} else {
+		condition = ipInCIDRFunction + "(l.IP, ?)"
+		args = []any{q.CIDR.String()}
+	}
+
+	query, args := appendFilters(fmt.Sprintf(queryStatement, flatLogTable, condition), args, q.From, q.To, q.StatusMin, q.StatusMax, q.Limit)
+
+	rows, err := d.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs: %w", err)
+	}
+	defer rows.Close()
+
+	results, err := scanResults(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query logs: %w", err)
+	}
+
+	return results, nil
+}
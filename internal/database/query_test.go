@@ -0,0 +1,151 @@
+package database
+
+import (
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+// queryTestLogs returns logs from the provided IPs. If binary is set, the binary forms of the valid IPs are set as well.
+func queryTestLogs(binary bool, ips ...string) []parser.Log {
+	logs := make([]parser.Log, 0, len(ips))
+
+	for i, ip := range ips {
+		l := parser.Log{IP: ip, TimestampUnix: 971211336 + int64(i), Method: "GET", Route: "/", ResponseCode: 200}
+
+		if addr, err := netip.ParseAddr(ip); binary && err == nil {
+			b := addr.As16()
+			l.IPBinary = b[:]
+		} else if binary {
+			l.IPInvalid = true
+		}
+
+		logs = append(logs, l)
+	}
+
+	return logs
+}
+
+func TestDB_Query(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{name: "text"},
+		{name: "binary", cfg: config.Config{ParseIPs: true, Strict: true}},
+		{name: "binary partitioned", cfg: config.Config{ParseIPs: true, Partition: config.PartitionDay, CreateIndexes: true}},
+	}
+
+	ips := []string{"10.0.0.1", "10.255.255.255", "11.0.0.1", "9.255.255.255", "2001:db8::1", "2001:db9::1", "host.example.com"}
+
+	queries := []struct {
+		cidr     string
+		expected []string
+	}{
+		{cidr: "10.0.0.0/8", expected: []string{"10.0.0.1", "10.255.255.255"}},
+		{cidr: "10.0.0.1/32", expected: []string{"10.0.0.1"}},
+		{cidr: "0.0.0.0/0", expected: []string{"10.0.0.1", "10.255.255.255", "11.0.0.1", "9.255.255.255"}},
+		{cidr: "2001:db8::/32", expected: []string{"2001:db8::1"}},
+		// IPv4 addresses belong to IPv6 ranges as IPv4-mapped addresses
+		{cidr: "::/0", expected: []string{"10.0.0.1", "10.255.255.255", "11.0.0.1", "9.255.255.255", "2001:db8::1", "2001:db9::1"}},
+		{cidr: "::ffff:10.0.0.0/104", expected: []string{"10.0.0.1", "10.255.255.255"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.DBFilePath = filepath.Join(t.TempDir(), "query.db")
+
+			db := NewDB(&mockLogger{}, &cfg)
+			defer db.Close()
+
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: queryTestLogs(cfg.ParseIPs, ips...)})
+
+			if stats := db.Stats(); stats.Inserted != int64(len(ips)) {
+				t.Fatalf("expected %d logs to be inserted, got %+v", len(ips), stats)
+			}
+
+			if cfg.CreateIndexes {
+				if err := db.CreateIndexes(); err != nil {
+					t.Fatalf("error creating indexes: %v", err)
+				}
+			}
+
+			for _, q := range queries {
+				results, err := db.Query(LogQuery{CIDR: netip.MustParsePrefix(q.cidr)})
+				if err != nil {
+					t.Fatalf("error querying logs: %v", err)
+				}
+
+				actual := make([]string, 0, len(results))
+				for _, r := range results {
+					actual = append(actual, r.IP)
+				}
+
+				if !slices.Equal(actual, q.expected) {
+					t.Errorf("%s: expected %v, got %v", q.cidr, q.expected, actual)
+				}
+			}
+
+			if results, err := db.Query(LogQuery{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Limit: 1}); err != nil || len(results) != 1 {
+				t.Errorf("expected 1 limited result, got %d (%v)", len(results), err)
+			}
+
+			if _, err := db.Query(LogQuery{}); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestIPInCIDR(t *testing.T) {
+	db := NewDB(&mockLogger{}, &config.Config{DBFilePath: filepath.Join(t.TempDir(), "function.db")})
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	mapped := netip.MustParseAddr("192.168.1.10").As16()
+
+	tests := []struct {
+		ip       any
+		cidr     any
+		expected any
+	}{
+		{ip: "192.168.1.10", cidr: "192.168.0.0/16", expected: int64(1)},
+		{ip: "192.168.1.10", cidr: "192.168.2.0/24", expected: int64(0)},
+		{ip: "::ffff:192.168.1.10", cidr: "192.168.1.0/24", expected: int64(1)},
+		{ip: mapped[:], cidr: "192.168.1.0/24", expected: int64(1)},
+		{ip: "2001:db8::1", cidr: "2001:db8::/32", expected: int64(1)},
+		{ip: "2001:db8::1", cidr: "10.0.0.0/8", expected: int64(0)},
+		{ip: "10.0.0.1", cidr: "::ffff:0:0/96", expected: int64(1)},
+		{ip: "host.example.com", cidr: "10.0.0.0/8", expected: int64(0)},
+		{ip: nil, cidr: "10.0.0.0/8", expected: nil},
+	}
+
+	for _, tt := range tests {
+		var actual any
+		if err := db.conn.QueryRow("SELECT ip_in_cidr(?, ?);", tt.ip, tt.cidr).Scan(&actual); err != nil {
+			t.Errorf("%v in %v: error querying function: %v", tt.ip, tt.cidr, err)
+			continue
+		}
+
+		if actual != tt.expected {
+			t.Errorf("%v in %v: expected %v, got %v", tt.ip, tt.cidr, tt.expected, actual)
+		}
+	}
+
+	var actual any
+	if err := db.conn.QueryRow("SELECT ip_in_cidr('10.0.0.1', '10.0.0.0');").Scan(&actual); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+const (
+	createRollupTableScript = `CREATE TABLE "%s" ("Bucket" INTEGER NOT NULL, "%s" TEXT NOT NULL, "Requests" INTEGER NOT NULL, "Bytes" INTEGER NOT NULL, "Status1xx" INTEGER NOT NULL, "Status2xx" INTEGER NOT NULL, "Status3xx" INTEGER NOT NULL, "Status4xx" INTEGER NOT NULL, "Status5xx" INTEGER NOT NULL, PRIMARY KEY("Bucket", "%s"));`
+	upsertRollupStatement   = `INSERT INTO %s (Bucket, %s, Requests, Bytes, Status1xx, Status2xx, Status3xx, Status4xx, Status5xx) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(Bucket, %s) DO UPDATE SET
+		Requests = Requests + excluded.Requests,
+		Bytes = Bytes + excluded.Bytes,
+		Status1xx = Status1xx + excluded.Status1xx,
+		Status2xx = Status2xx + excluded.Status2xx,
+		Status3xx = Status3xx + excluded.Status3xx,
+		Status4xx = Status4xx + excluded.Status4xx,
+		Status5xx = Status5xx + excluded.Status5xx`
+	aggregateRollupStatement = `INSERT INTO %s (Bucket, %s, Requests, Bytes, Status1xx, Status2xx, Status3xx, Status4xx, Status5xx)
+	SELECT (TimestampUnix / ?1) * ?1, %s, ?2 * COUNT(*), ?2 * COALESCE(SUM(BytesSent), 0),
+		?2 * SUM(ResponseCode BETWEEN 100 AND 199), ?2 * SUM(ResponseCode BETWEEN 200 AND 299), ?2 * SUM(ResponseCode BETWEEN 300 AND 399), ?2 * SUM(ResponseCode BETWEEN 400 AND 499), ?2 * SUM(ResponseCode BETWEEN 500 AND 599)
+	FROM %s WHERE true GROUP BY 1, 2
+	ON CONFLICT(Bucket, %s) DO UPDATE SET
+		Requests = Requests + excluded.Requests,
+		Bytes = Bytes + excluded.Bytes,
+		Status1xx = Status1xx + excluded.Status1xx,
+		Status2xx = Status2xx + excluded.Status2xx,
+		Status3xx = Status3xx + excluded.Status3xx,
+		Status4xx = Status4xx + excluded.Status4xx,
+		Status5xx = Status5xx + excluded.Status5xx`
+)
+
+// rollupTable describes the table storing the aggregates of a single rollup dimension.
+type rollupTable struct {
+	name   string
+	column string
+}
+
+var rollupTables = map[parser.RollupDimension]rollupTable{
+	parser.RollupRoute: {name: "rollup_routes", column: "Route"},
+	parser.RollupIP:    {name: "rollup_ips", column: "IP"},
+	parser.RollupAgent: {name: "rollup_agents", column: "Agent"},
+}
+
+// rollupTablesScript returns the SQL script creating the rollup tables.
+func rollupTablesScript() string {
+	var b strings.Builder
+
+	for _, dimension := range parser.RollupDimensions {
+		t := rollupTables[dimension]
+		fmt.Fprintf(&b, createRollupTableScript+"\n", t.name, t.column, t.column)
+	}
+
+	return b.String()
+}
+
+// mergeRollups merges the aggregates of a batch into the rollup tables within the provided transaction.
+func (d *db) mergeRollups(tx *sql.Tx, rollups parser.Rollups) error {
+	statements := make(map[parser.RollupDimension]*sql.Stmt, len(rollupTables))
+
+	defer func() {
+		for _, stmt := range statements {
+			stmt.Close()
+		}
+	}()
+
+	for dimension, t := range rollupTables {
+		stmt, err := tx.Prepare(fmt.Sprintf(upsertRollupStatement, t.name, t.column, t.column))
+		if err != nil {
+			return fmt.Errorf("failed to prepare rollup statement: %w", err)
+		}
+		statements[dimension] = stmt
+	}
+
+	for key, counts := range rollups {
+		// Aggregates consisting only of skipped duplicates are left out
+		if counts.Requests == 0 {
+			continue
+		}
+
+		c := counts.StatusClasses
+		if _, err := statements[key.Dimension].Exec(key.Bucket, key.Value, counts.Requests, counts.Bytes, c[0], c[1], c[2], c[3], c[4]); err != nil {
+			return fmt.Errorf("failed to merge rollups: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// aggregateRollups adds (sign 1) or subtracts (sign -1) the aggregates of all logs of the provided source (a table, view or subquery with the flat logs shape) to or from the rollup tables.
+func (d *db) aggregateRollups(q queryer, source string, sign int64) error {
+	for _, dimension := range parser.RollupDimensions {
+		t := rollupTables[dimension]
+
+		if _, err := q.Exec(fmt.Sprintf(aggregateRollupStatement, t.name, t.column, t.column, source, t.column), d.schema.rollupInterval, sign); err != nil {
+			return fmt.Errorf("failed to aggregate rollups: %w", err)
+		}
+	}
+
+	return nil
+}
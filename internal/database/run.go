@@ -0,0 +1,72 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	createRunTablesScript = `
+	CREATE TABLE "ingest_runs" ("ID" INTEGER NOT NULL, "StartedAt" TEXT, "FinishedAt" TEXT, "Version" TEXT, "Config" TEXT, "LinesRead" INTEGER, "LinesParsed" INTEGER, "LinesRejected" INTEGER, "LogsInserted" INTEGER, "DuplicatesSkipped" INTEGER, PRIMARY KEY("ID" AUTOINCREMENT));
+	CREATE TABLE "ingest_run_files" ("RunID" INTEGER NOT NULL REFERENCES "ingest_runs"("ID"), "Path" TEXT, "SizeBytes" INTEGER, "SHA256" TEXT);
+	`
+	insertRunStatement     = "INSERT INTO ingest_runs (StartedAt, Version, Config) VALUES (?, ?, ?)"
+	finishRunStatement     = "UPDATE ingest_runs SET FinishedAt = ?, LinesRead = ?, LinesParsed = ?, LinesRejected = ?, LogsInserted = ?, DuplicatesSkipped = ? WHERE ID = ?"
+	insertRunFileStatement = "INSERT INTO ingest_run_files (RunID, Path, SizeBytes, SHA256) VALUES (?, ?, ?, ?)"
+
+	runTimeLayout = time.RFC3339Nano
+)
+
+// RunFile describes an input file read during an ingestion run.
+type RunFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// RunSummary holds the results of an ingestion run recorded when the run is finished.
+type RunSummary struct {
+	Files    []RunFile
+	Read     int64
+	Parsed   int64
+	Rejected int64
+}
+
+// StartRun records the start of an ingestion run in the ingest_runs table. All logs inserted afterwards reference the recorded run.
+func (d *db) StartRun(startedAt time.Time, version string, configSnapshot string) error {
+	res, err := d.conn.Exec(insertRunStatement, startedAt.UTC().Format(runTimeLayout), version, configSnapshot)
+	if err != nil {
+		return fmt.Errorf("failed to record ingestion run: %w", err)
+	}
+
+	if d.runID, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to record ingestion run: %w", err)
+	}
+
+	d.logger.Debugf("ingestion run %d recorded...", d.runID)
+
+	return nil
+}
+
+// FinishRun records the end of the current ingestion run along with its input files and the counts of processed lines. It must not be called before the write routine is finished.
+func (d *db) FinishRun(summary RunSummary) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	_, err = tx.Exec(finishRunStatement, time.Now().UTC().Format(runTimeLayout), summary.Read, summary.Parsed, summary.Rejected, d.stats.Inserted, d.stats.Duplicates, d.runID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record end of ingestion run: %w", err)
+	}
+
+	for _, f := range summary.Files {
+		if _, err := tx.Exec(insertRunFileStatement, d.runID, f.Path, f.Size, f.SHA256); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record input file of ingestion run: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
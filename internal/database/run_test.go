@@ -0,0 +1,68 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+func TestDB_Run(t *testing.T) {
+	config := &config.Config{
+		Verbose:    false,
+		DBFilePath: ":memory:?cache=shared",
+	}
+
+	db := NewDB(&mockLogger{}, config)
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+
+	if err := db.StartRun(time.Now(), "v1.2.3", `{"BatchSize":5000}`); err != nil {
+		t.Errorf("error starting run: %v", err)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: []parser.Log{{IP: "127.0.0.1"}, {IP: "127.0.0.2"}}})
+
+	summary := RunSummary{
+		Files:    []RunFile{{Path: "access.log", Size: 1234, SHA256: "abc"}},
+		Read:     3,
+		Parsed:   2,
+		Rejected: 1,
+	}
+
+	if err := db.FinishRun(summary); err != nil {
+		t.Errorf("error finishing run: %v", err)
+	}
+
+	var version, finishedAt string
+	var read, parsed, rejected, inserted int64
+
+	row := db.conn.QueryRow("SELECT Version, FinishedAt, LinesRead, LinesParsed, LinesRejected, LogsInserted FROM ingest_runs WHERE ID = ?;", db.runID)
+	if err := row.Scan(&version, &finishedAt, &read, &parsed, &rejected, &inserted); err != nil {
+		t.Errorf("error querying ingestion run: %v", err)
+	}
+
+	if version != "v1.2.3" || finishedAt == "" || read != 3 || parsed != 2 || rejected != 1 || inserted != 2 {
+		t.Errorf("unexpected ingestion run: version=%s, finishedAt=%s, read=%d, parsed=%d, rejected=%d, inserted=%d", version, finishedAt, read, parsed, rejected, inserted)
+	}
+
+	var files int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM ingest_run_files WHERE RunID = ? AND SHA256 = 'abc';", db.runID).Scan(&files); err != nil {
+		t.Errorf("error querying ingestion run files: %v", err)
+	}
+	if files != 1 {
+		t.Errorf("expected 1 input file to be recorded, got %d", files)
+	}
+
+	var referencing int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM logs WHERE RunID = ?;", db.runID).Scan(&referencing); err != nil {
+		t.Errorf("error querying logs: %v", err)
+	}
+	if referencing != 2 {
+		t.Errorf("expected 2 logs to reference the run, got %d", referencing)
+	}
+}
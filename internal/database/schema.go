@@ -0,0 +1,632 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+const (
+	flatLogTable       = "logs"
+	normalizedLogTable = "log_entries"
+
+	createMetaTableScript = `CREATE TABLE "meta" ("Key" TEXT NOT NULL, "Value" TEXT, PRIMARY KEY("Key"));`
+	insertMetaStatement   = "INSERT INTO meta (Key, Value) VALUES (?, ?)"
+	selectMetaStatement   = "SELECT Key, Value FROM meta"
+	upsertMetaStatement   = "INSERT OR REPLACE INTO meta (Key, Value) VALUES (?, ?)"
+
+	metaNormalized = "normalized"
+	metaDedupe     = "dedupe"
+	metaRollup     = "rollup"
+	metaFTS        = "fts"
+	metaProvenance = "provenance"
+	metaNode       = "node"
+	metaPartition  = "partition"
+	metaStrict     = "strict"
+	metaAgents     = "agents"
+	metaGeoIP      = "geoip"
+	metaIPs        = "ips"
+	metaClientIP   = "clientip"
+	metaURLs       = "urls"
+	metaParams     = "params"
+	metaRoutes     = "routetemplate"
+	metaReferers   = "referers"
+	metaDurations  = "durations"
+	metaSessions   = "sessions"
+	metaBotClass   = "botclass"
+)
+
+// column describes a single column of the log table and how its value is extracted from a record. Columns with a dimension are stored as a foreign key to the dimension's lookup table in the normalized schema mode. The check constraint of the column is only enforced in the strict schema mode.
+type column struct {
+	name       string
+	definition string
+	dimension  string
+	check      string
+	value      func(r *record) any
+}
+
+// record is a parsed log along with the values assigned to it by the write routine.
+type record struct {
+	*parser.Log
+	runID int64
+}
+
+// index describes an index on the log table. The columns are referenced by their flat names and translated to the foreign key columns in the normalized schema mode.
+type index struct {
+	name    string
+	columns []string
+}
+
+// schema holds the layout of the log table(s) depending on the configured schema mode.
+type schema struct {
+	normalized bool
+	dedupe     bool
+	// rollupInterval is the size of the rollup tables' time buckets in seconds, 0 if rollups are disabled
+	rollupInterval int64
+	fts            bool
+	provenance     bool
+	agents         bool
+	ips            bool
+	// clientIP stores the client IPs resolved from a forwarding header along with the peer IPs
+	clientIP bool
+	// urls stores the decoded routes along with the flags of invalid and suspicious encodings
+	urls bool
+	// params maintains the log_params table holding the decoded query parameters of the logs, which reference the logs by the IDs assigned by the write routine
+	params bool
+	// routeTemplate stores the templates of the routes, which have their own lookup table in the normalized schema mode
+	routeTemplate bool
+	// referers stores the parts of the referers, the hosts having their own lookup table in the normalized schema mode
+	referers bool
+	// durations stores the request and upstream times along with the views of their percentiles
+	durations bool
+	// botClass stores the classes of the logs' clients assigned by the classification routine
+	botClass bool
+	// geoip maintains the ip_info table holding the location and autonomous system of the logs' IPs
+	geoip bool
+	node  bool
+	// sessions stores the IDs of the visitor sessions reconstructed by the sessions command, which adds the column to the existing log tables
+	sessions bool
+	// partition is the time span of the tables the logs are partitioned into (day or month), empty if the logs are stored in a single table
+	partition string
+	// strict enforces the column types and check constraints of the log table
+	strict  bool
+	columns []column
+}
+
+var (
+	logColumns = []column{
+		{name: "IP", definition: "TEXT", value: func(r *record) any { return r.IP }},
+		{name: "Identity", definition: "TEXT", value: func(r *record) any { return r.Identity }},
+		{name: "UserID", definition: "TEXT", value: func(r *record) any { return r.User }},
+		{name: "Time", definition: "TEXT", value: func(r *record) any { return r.Time }},
+		{name: "TimestampUTC", definition: "TEXT", value: func(r *record) any { return r.TimestampUTC }},
+		{name: "TimestampUnix", definition: "INTEGER", value: func(r *record) any { return r.TimestampUnix }},
+		{name: "Method", definition: "TEXT", check: "Method IN ('GET', 'HEAD', 'POST', 'PUT', 'DELETE', 'CONNECT', 'OPTIONS', 'TRACE', 'PATCH')", value: func(r *record) any { return r.Method }},
+		{name: "Route", definition: "TEXT", dimension: "routes", value: func(r *record) any { return r.Route }},
+		{name: "Params", definition: "TEXT", value: func(r *record) any { return r.Params }},
+		{name: "ResponseCode", definition: "INTEGER", check: "ResponseCode BETWEEN 100 AND 599", value: func(r *record) any { return r.ResponseCode }},
+		{name: "BytesSent", definition: "INTEGER", check: "BytesSent >= 0", value: func(r *record) any { return r.BytesSent }},
+		{name: "Referer", definition: "TEXT", dimension: "referers", value: func(r *record) any { return r.Referer }},
+		{name: "Agent", definition: "TEXT", dimension: "agents", value: func(r *record) any { return r.Agent }},
+		{name: "RunID", definition: `INTEGER REFERENCES "ingest_runs"("ID")`, value: func(r *record) any { return nullIfZero(r.runID) }},
+	}
+
+	logIndexes = []index{
+		{name: "idx_logs_ip", columns: []string{"IP"}},
+		{name: "idx_logs_ts", columns: []string{"TimestampUTC"}},
+		{name: "idx_logs_ts_unix", columns: []string{"TimestampUnix"}},
+		{name: "idx_logs_method", columns: []string{"Method"}},
+		{name: "idx_logs_route", columns: []string{"Route"}},
+		{name: "idx_logs_referer", columns: []string{"Referer"}},
+		{name: "idx_logs_ts_ip", columns: []string{"TimestampUTC", "IP"}},
+	}
+
+	hashColumn = column{name: "Hash", definition: "BLOB", value: func(r *record) any { return r.Hash }}
+
+	provenanceColumns = []column{
+		{name: "SourceFile", definition: "TEXT", dimension: "source_files", value: func(r *record) any { return r.SourceFile }},
+		{name: "LineNumber", definition: "INTEGER", value: func(r *record) any { return r.LineNumber }},
+		{name: "ByteOffset", definition: "INTEGER", value: func(r *record) any { return r.ByteOffset }},
+	}
+
+	agentColumns = []column{
+		{name: "Browser", definition: "TEXT", value: func(r *record) any { return r.Browser }},
+		{name: "BrowserVersion", definition: "TEXT", value: func(r *record) any { return r.BrowserVersion }},
+		{name: "OS", definition: "TEXT", value: func(r *record) any { return r.OS }},
+		{name: "DeviceType", definition: "TEXT", value: func(r *record) any { return r.DeviceType }},
+		{name: "IsBot", definition: "INTEGER", check: "IsBot IN (0, 1)", value: func(r *record) any { return r.IsBot }},
+	}
+
+	// Invalid IPs have no binary form
+	ipColumns = []column{
+		{name: "IPBinary", definition: "BLOB", check: "length(IPBinary) = 16", value: func(r *record) any { return nullIfEmptyBlob(r.IPBinary) }},
+		{name: "IPInvalid", definition: "INTEGER", check: "IPInvalid IN (0, 1)", value: func(r *record) any { return r.IPInvalid }},
+	}
+
+	// The binary IPs are indexed for CIDR range queries
+	ipIndex = index{name: "idx_logs_ip_binary", columns: []string{"IPBinary"}}
+
+	clientIPColumn = column{name: "ClientIP", definition: "TEXT", value: func(r *record) any { return r.ClientIP }}
+	clientIPIndex  = index{name: "idx_logs_client_ip", columns: []string{"ClientIP"}}
+
+	routeTemplateColumn = column{name: "RouteTemplate", definition: "TEXT", dimension: "route_templates", value: func(r *record) any { return r.RouteTemplate }}
+	routeTemplateIndex  = index{name: "idx_logs_route_template", columns: []string{"RouteTemplate"}}
+
+	refererColumns = []column{
+		{name: "RefererHost", definition: "TEXT", dimension: "referer_hosts", value: func(r *record) any { return r.RefererHost }},
+		{name: "RefererPath", definition: "TEXT", value: func(r *record) any { return r.RefererPath }},
+		{name: "RefererInternal", definition: "INTEGER", check: "RefererInternal IN (0, 1)", value: func(r *record) any { return r.RefererInternal }},
+		{name: "SearchEngine", definition: "TEXT", value: func(r *record) any { return r.SearchEngine }},
+		{name: "SearchQuery", definition: "TEXT", value: func(r *record) any { return r.SearchQuery }},
+	}
+	refererHostIndex = index{name: "idx_logs_referer_host", columns: []string{"RefererHost"}}
+
+	// Durations which are not logged are NULL
+	durationColumns = []column{
+		{name: "RequestTimeUs", definition: "INTEGER", check: "RequestTimeUs >= 0", value: func(r *record) any { return nullIfNegative(r.RequestTimeUs) }},
+		{name: "UpstreamTimeUs", definition: "INTEGER", check: "UpstreamTimeUs >= 0", value: func(r *record) any { return nullIfNegative(r.UpstreamTimeUs) }},
+	}
+
+	botClassColumn = column{name: "BotClass", definition: "TEXT", check: "BotClass IN ('human', 'verified-bot', 'claimed-bot', 'suspicious')", value: func(r *record) any { return r.BotClass }}
+
+	urlColumns = []column{
+		{name: "RouteDecoded", definition: "TEXT", value: func(r *record) any { return r.RouteDecoded }},
+		{name: "ParamsDecoded", definition: "TEXT", value: func(r *record) any { return r.ParamsDecoded }},
+		{name: "URLInvalid", definition: "INTEGER", check: "URLInvalid IN (0, 1)", value: func(r *record) any { return r.URLInvalid }},
+		{name: "URLDoubleEncoded", definition: "INTEGER", check: "URLDoubleEncoded IN (0, 1)", value: func(r *record) any { return r.URLDoubleEncoded }},
+		{name: "URLNullByte", definition: "INTEGER", check: "URLNullByte IN (0, 1)", value: func(r *record) any { return r.URLNullByte }},
+	}
+
+	// The node is only stored in merged DBs, whose logs are copied by the merge command directly in SQL
+	nodeColumn = column{name: "Node", definition: "TEXT", dimension: "nodes", value: func(r *record) any { return nil }}
+
+	// The sessions are assigned after ingestion by the sessions command, which appends the column to the existing log tables, therefore it is the last one
+	sessionColumn = column{name: "SessionID", definition: "INTEGER", value: func(r *record) any { return nil }}
+)
+
+// newSchema returns the schema matching the provided config.
+func newSchema(cfg *config.Config) *schema {
+	s := &schema{
+		normalized:     cfg.Normalize,
+		dedupe:         cfg.Dedupe,
+		rollupInterval: int64(cfg.RollupInterval.Seconds()),
+		fts:            cfg.FullTextSearch,
+		provenance:     cfg.Provenance,
+		agents:         cfg.ParseAgents,
+		geoip:          cfg.GeoIP(),
+		ips:            cfg.ParseIPs,
+		clientIP:       cfg.ForwardedHeader != "",
+		urls:           cfg.DecodeURLs,
+		params:         cfg.ParamsTable,
+		routeTemplate:  cfg.RouteTemplates,
+		referers:       cfg.ParseReferers,
+		durations:      cfg.DurationUnit != "",
+		botClass:       cfg.ClassifyBots,
+		partition:      cfg.Partition,
+		strict:         cfg.Strict,
+	}
+	s.build()
+
+	return s
+}
+
+// build assembles the columns of the log table according to the schema options.
+func (s *schema) build() {
+	s.columns = append(make([]column, 0, len(logColumns)+2+len(provenanceColumns)+len(agentColumns)+len(ipColumns)+1+len(urlColumns)+2+len(refererColumns)+len(durationColumns)+3), logColumns...)
+
+	if s.dedupe {
+		s.columns = append(s.columns, hashColumn)
+	}
+
+	if s.provenance {
+		s.columns = append(s.columns, provenanceColumns...)
+	}
+
+	if s.agents {
+		s.columns = append(s.columns, agentColumns...)
+	}
+
+	if s.ips {
+		s.columns = append(s.columns, ipColumns...)
+	}
+
+	if s.clientIP {
+		s.columns = append(s.columns, clientIPColumn)
+	}
+
+	if s.urls {
+		s.columns = append(s.columns, urlColumns...)
+	}
+
+	if s.routeTemplate {
+		s.columns = append(s.columns, routeTemplateColumn)
+	}
+
+	if s.referers {
+		s.columns = append(s.columns, refererColumns...)
+	}
+
+	if s.durations {
+		s.columns = append(s.columns, durationColumns...)
+	}
+
+	if s.botClass {
+		s.columns = append(s.columns, botClassColumn)
+	}
+
+	if s.node {
+		s.columns = append(s.columns, nodeColumn)
+	}
+
+	if s.sessions {
+		s.columns = append(s.columns, sessionColumn)
+	}
+}
+
+// loadSchema reconstructs the schema of an existing database from its meta table.
+func loadSchema(conn *sql.DB) (*schema, error) {
+	rows, err := conn.Query(selectMetaStatement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read meta table: %w", err)
+	}
+	defer rows.Close()
+
+	s := &schema{}
+
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan meta table: %w", err)
+		}
+
+		switch key {
+		case metaNormalized:
+			s.normalized = value.String == "1"
+		case metaDedupe:
+			s.dedupe = value.String == "1"
+		case metaRollup:
+			if s.rollupInterval, err = strconv.ParseInt(value.String, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid rollup interval in meta table: %w", err)
+			}
+		case metaFTS:
+			s.fts = value.String == "1"
+		case metaProvenance:
+			s.provenance = value.String == "1"
+		case metaAgents:
+			s.agents = value.String == "1"
+		case metaIPs:
+			s.ips = value.String == "1"
+		case metaClientIP:
+			s.clientIP = value.String == "1"
+		case metaURLs:
+			s.urls = value.String == "1"
+		case metaParams:
+			s.params = value.String == "1"
+		case metaRoutes:
+			s.routeTemplate = value.String == "1"
+		case metaReferers:
+			s.referers = value.String == "1"
+		case metaDurations:
+			s.durations = value.String == "1"
+		case metaBotClass:
+			s.botClass = value.String == "1"
+		case metaGeoIP:
+			s.geoip = value.String == "1"
+		case metaNode:
+			s.node = value.String == "1"
+		case metaSessions:
+			s.sessions = value.String == "1"
+		case metaPartition:
+			s.partition = value.String
+		case metaStrict:
+			s.strict = value.String == "1"
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read meta table: %w", err)
+	}
+
+	s.build()
+
+	return s, nil
+}
+
+// meta returns the key-value pairs describing the schema, which are stored in the meta table so that the schema can be reconstructed later.
+func (s *schema) meta() map[string]string {
+	return map[string]string{
+		metaNormalized: boolToMeta(s.normalized),
+		metaDedupe:     boolToMeta(s.dedupe),
+		metaRollup:     strconv.FormatInt(s.rollupInterval, 10),
+		metaFTS:        boolToMeta(s.fts),
+		metaProvenance: boolToMeta(s.provenance),
+		metaAgents:     boolToMeta(s.agents),
+		metaGeoIP:      boolToMeta(s.geoip),
+		metaIPs:        boolToMeta(s.ips),
+		metaClientIP:   boolToMeta(s.clientIP),
+		metaURLs:       boolToMeta(s.urls),
+		metaParams:     boolToMeta(s.params),
+		metaRoutes:     boolToMeta(s.routeTemplate),
+		metaReferers:   boolToMeta(s.referers),
+		metaDurations:  boolToMeta(s.durations),
+		metaBotClass:   boolToMeta(s.botClass),
+		metaNode:       boolToMeta(s.node),
+		metaSessions:   boolToMeta(s.sessions),
+		metaPartition:  s.partition,
+		metaStrict:     boolToMeta(s.strict),
+	}
+}
+
+// logTable returns the name of the table the logs are written to. In the normalized schema mode, the logs table is replaced by a view reconstructing the flat shape. If the logs are partitioned, it is the prefix of the partitions' tables.
+func (s *schema) logTable() string {
+	if s.normalized {
+		return normalizedLogTable
+	}
+	return flatLogTable
+}
+
+// assignsIDs reports whether the IDs of the logs are assigned by the write routine instead of SQLite, which is needed for them to be unique across partitions and known to the rows referencing the logs (e.g. the query parameters).
+func (s *schema) assignsIDs() bool {
+	return s.partition != "" || s.params
+}
+
+// storedName returns the name under which the column is stored in the log table.
+func (s *schema) storedName(c column) string {
+	if s.normalized && c.dimension != "" {
+		return c.name + "ID"
+	}
+	return c.name
+}
+
+// dimensions returns the names of the lookup tables used by the schema.
+func (s *schema) dimensions() []string {
+	if !s.normalized {
+		return nil
+	}
+
+	dimensions := make([]string, 0)
+	for _, c := range s.columns {
+		if c.dimension != "" {
+			dimensions = append(dimensions, c.dimension)
+		}
+	}
+	return dimensions
+}
+
+// createScript returns the SQL script creating the log table and, depending on the schema options, the lookup tables, the flat logs view, the partitions table, the rollup tables, the full-text search table, the IP information table, the query parameters table and the duration views.
+func (s *schema) createScript() string {
+	var b strings.Builder
+
+	b.WriteString(createRunTablesScript)
+
+	for _, dimension := range s.dimensions() {
+		fmt.Fprintf(&b, `CREATE TABLE "%s" ("ID" INTEGER NOT NULL, "Value" TEXT NOT NULL UNIQUE, PRIMARY KEY("ID"));`+"\n", dimension)
+	}
+
+	if s.partition != "" {
+		// The partitions are created by the write routine, the logs view combining them is recreated with every new one
+		b.WriteString(createPartitionsTableScript + "\n")
+		fmt.Fprintf(&b, "CREATE VIEW %s AS %s;\n", flatLogTable, s.emptySelect())
+	} else {
+		b.WriteString(s.logTableScript(s.logTable()))
+
+		if s.normalized {
+			fmt.Fprintf(&b, "CREATE VIEW %s AS %s;\n", flatLogTable, s.flatSelect(normalizedLogTable))
+		}
+	}
+
+	if s.rollupInterval > 0 {
+		b.WriteString(rollupTablesScript())
+	}
+
+	if s.fts {
+		b.WriteString(s.ftsScript())
+	}
+
+	if s.geoip {
+		b.WriteString(createIPInfoTableScript + "\n")
+	}
+
+	if s.durations {
+		b.WriteString(s.durationViewsScript())
+	}
+
+	// The parameters of partitioned logs are deleted by the triggers created along with the partitions
+	if s.params {
+		b.WriteString(createParamsTableScript + "\n")
+
+		if s.partition == "" {
+			b.WriteString(paramsTriggerScript(s.logTable()))
+		}
+	}
+
+	return b.String()
+}
+
+// logTableScript returns the SQL script creating a table storing logs, i.e. the log table or a partition.
+func (s *schema) logTableScript(table string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, `CREATE TABLE "%s" ("ID" INTEGER NOT NULL, `, table)
+	for _, c := range s.columns {
+		if s.normalized && c.dimension != "" {
+			fmt.Fprintf(&b, `"%s" INTEGER REFERENCES "%s"("ID"), `, s.storedName(c), c.dimension)
+			continue
+		}
+		fmt.Fprintf(&b, `"%s" %s`, c.name, c.definition)
+		if s.strict && c.check != "" {
+			fmt.Fprintf(&b, " CHECK (%s)", c.check)
+		}
+		b.WriteString(", ")
+	}
+	b.WriteString(`PRIMARY KEY("ID" AUTOINCREMENT))`)
+	if s.strict {
+		b.WriteString(" STRICT")
+	}
+	b.WriteString(";\n")
+
+	// The unique index on the hashes is needed for skipping duplicates, therefore it is created regardless of the indexes being enabled. Duplicates always share the timestamp, so they can only collide within a partition.
+	if s.dedupe {
+		fmt.Fprintf(&b, "CREATE UNIQUE INDEX %s ON %s(Hash);\n", s.indexName("idx_logs_hash", table), table)
+	}
+
+	return b.String()
+}
+
+// indexName returns the name of the index on the provided table. The indexes of partitions are prefixed by the partition's name, as index names are unique within the whole DB.
+func (s *schema) indexName(name, table string) string {
+	if table == s.logTable() {
+		return name
+	}
+	return "idx_" + table + strings.TrimPrefix(name, "idx_logs")
+}
+
+// flatSelect returns a SELECT statement reconstructing the flat logs shape from the provided normalized log table.
+func (s *schema) flatSelect(table string) string {
+	var b strings.Builder
+	var joins strings.Builder
+
+	b.WriteString("SELECT e.ID AS ID")
+	for _, c := range s.columns {
+		if c.dimension != "" {
+			fmt.Fprintf(&b, ", %s.Value AS %s", c.dimension, c.name)
+			fmt.Fprintf(&joins, " LEFT JOIN %s ON %s.ID = e.%s", c.dimension, c.dimension, s.storedName(c))
+			continue
+		}
+		fmt.Fprintf(&b, ", e.%s AS %s", c.name, c.name)
+	}
+	fmt.Fprintf(&b, " FROM %s e%s", table, joins.String())
+
+	return b.String()
+}
+
+// flatSource returns the source with the flat logs shape of the logs stored in the provided log table, which can be used in the FROM clause of queries.
+func (s *schema) flatSource(table string) string {
+	if s.normalized {
+		return "(" + s.flatSelect(table) + ")"
+	}
+	return table
+}
+
+// insertStatement returns the statement used to insert a single log into the provided log table. If duplicates are to be skipped, logs with an already stored hash are ignored. The IDs of partitioned logs and of logs with stored query parameters are assigned by the write routine.
+func (s *schema) insertStatement(table string) string {
+	return s.insertRowsStatement(table, 1, false)
+}
+
+// insertRowsStatement returns the statement used to insert the provided number of logs into the provided log table at once. If duplicates are to be skipped, the statement can return the hashes of the inserted logs.
+func (s *schema) insertRowsStatement(table string, rows int, returning bool) string {
+	names := make([]string, 0, len(s.columns)+1)
+	placeholders := make([]string, 0, len(s.columns)+1)
+
+	if s.assignsIDs() {
+		names = append(names, "ID")
+		placeholders = append(placeholders, "?")
+	}
+
+	for _, c := range s.columns {
+		names = append(names, s.storedName(c))
+		placeholders = append(placeholders, "?")
+	}
+
+	row := "(" + strings.Join(placeholders, ", ") + ")"
+	values := strings.TrimSuffix(strings.Repeat(row+", ", rows), ", ")
+
+	// Unlike INSERT OR IGNORE, the upsert clause only skips duplicates, so that violations of the check constraints are reported
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(names, ", "), values)
+	if s.dedupe {
+		statement += " ON CONFLICT(Hash) DO NOTHING"
+	}
+	if returning && s.dedupe {
+		statement += " RETURNING Hash"
+	}
+
+	return statement
+}
+
+// indexesScript returns the SQL script creating the indexes on the provided log table. Existing indexes are skipped, as logs may be appended to a DB indexed by a previous run.
+func (s *schema) indexesScript(table string) string {
+	stored := make(map[string]string, len(s.columns))
+	for _, c := range s.columns {
+		stored[c.name] = s.storedName(c)
+	}
+
+	indexes := slices.Clip(logIndexes)
+	if s.ips {
+		indexes = append(indexes, ipIndex)
+	}
+	if s.clientIP {
+		indexes = append(indexes, clientIPIndex)
+	}
+	if s.routeTemplate {
+		indexes = append(indexes, routeTemplateIndex)
+	}
+	if s.referers {
+		indexes = append(indexes, refererHostIndex)
+	}
+
+	var b strings.Builder
+
+	for _, idx := range indexes {
+		columns := make([]string, 0, len(idx.columns))
+		for _, name := range idx.columns {
+			columns = append(columns, stored[name])
+		}
+		fmt.Fprintf(&b, "CREATE INDEX IF NOT EXISTS %s ON %s(%s);\n", s.indexName(idx.name, table), table, strings.Join(columns, ", "))
+	}
+
+	return b.String()
+}
+
+// writeMeta stores the schema description in the meta table.
+func (s *schema) writeMeta(conn *sql.DB) error {
+	if _, err := conn.Exec(createMetaTableScript); err != nil {
+		return fmt.Errorf("failed to create meta table: %w", err)
+	}
+
+	for key, value := range s.meta() {
+		if _, err := conn.Exec(insertMetaStatement, key, value); err != nil {
+			return fmt.Errorf("failed to write meta table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// nullIfZero converts a zero ID to NULL, as a zero ID does not reference any row.
+func nullIfZero(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullIfNegative converts a negative value, which stands for a missing one, to NULL.
+func nullIfNegative(v int64) any {
+	if v < 0 {
+		return nil
+	}
+	return v
+}
+
+// nullIfEmptyBlob converts an empty blob to NULL.
+func nullIfEmptyBlob(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// boolToMeta converts a boolean to its representation in the meta table.
+func boolToMeta(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
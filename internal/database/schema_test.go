@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
+)
+
+func TestSchema_InsertStatement(t *testing.T) {
+	flat := newSchema(&config.Config{})
+	expected := "INSERT INTO logs (IP, Identity, UserID, Time, TimestampUTC, TimestampUnix, Method, Route, Params, ResponseCode, BytesSent, Referer, Agent, RunID) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	if actual := flat.insertStatement(flat.logTable()); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	normalized := newSchema(&config.Config{Normalize: true})
+	expected = "INSERT INTO log_entries (IP, Identity, UserID, Time, TimestampUTC, TimestampUnix, Method, RouteID, Params, ResponseCode, BytesSent, RefererID, AgentID, RunID) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	if actual := normalized.insertStatement(normalized.logTable()); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	// The route templates have their own lookup table
+	templated := newSchema(&config.Config{Normalize: true, RouteTemplates: true})
+	expected = "INSERT INTO log_entries (IP, Identity, UserID, Time, TimestampUTC, TimestampUnix, Method, RouteID, Params, ResponseCode, BytesSent, RefererID, AgentID, RunID, RouteTemplateID) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	if actual := templated.insertStatement(templated.logTable()); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestSchema_LoadSchema(t *testing.T) {
+	conn, err := sql.Open("sqlite3", "file::memory:")
+	if err != nil {
+		t.Errorf("error opening DB: %v", err)
+	}
+	defer conn.Close()
+
+	// A single connection is used so that the in-memory DB is shared by all queries
+	conn.SetMaxOpenConns(1)
+
+	expected := newSchema(&config.Config{Normalize: true, Strict: true})
+
+	if err := expected.writeMeta(conn); err != nil {
+		t.Errorf("error writing meta table: %v", err)
+	}
+
+	actual, err := loadSchema(conn)
+	if err != nil {
+		t.Errorf("error loading schema: %v", err)
+	}
+
+	if actual.normalized != expected.normalized {
+		t.Errorf("expected normalized to be %t, got %t", expected.normalized, actual.normalized)
+	}
+
+	if actual.strict != expected.strict {
+		t.Errorf("expected strict to be %t, got %t", expected.strict, actual.strict)
+	}
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+const (
+	ftsTable = "logs_fts"
+
+	createFTSTableScript   = `CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s', content_rowid='ID');`
+	createFTSTriggerScript = `CREATE TRIGGER %s_%s AFTER %s ON %s BEGIN INSERT INTO %s(%s) VALUES (%s); END;`
+
+	// resultColumns lists the columns of the logs (aliased as l) returned as results
+	resultColumns   = "l.ID, l.IP, l.Identity, l.UserID, l.Time, l.TimestampUTC, l.TimestampUnix, l.Method, l.Route, l.Params, l.ResponseCode, l.BytesSent, l.Referer, l.Agent"
+	searchStatement = "SELECT " + resultColumns + " FROM %s JOIN %s l ON l.ID = %s.rowid WHERE %s MATCH ?"
+)
+
+// ftsColumns lists the columns of the logs indexed by the full-text search table.
+var ftsColumns = []string{"Route", "Params", "Referer", "Agent"}
+
+// SearchQuery holds a full-text search query along with the filters applied to the matched logs. Zero values of the filters are ignored.
+type SearchQuery struct {
+	Match     string
+	From      time.Time
+	To        time.Time
+	StatusMin uint16
+	StatusMax uint16
+	Limit     int
+}
+
+// Result is a log stored in the DB along with its ID.
+type Result struct {
+	ID int64
+	parser.Log
+}
+
+// ftsScript returns the SQL script creating the full-text search table and the triggers keeping it in sync with the log table. The triggers of partitions are created along with them.
+func (s *schema) ftsScript() string {
+	script := fmt.Sprintf(createFTSTableScript+"\n", ftsTable, strings.Join(ftsColumns, ", "), flatLogTable)
+
+	if s.partition != "" {
+		return script
+	}
+
+	return script + s.ftsTriggersScript(s.logTable())
+}
+
+// ftsTriggersScript returns the SQL script creating the triggers keeping the full-text search table in sync with the provided log table.
+func (s *schema) ftsTriggersScript(table string) string {
+	var b strings.Builder
+
+	prefix := ftsTable
+	if table != s.logTable() {
+		prefix = table + "_fts"
+	}
+
+	names := "rowid, " + strings.Join(ftsColumns, ", ")
+
+	fmt.Fprintf(&b, createFTSTriggerScript+"\n", prefix, "insert", "INSERT", table, ftsTable, names, s.ftsValues("new"))
+	fmt.Fprintf(&b, createFTSTriggerScript+"\n", prefix, "delete", "DELETE", table, ftsTable, ftsTable+", "+names, "'delete', "+s.ftsValues("old"))
+
+	return b.String()
+}
+
+// ftsValues returns the expressions referencing the indexed values of the new/old row in a trigger. In the normalized schema mode, the values are looked up in the lookup tables.
+func (s *schema) ftsValues(row string) string {
+	values := []string{row + ".ID"}
+
+	for _, name := range ftsColumns {
+		for _, c := range s.columns {
+			if c.name != name {
+				continue
+			}
+
+			if s.normalized && c.dimension != "" {
+				values = append(values, fmt.Sprintf("(SELECT Value FROM %s WHERE ID = %s.%s)", c.dimension, row, s.storedName(c)))
+			} else {
+				values = append(values, row+"."+c.name)
+			}
+		}
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// Search runs a full-text search query against the routes, params, referers and agents of the stored logs and returns the matched logs ordered by their IDs.
+func (d *db) Search(q SearchQuery) ([]Result, error) {
+	if !d.schema.fts {
+		return nil, fmt.Errorf("the DB was not created with full-text search enabled")
+	}
+
+	query, args := appendFilters(fmt.Sprintf(searchStatement, ftsTable, flatLogTable, ftsTable, ftsTable), []any{q.Match}, q.From, q.To, q.StatusMin, q.StatusMax, q.Limit)
+
+	rows, err := d.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search logs: %w", err)
+	}
+	defer rows.Close()
+
+	results, err := scanResults(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search logs: %w", err)
+	}
+
+	return results, nil
+}
+
+// appendFilters appends the conditions of the time range and response code filters, the ordering by ID and the limit to the provided query of logs (aliased as l) along with their arguments. Zero values of the filters are ignored.
+func appendFilters(query string, args []any, from, to time.Time, statusMin, statusMax uint16, limit int) (string, []any) {
+	if !from.IsZero() {
+		query += " AND l.TimestampUnix >= ?"
+		args = append(args, from.Unix())
+	}
+	if !to.IsZero() {
+		query += " AND l.TimestampUnix < ?"
+		args = append(args, to.Unix())
+	}
+	if statusMin > 0 {
+		query += " AND l.ResponseCode >= ?"
+		args = append(args, statusMin)
+	}
+	if statusMax > 0 {
+		query += " AND l.ResponseCode <= ?"
+		args = append(args, statusMax)
+	}
+
+	query += " ORDER BY l.ID"
+
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	return query, args
+}
+
+// scanResults scans the logs returned by a query selecting the result columns.
+func scanResults(rows *sql.Rows) ([]Result, error) {
+	results := make([]Result, 0)
+
+	for rows.Next() {
+		var r Result
+
+		if err := rows.Scan(&r.ID, &r.IP, &r.Identity, &r.User, &r.Time, &r.TimestampUTC, &r.TimestampUnix, &r.Method, &r.Route, &r.Params, &r.ResponseCode, &r.BytesSent, &r.Referer, &r.Agent); err != nil {
+			return nil, fmt.Errorf("failed to scan log: %w", err)
+		}
+
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
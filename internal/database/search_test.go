@@ -0,0 +1,126 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+var searchTestLogs = []parser.Log{{
+	IP:            "127.0.0.1",
+	TimestampUnix: 971211336,
+	Method:        "GET",
+	Route:         "/wp-login.php",
+	Params:        "-",
+	ResponseCode:  404,
+	Referer:       "-",
+	Agent:         "curl/7.68",
+}, {
+	IP:            "127.0.0.1",
+	TimestampUnix: 971211400,
+	Method:        "GET",
+	Route:         "/index.php",
+	Params:        "id=1+union+select+password",
+	ResponseCode:  200,
+	Referer:       "-",
+	Agent:         "sqlmap/1.5",
+}, {
+	IP:            "127.0.0.1",
+	TimestampUnix: 971297736,
+	Method:        "GET",
+	Route:         "/wp-login.php",
+	Params:        "-",
+	ResponseCode:  200,
+	Referer:       "https://example.com",
+	Agent:         "Mozilla/5.0",
+}}
+
+func TestDB_Search(t *testing.T) {
+	for _, normalize := range []bool{false, true} {
+		config := &config.Config{
+			Verbose:        false,
+			DBFilePath:     ":memory:?cache=shared",
+			FullTextSearch: true,
+			Normalize:      normalize,
+		}
+
+		db := NewDB(&mockLogger{}, config)
+
+		if err := db.Init(); err != nil {
+			t.Errorf("Init failed: %v", err)
+		}
+
+		insertTestBatches(db, parser.Batch{Logs: searchTestLogs})
+
+		tests := []struct {
+			name     string
+			query    SearchQuery
+			expected []int64
+		}{
+			{name: "phrase", query: SearchQuery{Match: `"wp-login"`}, expected: []int64{1, 3}},
+			{name: "params", query: SearchQuery{Match: `"union select"`}, expected: []int64{2}},
+			{name: "status", query: SearchQuery{Match: `"wp-login"`, StatusMin: 400, StatusMax: 499}, expected: []int64{1}},
+			{name: "time", query: SearchQuery{Match: `"wp-login"`, From: time.Unix(971211400, 0)}, expected: []int64{3}},
+			{name: "limit", query: SearchQuery{Match: `"wp-login"`, Limit: 1}, expected: []int64{1}},
+		}
+
+		for _, tt := range tests {
+			results, err := db.Search(tt.query)
+			if err != nil {
+				t.Errorf("%s: search failed: %v", tt.name, err)
+			}
+
+			ids := make([]int64, 0, len(results))
+			for _, r := range results {
+				ids = append(ids, r.ID)
+			}
+
+			if len(ids) != len(tt.expected) {
+				t.Errorf("%s: expected IDs %v, got %v", tt.name, tt.expected, ids)
+				continue
+			}
+			for i := range ids {
+				if ids[i] != tt.expected[i] {
+					t.Errorf("%s: expected IDs %v, got %v", tt.name, tt.expected, ids)
+				}
+			}
+		}
+
+		// Deleted logs must be removed from the full-text search table
+		if _, err := db.conn.Exec("DELETE FROM " + db.schema.logTable() + " WHERE ID = 1;"); err != nil {
+			t.Errorf("error deleting log: %v", err)
+		}
+
+		results, err := db.Search(SearchQuery{Match: `"wp-login"`})
+		if err != nil {
+			t.Errorf("search failed: %v", err)
+		}
+		if len(results) != 1 || results[0].Route != "/wp-login.php" {
+			t.Errorf("expected only the log with ID 3 to be found, got %+v", results)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing DB: %v", err)
+		}
+	}
+}
+
+func TestDB_SearchDisabled(t *testing.T) {
+	config := &config.Config{
+		Verbose:    false,
+		DBFilePath: ":memory:?cache=shared",
+	}
+
+	db := NewDB(&mockLogger{}, config)
+	defer db.Close()
+
+	if err := db.Init(); err != nil {
+		t.Errorf("Init failed: %v", err)
+	}
+
+	if _, err := db.Search(SearchQuery{Match: "test"}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	createSessionsTableScript = `CREATE TABLE IF NOT EXISTS "sessions" ("ID" INTEGER NOT NULL, "IP" TEXT, "Agent" TEXT, "StartUnix" INTEGER NOT NULL, "EndUnix" INTEGER NOT NULL, "Requests" INTEGER NOT NULL, "BytesSent" INTEGER NOT NULL, "LandingRoute" TEXT, "ExitRoute" TEXT, PRIMARY KEY("ID"));`
+
+	createSessionLogsScript = `DROP TABLE IF EXISTS temp.session_logs;
+	CREATE TEMP TABLE session_logs ("LogID" INTEGER NOT NULL, "SessionID" INTEGER NOT NULL, "IP" TEXT, "Agent" TEXT, "TimestampUnix" INTEGER, "BytesSent" INTEGER, "LandingRoute" TEXT, "ExitRoute" TEXT, PRIMARY KEY("LogID"));`
+
+	// A log starts a new session if it is the first one of its client or follows the previous one of the client after more than the gap (?1). The sessions are numbered by a running count of the starts, and their landing and exit routes are the routes of their first and last logs.
+	insertSessionLogsStatement = `INSERT INTO temp.session_logs
+	WITH clients AS (
+		SELECT ID, %s AS IP, Agent, TimestampUnix, Route, COALESCE(BytesSent, 0) AS BytesSent FROM logs
+	), starts AS (
+		SELECT *, COALESCE(TimestampUnix - LAG(TimestampUnix) OVER (PARTITION BY IP, Agent ORDER BY TimestampUnix, ID) > ?1, 1) AS Start FROM clients
+	), numbered AS (
+		SELECT *, SUM(Start) OVER (ORDER BY IP, Agent, TimestampUnix, ID ROWS UNBOUNDED PRECEDING) AS SessionID FROM starts
+	)
+	SELECT ID, SessionID, IP, Agent, TimestampUnix, BytesSent,
+		FIRST_VALUE(Route) OVER session,
+		LAST_VALUE(Route) OVER (session ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING)
+	FROM numbered
+	WINDOW session AS (PARTITION BY SessionID ORDER BY TimestampUnix, ID);`
+
+	insertSessionsStatement = `INSERT INTO sessions (ID, IP, Agent, StartUnix, EndUnix, Requests, BytesSent, LandingRoute, ExitRoute)
+	SELECT SessionID, IP, Agent, MIN(TimestampUnix), MAX(TimestampUnix), COUNT(*), SUM(BytesSent), MIN(LandingRoute), MIN(ExitRoute)
+	FROM temp.session_logs GROUP BY SessionID;`
+
+	updateSessionIDsStatement = "UPDATE %s SET SessionID = (SELECT SessionID FROM temp.session_logs WHERE LogID = %s.ID);"
+)
+
+// Sessions groups the stored logs into visitor sessions, identified by the client IP (or the peer IP if no client IP is stored) and the user agent, and returns their count. A session ends once the client has been inactive for longer than the provided gap. The sessions are stored in the sessions table and referenced by the SessionID column of the logs, which is added to the log tables on the first run. All sessions are reconstructed on every run, so that the logs appended since the previous one are included.
+func (d *db) Sessions(gap time.Duration) (sessions int64, err error) {
+	tables, err := d.logTables()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	existing := d.schema.sessions
+
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				d.logger.Printf("failed to roll back transaction: %v", rollbackErr)
+			}
+
+			d.schema.sessions = existing
+			d.schema.build()
+		}
+	}()
+
+	if !existing {
+		if err := d.addSessionColumn(tx, tables); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(createSessionsTableScript + "\n" + createSessionLogsScript); err != nil {
+		return 0, fmt.Errorf("failed to create sessions tables: %w", err)
+	}
+
+	ip := "IP"
+	if d.schema.clientIP {
+		ip = "COALESCE(NULLIF(ClientIP, ''), IP)"
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(insertSessionLogsStatement, ip), int64(gap.Seconds())); err != nil {
+		return 0, fmt.Errorf("failed to group logs into sessions: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM sessions;"); err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+
+	res, err := tx.Exec(insertSessionsStatement)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert sessions: %w", err)
+	}
+
+	if sessions, err = res.RowsAffected(); err != nil {
+		return 0, fmt.Errorf("failed to insert sessions: %w", err)
+	}
+
+	for _, table := range tables {
+		if _, err := tx.Exec(fmt.Sprintf(updateSessionIDsStatement, table, table)); err != nil {
+			return 0, fmt.Errorf("failed to update sessions of %s: %w", table, err)
+		}
+	}
+
+	if _, err := tx.Exec("DROP TABLE temp.session_logs;"); err != nil {
+		return 0, fmt.Errorf("failed to drop session logs: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// addSessionColumn adds the SessionID column to the provided log tables of a DB without sessions, records the sessions option in the meta table and recreates the logs view to include the column. The schema is updated along with them, so that new partitions are created with the column.
+func (d *db) addSessionColumn(tx queryer, tables []string) error {
+	for _, table := range tables {
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN "%s" %s;`, table, sessionColumn.name, sessionColumn.definition)); err != nil {
+			return fmt.Errorf("failed to add session column to %s: %w", table, err)
+		}
+	}
+
+	if _, err := tx.Exec(upsertMetaStatement, metaSessions, boolToMeta(true)); err != nil {
+		return fmt.Errorf("failed to write meta table: %w", err)
+	}
+
+	d.schema.sessions = true
+	d.schema.build()
+
+	switch {
+	case d.schema.partition != "":
+		return d.rebuildPartitionView(tx)
+	case d.schema.normalized:
+		if _, err := tx.Exec(fmt.Sprintf("DROP VIEW %s;\nCREATE VIEW %s AS %s;", flatLogTable, flatLogTable, d.schema.flatSelect(normalizedLogTable))); err != nil {
+			return fmt.Errorf("failed to recreate logs view: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+)
+
+// sessionTestLogs returns the logs of a client with two sessions, another agent of the same IP, and a client whose peer IP is a proxy, which comes back the next day.
+func sessionTestLogs() []parser.Log {
+	logs := []parser.Log{
+		{TimestampUnix: 971211336, IP: "1.1.1.1", Agent: "ua1", Route: "/a", BytesSent: 10, Hash: []byte("a")},
+		{TimestampUnix: 971211436, IP: "1.1.1.1", Agent: "ua1", Route: "/b", BytesSent: 20, Hash: []byte("b")},
+		{TimestampUnix: 971213336, IP: "1.1.1.1", Agent: "ua1", Route: "/c", BytesSent: 30, Hash: []byte("c")},
+		{TimestampUnix: 971213436, IP: "1.1.1.1", Agent: "ua1", Route: "/d", BytesSent: 40, Hash: []byte("d")},
+		{TimestampUnix: 971211386, IP: "1.1.1.1", Agent: "ua2", Route: "/x", BytesSent: 50, Hash: []byte("x")},
+		{TimestampUnix: 971211346, IP: "2.2.2.2", ClientIP: "3.3.3.3", Agent: "ua1", Route: "/y", BytesSent: 60, Hash: []byte("y")},
+		{TimestampUnix: 971297736, IP: "2.2.2.2", ClientIP: "3.3.3.3", Agent: "ua1", Route: "/z", BytesSent: 70, Hash: []byte("z")},
+	}
+
+	for i := range logs {
+		logs[i].Method = "GET"
+		logs[i].ResponseCode = 200
+	}
+
+	return logs
+}
+
+// querySessions returns the stored sessions along with the routes of their logs.
+func querySessions(t *testing.T, db *db) []string {
+	t.Helper()
+
+	rows, err := db.conn.Query(`SELECT s.IP, s.Agent, s.StartUnix, s.EndUnix, s.Requests, s.BytesSent, s.LandingRoute, s.ExitRoute, (SELECT GROUP_CONCAT(Route, ',') FROM (SELECT Route FROM logs WHERE SessionID = s.ID ORDER BY TimestampUnix))
+		FROM sessions s ORDER BY s.ID;`)
+	if err != nil {
+		t.Fatalf("error querying sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]string, 0)
+	for rows.Next() {
+		var ip, agent, landing, exit, routes string
+		var start, end, requests, bytes int64
+		if err := rows.Scan(&ip, &agent, &start, &end, &requests, &bytes, &landing, &exit, &routes); err != nil {
+			t.Fatalf("error scanning sessions: %v", err)
+		}
+		sessions = append(sessions, fmt.Sprintf("%s %s %d-%d %d %d %s-%s %s", ip, agent, start-971211336, end-971211336, requests, bytes, landing, exit, routes))
+	}
+
+	return sessions
+}
+
+func TestDB_Sessions(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{name: "flat", cfg: config.Config{}},
+		{name: "normalized", cfg: config.Config{Normalize: true, Dedupe: true}},
+		{name: "partitioned", cfg: config.Config{Partition: config.PartitionDay, Dedupe: true}},
+		{name: "client IPs", cfg: config.Config{ForwardedHeader: "x-forwarded-for", Normalize: true, Partition: config.PartitionDay}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.DBFilePath = filepath.Join(t.TempDir(), "sessions.db")
+
+			db := NewDB(&mockLogger{}, &cfg)
+			if err := db.Init(); err != nil {
+				t.Fatalf("Init failed: %v", err)
+			}
+
+			insertTestBatches(db, parser.Batch{Logs: sessionTestLogs()})
+
+			client := "2.2.2.2"
+			if cfg.ForwardedHeader != "" {
+				client = "3.3.3.3"
+			}
+
+			expected := []string{
+				"1.1.1.1 ua1 0-100 2 30 /a-/b /a,/b",
+				"1.1.1.1 ua1 2000-2100 2 70 /c-/d /c,/d",
+				"1.1.1.1 ua2 50-50 1 50 /x-/x /x",
+				client + " ua1 10-10 1 60 /y-/y /y",
+				client + " ua1 86400-86400 1 70 /z-/z /z",
+			}
+
+			sessions, err := db.Sessions(30 * time.Minute)
+			if err != nil {
+				t.Fatalf("Sessions failed: %v", err)
+			}
+			if sessions != int64(len(expected)) {
+				t.Errorf("expected %d sessions, got %d", len(expected), sessions)
+			}
+
+			if actual := querySessions(t, db); !slices.Equal(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatalf("error closing DB: %v", err)
+			}
+
+			// The sessions are reconstructed along with the logs appended since the previous run, which are stored with the session column. A longer gap joins the sessions of the first client.
+			db = NewDB(&mockLogger{}, &cfg)
+			defer db.Close()
+
+			if cfg.Dedupe {
+				if err := db.Init(); err != nil {
+					t.Fatalf("Init failed: %v", err)
+				}
+
+				insertTestBatches(db, parser.Batch{Logs: []parser.Log{{TimestampUnix: 971384136, IP: "4.4.4.4", Method: "GET", Route: "/new", ResponseCode: 200, Hash: []byte("new")}}})
+
+				expected = append(expected, "4.4.4.4  172800-172800 1 0 /new-/new /new")
+			} else if err := db.Open(); err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+
+			if !db.schema.sessions {
+				t.Errorf("expected the sessions to be recorded in the meta table")
+			}
+
+			if _, err := db.Sessions(time.Hour); err != nil {
+				t.Fatalf("Sessions failed: %v", err)
+			}
+
+			expected = slices.Delete(expected, 0, 2)
+			expected = slices.Insert(expected, 0, "1.1.1.1 ua1 0-2100 4 100 /a-/d /a,/b,/c,/d")
+
+			if actual := querySessions(t, db); !slices.Equal(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestDB_MergeSessions(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := config.Config{DBFilePath: filepath.Join(dir, "node.db")}
+
+	db := NewDB(&mockLogger{}, &cfg)
+	if err := db.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: sessionTestLogs()})
+
+	if _, err := db.Sessions(30 * time.Minute); err != nil {
+		t.Fatalf("Sessions failed: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("error closing DB: %v", err)
+	}
+
+	// The sessions of a source do not prevent it from being merged with the ones without sessions
+	other := config.Config{DBFilePath: filepath.Join(dir, "other.db")}
+
+	db = NewDB(&mockLogger{}, &other)
+	if err := db.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+
+	insertTestBatches(db, parser.Batch{Logs: sessionTestLogs()[:1]})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("error closing DB: %v", err)
+	}
+
+	merged := NewDB(&mockLogger{}, &config.Config{DBFilePath: filepath.Join(dir, "merged.db")})
+	defer merged.Close()
+
+	if _, err := merged.Merge([]MergeSource{{Path: cfg.DBFilePath, Node: "a"}, {Path: other.DBFilePath, Node: "b"}}); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	if merged.schema.sessions {
+		t.Errorf("expected the merged DB to have no sessions")
+	}
+
+	sessions, err := merged.Sessions(30 * time.Minute)
+	if err != nil {
+		t.Fatalf("Sessions failed: %v", err)
+	}
+
+	// The logs of the first client come from both nodes
+	if sessions != 5 {
+		t.Errorf("expected 5 sessions, got %d", sessions)
+	}
+}
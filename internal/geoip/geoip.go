@@ -0,0 +1,154 @@
+// Package geoip provides functionality for enriching batches of parsed logs with the location (country, region and city) and the autonomous system (ASN and organization) of their IPs, looked up offline in local MaxMind DB (.mmdb) files such as GeoLite2-City and GeoLite2-ASN. It runs as a pipeline stage between the parsing routines and the write routine.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	"go.vxn.dev/xilt/internal/cache"
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+// record holds the fields decoded from a MaxMind DB record. City databases hold the location fields and ASN databases the autonomous system fields, a database holding both is supported as well.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names struct {
+			EN string `maxminddb:"en"`
+		} `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names struct {
+			EN string `maxminddb:"en"`
+		} `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN          uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// result is a cached lookup result. IPs not found in any database are cached as well.
+type result struct {
+	info  parser.IPInfo
+	found bool
+}
+
+// Enricher looks up the IPs of parsed logs in MaxMind DB files, caching the results per IP.
+type Enricher struct {
+	logger    logger.Logger
+	databases []*maxminddb.Reader
+
+	cache *cache.Cache[string, result]
+}
+
+// NewEnricher returns a new Enricher using the GeoIP and ASN databases configured. Either of them may be omitted.
+func NewEnricher(l logger.Logger, c *config.Config) (*Enricher, error) {
+	e := &Enricher{
+		logger: l,
+		cache:  cache.New[string, result](cache.DefaultSize),
+	}
+
+	for _, path := range []string{c.GeoIPFilePath, c.ASNFilePath} {
+		if path == "" {
+			continue
+		}
+
+		db, err := maxminddb.Open(path)
+		if err != nil {
+			return nil, errors.Join(fmt.Errorf("error opening MaxMind DB %s: %w", path, err), e.Close())
+		}
+
+		e.databases = append(e.databases, db)
+	}
+
+	return e, nil
+}
+
+// Close closes the databases of the Enricher.
+func (e *Enricher) Close() error {
+	var errs []error
+	for _, db := range e.databases {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Lookup returns the information about the provided IP and whether it was found in any of the databases. The results are cached per IP. It is safe for concurrent use.
+func (e *Enricher) Lookup(ip string) (parser.IPInfo, bool) {
+	r := e.cache.GetOrCompute(ip, e.lookup)
+
+	return r.info, r.found
+}
+
+// lookup looks up the provided IP in all the databases, combining the fields found.
+func (e *Enricher) lookup(ip string) result {
+	var r result
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return r
+	}
+
+	for _, db := range e.databases {
+		var rec record
+
+		// IPv6 addresses cannot be looked up in IPv4-only databases, which is reported as an error
+		_, ok, err := db.LookupNetwork(parsed, &rec)
+		if err != nil {
+			e.logger.Debugf("error looking up IP %s: %v", ip, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		r.found = true
+
+		if rec.Country.ISOCode != "" {
+			r.info.Country = rec.Country.ISOCode
+		}
+		if len(rec.Subdivisions) > 0 && rec.Subdivisions[0].Names.EN != "" {
+			r.info.Region = rec.Subdivisions[0].Names.EN
+		}
+		if rec.City.Names.EN != "" {
+			r.info.City = rec.City.Names.EN
+		}
+		if rec.ASN != 0 {
+			r.info.ASN = rec.ASN
+			r.info.Organization = rec.Organization
+		}
+	}
+
+	return r
+}
+
+// EnrichBatch reads batches of parsed logs from an input channel, looks up the distinct IPs (including the client IPs resolved from forwarding headers) of each batch and sends the batch along with the information about the IPs found to an output channel. It is designed to run concurrently as part of a goroutine.
+func (e *Enricher) EnrichBatch(parsedLogChan <-chan parser.Batch, enrichedLogChan chan<- parser.Batch, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for batch := range parsedLogChan {
+		batch.IPInfo = make(map[string]parser.IPInfo)
+
+		for i := range batch.Logs {
+			for _, ip := range []string{batch.Logs[i].IP, batch.Logs[i].ClientIP} {
+				if _, ok := batch.IPInfo[ip]; ok || ip == "" {
+					continue
+				}
+
+				if info, found := e.Lookup(ip); found {
+					batch.IPInfo[ip] = info
+				}
+			}
+		}
+
+		e.logger.Debugf("enrichment routine found %d IPs of a batch of %d logs", len(batch.IPInfo), len(batch.Logs))
+
+		enrichedLogChan <- batch
+	}
+}
@@ -0,0 +1,244 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
+	"go.vxn.dev/xilt/pkg/logger"
+)
+
+// testNetwork is a network stored in a test database along with the encoded data of its record.
+type testNetwork struct {
+	cidr string
+	data []byte
+}
+
+// encodeString encodes a string in the data section format of MaxMind DB files. Only strings shorter than 285 bytes are supported.
+func encodeString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{0x40 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+
+// encodeUint encodes an unsigned integer of the provided type (5 for uint16, 6 for uint32) in the data section format.
+func encodeUint(typ byte, v uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return append([]byte{typ<<5 | byte(len(b))}, b...)
+}
+
+// encodeMap encodes a map in the data section format. The values have to be encoded already.
+func encodeMap(pairs ...any) []byte {
+	b := []byte{0xe0 | byte(len(pairs)/2)}
+	for i := 0; i < len(pairs); i += 2 {
+		b = append(b, encodeString(pairs[i].(string))...)
+		b = append(b, pairs[i+1].([]byte)...)
+	}
+	return b
+}
+
+// writeTestDB writes an IPv4 MaxMind DB file with 24-bit records holding the provided networks and returns its path.
+func writeTestDB(t *testing.T, networks []testNetwork) string {
+	t.Helper()
+
+	// Each node of the search tree holds its left (bit 0) and right (bit 1) records, which point to a node, the data of a network, or nowhere (-1)
+	type pointer struct {
+		node int
+		data int
+	}
+	nodes := [][2]pointer{{{-1, -1}, {-1, -1}}}
+
+	var data []byte
+
+	for _, n := range networks {
+		ip, network, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatalf("error parsing network: %v", err)
+		}
+		ip = ip.To4()
+		ones, _ := network.Mask.Size()
+
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+
+			if i == ones-1 {
+				nodes[node][bit] = pointer{node: -1, data: len(data)}
+				break
+			}
+
+			if nodes[node][bit].node == -1 {
+				nodes = append(nodes, [2]pointer{{-1, -1}, {-1, -1}})
+				nodes[node][bit] = pointer{node: len(nodes) - 1, data: -1}
+			}
+			node = nodes[node][bit].node
+		}
+
+		data = append(data, n.data...)
+	}
+
+	var b []byte
+	for _, node := range nodes {
+		for _, p := range node {
+			value := len(nodes)
+			switch {
+			case p.node != -1:
+				value = p.node
+			case p.data != -1:
+				value = len(nodes) + 16 + p.data
+			}
+			b = append(b, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+
+	b = append(b, make([]byte, 16)...)
+	b = append(b, data...)
+	b = append(b, "\xab\xcd\xefMaxMind.com"...)
+	b = append(b, encodeMap(
+		"node_count", encodeUint(6, uint32(len(nodes))),
+		"record_size", encodeUint(5, 24),
+		"ip_version", encodeUint(5, 4),
+		"database_type", encodeString("xilt-test"),
+		"binary_format_major_version", encodeUint(5, 2),
+		"binary_format_minor_version", encodeUint(5, 0),
+	)...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatalf("error writing test DB: %v", err)
+	}
+
+	return path
+}
+
+// testEnricher returns an Enricher using a city database and an ASN database which cover different networks.
+func testEnricher(t *testing.T) *Enricher {
+	t.Helper()
+
+	city := writeTestDB(t, []testNetwork{
+		{cidr: "81.2.69.0/24", data: encodeMap(
+			"country", encodeMap("iso_code", encodeString("GB")),
+			"subdivisions", append([]byte{0x01, 0x04}, encodeMap("names", encodeMap("en", encodeString("England")))...),
+			"city", encodeMap("names", encodeMap("en", encodeString("London"))),
+		)},
+		{cidr: "89.160.0.0/16", data: encodeMap(
+			"country", encodeMap("iso_code", encodeString("SE")),
+		)},
+	})
+
+	asn := writeTestDB(t, []testNetwork{
+		{cidr: "81.2.0.0/16", data: encodeMap(
+			"autonomous_system_number", encodeUint(6, 20712),
+			"autonomous_system_organization", encodeString("Andrews & Arnold Ltd"),
+		)},
+		{cidr: "1.128.0.0/11", data: encodeMap(
+			"autonomous_system_number", encodeUint(6, 1221),
+			"autonomous_system_organization", encodeString("Telstra Pty Ltd"),
+		)},
+	})
+
+	e, err := NewEnricher(logger.NewLogger(false), &config.Config{GeoIPFilePath: city, ASNFilePath: asn})
+	if err != nil {
+		t.Fatalf("error creating enricher: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := e.Close(); err != nil {
+			t.Errorf("error closing enricher: %v", err)
+		}
+	})
+
+	return e
+}
+
+func TestEnricher_Lookup(t *testing.T) {
+	e := testEnricher(t)
+
+	tests := []struct {
+		ip       string
+		expected parser.IPInfo
+		found    bool
+	}{
+		{ip: "81.2.69.142", expected: parser.IPInfo{Country: "GB", Region: "England", City: "London", ASN: 20712, Organization: "Andrews & Arnold Ltd"}, found: true},
+		{ip: "81.2.1.1", expected: parser.IPInfo{ASN: 20712, Organization: "Andrews & Arnold Ltd"}, found: true},
+		{ip: "89.160.20.112", expected: parser.IPInfo{Country: "SE"}, found: true},
+		{ip: "1.130.0.1", expected: parser.IPInfo{ASN: 1221, Organization: "Telstra Pty Ltd"}, found: true},
+		{ip: "127.0.0.1"},
+		// IPv6 addresses cannot be looked up in the IPv4 test databases
+		{ip: "2001:db8::1"},
+		{ip: "invalid"},
+	}
+
+	for _, tt := range tests {
+		info, found := e.Lookup(tt.ip)
+		if info != tt.expected || found != tt.found {
+			t.Errorf("%s: expected %+v (found %t), got %+v (found %t)", tt.ip, tt.expected, tt.found, info, found)
+		}
+	}
+
+	// Lookups are cached, including the ones of IPs not found
+	if e.cache.Len() != len(tests) {
+		t.Errorf("expected %d cached IPs, got %d", len(tests), e.cache.Len())
+	}
+}
+
+func TestEnricher_EnrichBatch(t *testing.T) {
+	e := testEnricher(t)
+
+	parsedLogChan := make(chan parser.Batch, 1)
+	enrichedLogChan := make(chan parser.Batch, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go e.EnrichBatch(parsedLogChan, enrichedLogChan, &wg)
+
+	// The client IPs resolved from forwarding headers are looked up as well
+	parsedLogChan <- parser.Batch{Seq: 3, Logs: []parser.Log{{IP: "81.2.69.142"}, {IP: "127.0.0.1"}, {IP: "81.2.69.142"}, {IP: "127.0.0.1", ClientIP: "89.160.20.112"}}}
+	close(parsedLogChan)
+
+	wg.Wait()
+
+	batch := <-enrichedLogChan
+
+	if batch.Seq != 3 || len(batch.Logs) != 4 {
+		t.Errorf("expected the batch to be passed on unchanged, got %+v", batch)
+	}
+
+	expected := map[string]parser.IPInfo{
+		"81.2.69.142":   {Country: "GB", Region: "England", City: "London", ASN: 20712, Organization: "Andrews & Arnold Ltd"},
+		"89.160.20.112": {Country: "SE"},
+	}
+
+	if len(batch.IPInfo) != len(expected) {
+		t.Errorf("expected %+v, got %+v", expected, batch.IPInfo)
+	}
+	for ip, info := range expected {
+		if batch.IPInfo[ip] != info {
+			t.Errorf("%s: expected %+v, got %+v", ip, info, batch.IPInfo[ip])
+		}
+	}
+}
+
+func TestNewEnricher_InvalidDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	if err := os.WriteFile(path, []byte("not a MaxMind DB"), 0o644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	if _, err := NewEnricher(logger.NewLogger(false), &config.Config{GeoIPFilePath: path}); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if _, err := NewEnricher(logger.NewLogger(false), &config.Config{ASNFilePath: filepath.Join(t.TempDir(), "missing.mmdb")}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
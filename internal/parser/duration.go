@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"go.vxn.dev/xilt/internal/config"
+)
+
+const (
+	// requestTimeGroup and upstreamTimeGroup are the named groups of the regex capturing the time taken to serve the request and the time spent waiting for the upstream servers
+	requestTimeGroup  = "request_time"
+	upstreamTimeGroup = "upstream_time"
+)
+
+// unitMicroseconds holds the number of microseconds in each supported duration unit.
+var unitMicroseconds = map[string]float64{
+	config.DurationMicroseconds: 1,
+	config.DurationMilliseconds: 1e3,
+	config.DurationSeconds:      1e6,
+}
+
+// parseDuration converts a logged duration in the provided unit to microseconds. Multiple durations separated by commas or colons (e.g. nginx's $upstream_response_time of a request passed to several upstream servers) are summed, missing values ("-") are skipped. It reports false if no valid duration is logged.
+func parseDuration(value string, unit string) (int64, bool) {
+	var total float64
+	var found bool
+
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' }) {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "-" {
+			continue
+		}
+
+		d, err := strconv.ParseFloat(part, 64)
+		if err != nil || d < 0 || math.IsInf(d, 0) || math.IsNaN(d) {
+			return 0, false
+		}
+
+		total += d
+		found = true
+	}
+
+	if !found {
+		return 0, false
+	}
+
+	us := math.Round(total * unitMicroseconds[unit])
+	if us >= math.MaxInt64 {
+		return 0, false
+	}
+
+	return int64(us), true
+}
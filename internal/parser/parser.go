@@ -2,39 +2,135 @@
 package parser
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"math"
+	"net/netip"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.vxn.dev/xilt/internal/clientip"
+	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/referer"
+	"go.vxn.dev/xilt/internal/routetemplate"
+	"go.vxn.dev/xilt/internal/useragent"
 	"go.vxn.dev/xilt/pkg/logger"
 )
 
 type Log struct {
-	IP           string
-	Identity     string
-	User         string
-	Time         string
-	TimestampUTC string
-	Method       string
-	Route        string
-	Params       string
-	ResponseCode uint16
-	BytesSent    uint32
-	Referer      string
-	Agent        string
+	IP            string
+	Identity      string
+	User          string
+	Time          string
+	TimestampUTC  string
+	TimestampUnix int64
+	Method        string
+	Route         string
+	Params        string
+	ResponseCode  uint16
+	BytesSent     uint64
+	Referer       string
+	Agent         string
+	Hash          []byte
+	SourceFile    string
+	LineNumber    int64
+	ByteOffset    int64
+	// The attributes of the user agent are only set if user agents are parsed
+	Browser        string
+	BrowserVersion string
+	OS             string
+	DeviceType     string
+	IsBot          bool
+	// The binary form and the invalid flag of the IP are only set if IPs are parsed
+	IPBinary  []byte
+	IPInvalid bool
+	// The client IP is only set if it is resolved from a forwarding header, IP being the peer IP then
+	ClientIP string
+	// The decoded route, the decoded query string and the encoding flags are only set if URLs are decoded, the decoded query parameters if URLs are decoded or the parameters are stored
+	RouteDecoded     string
+	ParamsDecoded    string
+	URLInvalid       bool
+	URLDoubleEncoded bool
+	URLNullByte      bool
+	QueryParams      []Param
+	// The route template is only set if routes are templated
+	RouteTemplate string
+	// The parts of the referer are only set if referers are parsed
+	RefererHost     string
+	RefererPath     string
+	RefererInternal bool
+	SearchEngine    string
+	SearchQuery     string
+	// The request time and the upstream time are in microseconds, -1 if not logged. They are only set if durations are parsed.
+	RequestTimeUs  int64
+	UpstreamTimeUs int64
+	// The bot class is only set if bots are classified, which is done by the classification routine following the parsing routines
+	BotClass string
+}
+
+// RawLog is a raw log along with its position in the log file it was read from.
+type RawLog struct {
+	Line       string
+	SourceFile string
+	LineNumber int64
+	ByteOffset int64
+}
+
+// RawBatch is a batch of raw logs along with its sequence number, which is the position of the batch in the log file.
+type RawBatch struct {
+	Seq  int64
+	Logs []RawLog
+}
+
+// Batch is a batch of parsed logs along with the rollup aggregates computed from them and the sequence number of the raw batch they were parsed from. If GeoIP enrichment is enabled, IPInfo holds the information about the IPs of the logs found in the GeoIP databases.
+type Batch struct {
+	Seq     int64
+	Logs    []Log
+	Rollups Rollups
+	IPInfo  map[string]IPInfo
+}
+
+// IPInfo holds the location and the autonomous system of an IP. The country is an ISO 3166-1 alpha-2 code, the region and city are English names.
+type IPInfo struct {
+	Country      string
+	Region       string
+	City         string
+	ASN          uint32
+	Organization string
 }
 
 type Parser interface {
 	parseLog(l string) (*Log, error)
-	ParseBatch(id int, batchChan <-chan []string, parsedLogChan chan<- []Log, wg *sync.WaitGroup)
+	ParseBatch(id int, batchChan <-chan RawBatch, parsedLogChan chan<- Batch, wg *sync.WaitGroup)
 }
 
 type parser struct {
-	logger logger.Logger
-	regex  *regexp.Regexp
+	logger   logger.Logger
+	config   *config.Config
+	regex    *regexp.Regexp
+	agents   *useragent.Parser
+	routes   *routetemplate.Templater
+	referers *referer.Parser
+	// clientIPs resolves the client IPs from the forwarding header captured by the regex group with the index forwarded
+	clientIPs *clientip.Resolver
+	forwarded int
+	// requestTime and upstreamTime are the indexes of the regex groups capturing the durations, 0 if not captured
+	requestTime  int
+	upstreamTime int
+
+	parsed   atomic.Int64
+	rejected atomic.Int64
+}
+
+// Stats holds the counts of logs processed by the parsing routines.
+type Stats struct {
+	Parsed   int64
+	Rejected int64
 }
 
 const (
@@ -44,14 +140,18 @@ const (
 	defaultAgent         = "-"
 	defaultLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
 	timestampUTCLayout   = "2006-01-02T15:04:05Z07:00"
+	// forwardedGroup is the named group of the regex capturing the forwarding header the client IP is resolved from
+	forwardedGroup = "forwarded"
+	// hashSize is the number of bytes of the SHA-256 digest kept as the content hash of a log, which is plenty to avoid collisions while halving the storage needed
+	hashSize = 16
 )
 
 var (
 	defaultRegex = `^(?<ip>\S*).* (?<identity>\S*) (?<user>\S*) \[(?<timestamp>.*)\]\s"(?<method>\S*)\s(?<route>\S*)\s(?<protocol>[^"]*)"\s(?<response>\S*)\s(?<bytes>\S*)\s?"?(?<referrer>[^"]*)"?\s?"?(?<agent>[^"]*)"?\s*$`
 )
 
-// NewParser returns a new Parser instance. It takes a logger instance implementing the Logger interface and a regex pattern string. If the regex pattern is not passed (passing a nil pointer instead), the default regex pattern is used to create the Parser instance.
-func NewParser(l logger.Logger, r *string) (*parser, error) {
+// NewParser returns a new Parser instance. It takes a logger instance implementing the Logger interface, the config and a regex pattern string. If the regex pattern is not passed (passing a nil pointer instead), the default regex pattern is used to create the Parser instance. If user agents are to be parsed, the configured rule set (or the embedded default one) is loaded, and so are the custom route rules if routes are to be templated. If client IPs are to be resolved, the regex must capture the forwarding header in the 'forwarded' named group. If durations are to be parsed, the regex must capture the request time in the 'request_time' named group and may capture the upstream time in the 'upstream_time' one.
+func NewParser(l logger.Logger, c *config.Config, r *string) (*parser, error) {
 	if r == nil {
 		r = &defaultRegex
 	}
@@ -61,9 +161,59 @@ func NewParser(l logger.Logger, r *string) (*parser, error) {
 		return nil, err
 	}
 
+	var agents *useragent.Parser
+	if c.ParseAgents {
+		if agents, err = useragent.NewParser(c.AgentRules); err != nil {
+			return nil, err
+		}
+		if skipped := agents.Skipped(); len(skipped) > 0 {
+			l.Printf("skipped %d user agent rules which cannot be compiled, e.g. %q", len(skipped), skipped[0])
+		}
+	}
+
+	var routes *routetemplate.Templater
+	if c.RouteTemplates {
+		if routes, err = routetemplate.NewTemplater(c.RouteRules); err != nil {
+			return nil, err
+		}
+	}
+
+	var referers *referer.Parser
+	if c.ParseReferers {
+		referers = referer.NewParser(c.Sites)
+	}
+
+	var clientIPs *clientip.Resolver
+	var forwarded int
+	if c.ForwardedHeader != "" {
+		if forwarded = regex.SubexpIndex(forwardedGroup); forwarded < 0 {
+			return nil, fmt.Errorf("the regex must capture the %s header in the '%s' named group", c.ForwardedHeader, forwardedGroup)
+		}
+		if clientIPs, err = clientip.NewResolver(c.ForwardedHeader, c.TrustedProxies); err != nil {
+			return nil, err
+		}
+	}
+
+	var requestTime, upstreamTime int
+	if c.DurationUnit != "" {
+		if requestTime = regex.SubexpIndex(requestTimeGroup); requestTime < 0 {
+			return nil, fmt.Errorf("the regex must capture the request time in the '%s' named group", requestTimeGroup)
+		}
+		upstreamTime = max(regex.SubexpIndex(upstreamTimeGroup), 0)
+	}
+
 	return &parser{
-		logger: l,
-		regex:  regex,
+		logger:    l,
+		config:    c,
+		regex:     regex,
+		agents:    agents,
+		routes:    routes,
+		referers:  referers,
+		clientIPs: clientIPs,
+		forwarded: forwarded,
+
+		requestTime:  requestTime,
+		upstreamTime: upstreamTime,
 	}, nil
 }
 
@@ -82,8 +232,20 @@ func (p *parser) parseLog(l string) (*Log, error) {
 		Agent:   defaultAgent,
 	}
 
-	// TODO: use net.ParseIP?
 	parsedLog.IP = matches[1]
+	if p.config.ParseIPs {
+		parseIP(&parsedLog)
+	}
+	if p.clientIPs != nil {
+		parsedLog.ClientIP = p.clientIPs.Resolve(parsedLog.IP, matches[p.forwarded])
+	}
+	if p.requestTime > 0 {
+		parsedLog.RequestTimeUs = p.parseDuration(matches[p.requestTime])
+		parsedLog.UpstreamTimeUs = -1
+		if p.upstreamTime > 0 {
+			parsedLog.UpstreamTimeUs = p.parseDuration(matches[p.upstreamTime])
+		}
+	}
 	parsedLog.Identity = matches[2]
 	parsedLog.User = matches[3]
 	parsedLog.Time = matches[4]
@@ -98,6 +260,14 @@ func (p *parser) parseLog(l string) (*Log, error) {
 		parsedLog.Params = uri[1]
 	}
 
+	if p.config.DecodeURLs || p.config.ParamsTable {
+		decodeURL(&parsedLog)
+	}
+
+	if p.routes != nil {
+		parsedLog.RouteTemplate = p.routes.Template(parsedLog.Route)
+	}
+
 	// Parse response code
 	responseCode, err := strconv.ParseUint(matches[8], 10, 16)
 	if err != nil {
@@ -112,17 +282,22 @@ func (p *parser) parseLog(l string) (*Log, error) {
 		p.logger.Println("error parsing time:", err)
 	} else {
 		parsedLog.TimestampUTC = parsedTime.UTC().Format(timestampUTCLayout)
+		parsedLog.TimestampUnix = parsedTime.Unix()
 	}
 
 	// Parse bytes sent
 	bytesSent, err := strconv.ParseUint(matches[9], 10, 64)
-	if err != nil {
+	switch {
+	case err != nil:
 		// If there is an error parsing as int and the value is not "-", there is some issue with the format. If the value is "-", it is a valid value, therefore no error is logged and the default value of "-" is used.
 		if matches[9] != "-" {
 			p.logger.Debugf("error parsing bytes sent: %v. proceeding with default value", err)
 		}
-	} else {
-		parsedLog.BytesSent = uint32(bytesSent)
+	case bytesSent > math.MaxInt64:
+		// SQLite stores integers as signed 64-bit values
+		p.logger.Debugf("bytes sent %d out of the storable range. proceeding with default value", bytesSent)
+	default:
+		parsedLog.BytesSent = bytesSent
 	}
 
 	// Parse Referer if present
@@ -138,25 +313,126 @@ func (p *parser) parseLog(l string) (*Log, error) {
 	return &parsedLog, nil
 }
 
-// ParseBatch reads batches of raw logs from an input channel, parses each log in the batch, and sends successfully parsed logs from the batch to an output channel for further processing or storage. It is designed to run concurrently as part of a goroutine, and only valid logs are included in the output batch.
-func (p *parser) ParseBatch(id int, batchChan <-chan []string, parsedLogChan chan<- []Log, wg *sync.WaitGroup) {
+// ParseBatch reads batches of raw logs from an input channel, parses each log in the batch, and sends successfully parsed logs from the batch to an output channel for further processing or storage. It is designed to run concurrently as part of a goroutine, and only valid logs are included in the output batch. If rollups are enabled, the logs are also aggregated into the output batch's rollups. An output batch is sent for every input batch, even if none of its logs are valid, so that the sequence of batches has no gaps.
+func (p *parser) ParseBatch(id int, batchChan <-chan RawBatch, parsedLogChan chan<- Batch, wg *sync.WaitGroup) {
 	defer wg.Done()
+
+	rollupInterval := int64(p.config.RollupInterval.Seconds())
+
 	for batch := range batchChan {
-		p.logger.Debugf("routine %d beginning to parse a batch of %d logs", id, len(batch))
+		p.logger.Debugf("routine %d beginning to parse a batch of %d logs", id, len(batch.Logs))
 
-		parsedLogs := make([]Log, 0, len(batch))
+		parsedLogs := make([]Log, 0, len(batch.Logs))
 
-		for _, logEntry := range batch {
-			parsedLog, err := p.parseLog(logEntry)
+		var rollups Rollups
+		if rollupInterval > 0 {
+			rollups = make(Rollups)
+		}
+
+		for _, logEntry := range batch.Logs {
+			parsedLog, err := p.parseLog(logEntry.Line)
 			if err != nil {
 				p.logger.Println("error parsing log: ", err)
+				p.rejected.Add(1)
 				continue
 			}
+			parsedLog.SourceFile = logEntry.SourceFile
+			parsedLog.LineNumber = logEntry.LineNumber
+			parsedLog.ByteOffset = logEntry.ByteOffset
+			if p.config.Dedupe {
+				parsedLog.Hash = hashLog(p.config.Source, logEntry.Line)
+			}
+			if p.agents != nil {
+				p.enrichAgent(parsedLog)
+			}
+			if p.referers != nil {
+				p.enrichReferer(parsedLog)
+			}
+			if rollups != nil {
+				rollups.Add(parsedLog, rollupInterval)
+			}
 			parsedLogs = append(parsedLogs, *parsedLog)
 		}
 
+		p.parsed.Add(int64(len(parsedLogs)))
+
 		p.logger.Debugf("routine %d successfully parsed a batch", id)
 
-		parsedLogChan <- parsedLogs
+		parsedLogChan <- Batch{
+			Seq:     batch.Seq,
+			Logs:    parsedLogs,
+			Rollups: rollups,
+		}
 	}
 }
+
+// enrichAgent sets the attributes of the log's user agent.
+func (p *parser) enrichAgent(l *Log) {
+	a := p.agents.Parse(l.Agent)
+
+	l.Browser = a.Browser
+	l.BrowserVersion = a.BrowserVersion
+	l.OS = a.OS
+	l.DeviceType = a.DeviceType
+	l.IsBot = a.IsBot
+}
+
+// parseDuration returns the logged duration in microseconds, or -1 if it is not logged or invalid.
+func (p *parser) parseDuration(value string) int64 {
+	us, ok := parseDuration(value, p.config.DurationUnit)
+	if !ok {
+		if value != "" && value != "-" {
+			p.logger.Debugf("error parsing duration %q. proceeding without it", value)
+		}
+		return -1
+	}
+	return us
+}
+
+// enrichReferer sets the parts of the log's referer.
+func (p *parser) enrichReferer(l *Log) {
+	r := p.referers.Parse(l.Referer)
+
+	l.RefererHost = r.Host
+	l.RefererPath = r.Path
+	l.RefererInternal = r.Internal
+	l.SearchEngine = r.SearchEngine
+	l.SearchQuery = r.SearchQuery
+}
+
+// parseIP validates the IP of the log and normalizes it to its canonical text form, converting IPv4-mapped IPv6 addresses to IPv4. The binary form is the 16-byte IPv6 representation (IPv4 addresses being IPv4-mapped), so that IPs sort by their numeric value. Invalid IPs (e.g. hostnames) are kept as they are and flagged.
+func parseIP(l *Log) {
+	addr, err := netip.ParseAddr(l.IP)
+	if err != nil {
+		l.IPInvalid = true
+		return
+	}
+
+	addr = addr.Unmap()
+	binary := addr.As16()
+
+	l.IP = addr.String()
+	l.IPBinary = binary[:]
+}
+
+// Stats returns the counts of logs processed by the parsing routines so far.
+func (p *parser) Stats() Stats {
+	return Stats{
+		Parsed:   p.parsed.Load(),
+		Rejected: p.rejected.Load(),
+	}
+}
+
+// Regex returns the regex pattern used to parse logs.
+func (p *parser) Regex() string {
+	return p.regex.String()
+}
+
+// hashLog returns the content hash of a raw log coming from the provided source, which is used to skip duplicate logs on re-import.
+func hashLog(source string, l string) []byte {
+	h := sha256.New()
+	h.Write([]byte(source))
+	h.Write([]byte{0})
+	h.Write([]byte(l))
+	return h.Sum(nil)[:hashSize]
+}
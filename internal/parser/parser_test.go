@@ -2,11 +2,14 @@ package parser
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"regexp"
 	"strings"
 	"sync"
 	"testing"
+
+	"go.vxn.dev/xilt/internal/config"
 )
 
 type mockLogger struct {
@@ -35,13 +38,22 @@ const (
 	validCommonLog   = `127.0.0.1 user-identifier frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?param1=test HTTP/1.0" 200 2326`
 )
 
+// rawLogs wraps the provided lines into raw logs as if they were read from a log file.
+func rawLogs(lines ...string) []RawLog {
+	logs := make([]RawLog, 0, len(lines))
+	for i, line := range lines {
+		logs = append(logs, RawLog{Line: line, SourceFile: "access.log", LineNumber: int64(i + 1)})
+	}
+	return logs
+}
+
 func TestNewParserWithRegex(t *testing.T) {
 	compiledDefaultRegex, err := regexp.Compile(defaultRegex)
 	if err != nil {
 		t.Errorf("error compiling regex: %v", err)
 	}
-	expected := &parser{logger: &mockLogger{}, regex: compiledDefaultRegex}
-	actual, err := NewParser(&mockLogger{}, &defaultRegex)
+	expected := &parser{logger: &mockLogger{}, config: &config.Config{}, regex: compiledDefaultRegex}
+	actual, err := NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating new parser: %v", err)
 	}
@@ -57,9 +69,9 @@ func TestNewParserWithoutRegex(t *testing.T) {
 		t.Errorf("error compiling regex: %v", err)
 	}
 
-	expected := &parser{logger: &mockLogger{}, regex: compiledDefaultRegex}
+	expected := &parser{logger: &mockLogger{}, config: &config.Config{}, regex: compiledDefaultRegex}
 
-	actual, err := NewParser(&mockLogger{}, nil)
+	actual, err := NewParser(&mockLogger{}, &config.Config{}, nil)
 	if err != nil {
 		t.Errorf("error creating new parser: %v", err)
 	}
@@ -72,7 +84,7 @@ func TestNewParserWithoutRegex(t *testing.T) {
 func TestNewParserWithInvalidRegex(t *testing.T) {
 	invalidRegex := "(abc"
 
-	_, err := NewParser(&mockLogger{}, &invalidRegex)
+	_, err := NewParser(&mockLogger{}, &config.Config{}, &invalidRegex)
 	if err == nil {
 		t.Errorf("expected error, got nil")
 	}
@@ -81,7 +93,7 @@ func TestNewParserWithInvalidRegex(t *testing.T) {
 
 func TestParser_ParseLogValidCombined(t *testing.T) {
 
-	p, err := NewParser(&mockLogger{}, &defaultRegex)
+	p, err := NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating new parser: %v", err)
 	}
@@ -93,7 +105,7 @@ func TestParser_ParseLogValidCombined(t *testing.T) {
 
 func TestParser_ParseLogValidCommon(t *testing.T) {
 
-	p, err := NewParser(&mockLogger{}, &defaultRegex)
+	p, err := NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating new parser: %v", err)
 	}
@@ -106,7 +118,7 @@ func TestParser_ParseLogValidCommon(t *testing.T) {
 func TestParser_ParseLogNoParams(t *testing.T) {
 	log := `127.0.0.1 user-identifier frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`
 
-	p, err := NewParser(&mockLogger{}, &defaultRegex)
+	p, err := NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating new parser: %v", err)
 	}
@@ -121,7 +133,7 @@ func TestParser_ParseLogInvalidFormat(t *testing.T) {
 
 	m := &mockLogger{}
 
-	p, err := NewParser(m, &defaultRegex)
+	p, err := NewParser(m, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating new parser: %v", err)
 	}
@@ -133,7 +145,7 @@ func TestParser_ParseLogInvalidFormat(t *testing.T) {
 
 func TestParser_ParseLogInvalidResponseCode(t *testing.T) {
 
-	p, err := NewParser(&mockLogger{}, &defaultRegex)
+	p, err := NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating parser: %v", err)
 	}
@@ -148,7 +160,7 @@ func TestParser_ParseLogInvalidBytesSent(t *testing.T) {
 
 	l := &mockLogger{}
 
-	p, err := NewParser(l, &defaultRegex)
+	p, err := NewParser(l, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating parser: %v", err)
 	}
@@ -166,12 +178,205 @@ func TestParser_ParseLogInvalidBytesSent(t *testing.T) {
 	}
 }
 
+func TestParser_ParseLogIPs(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+		binary   []byte
+		invalid  bool
+	}{
+		{ip: "192.168.0.1", expected: "192.168.0.1", binary: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 192, 168, 0, 1}},
+		// IPv4-mapped IPv6 addresses are stored as IPv4
+		{ip: "::ffff:192.168.0.1", expected: "192.168.0.1", binary: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 192, 168, 0, 1}},
+		{ip: "2001:DB8:0:0::1", expected: "2001:db8::1", binary: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{ip: "host.example.com", expected: "host.example.com", invalid: true},
+		{ip: "-", expected: "-", invalid: true},
+	}
+
+	p, err := NewParser(&mockLogger{}, &config.Config{ParseIPs: true}, &defaultRegex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	for _, tt := range tests {
+		log := tt.ip + ` user-identifier frank [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326 "referrer" "agent"`
+
+		parsedLog, err := p.parseLog(log)
+		if err != nil {
+			t.Errorf("did not expect error, got %v", err)
+			continue
+		}
+
+		if parsedLog.IP != tt.expected || !reflect.DeepEqual(parsedLog.IPBinary, tt.binary) || parsedLog.IPInvalid != tt.invalid {
+			t.Errorf("%s: expected %s %v (invalid %t), got %s %v (invalid %t)", tt.ip, tt.expected, tt.binary, tt.invalid, parsedLog.IP, parsedLog.IPBinary, parsedLog.IPInvalid)
+		}
+	}
+
+	// IPs are stored as they are unless enabled
+	p, err = NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	parsedLog, err := p.parseLog(`::ffff:192.168.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326 "-" "-"`)
+	if err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+	if parsedLog.IP != "::ffff:192.168.0.1" || parsedLog.IPBinary != nil || parsedLog.IPInvalid {
+		t.Errorf("expected the IP not to be parsed, got %+v", parsedLog)
+	}
+}
+
+func TestParser_ParseLogClientIP(t *testing.T) {
+	regex := strings.TrimSuffix(defaultRegex, `\s*$`) + `\s"(?<forwarded>[^"]*)"\s*$`
+
+	p, err := NewParser(&mockLogger{}, &config.Config{ParseIPs: true, ForwardedHeader: "x-forwarded-for", TrustedProxies: "10.0.0.0/8"}, &regex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	tests := []struct {
+		log      string
+		ip       string
+		clientIP string
+	}{
+		{log: `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "curl/8.4.0" "1.1.1.1, 198.51.100.1, 10.0.0.2"`, ip: "10.0.0.1", clientIP: "198.51.100.1"},
+		{log: `203.0.113.7 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "curl/8.4.0" "198.51.100.1"`, ip: "203.0.113.7", clientIP: "203.0.113.7"},
+		{log: `::ffff:10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "curl/8.4.0" "-"`, ip: "10.0.0.1", clientIP: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		parsedLog, err := p.parseLog(tt.log)
+		if err != nil {
+			t.Errorf("did not expect error, got %v", err)
+			continue
+		}
+
+		// The peer IP is kept along with the client IP
+		if parsedLog.IP != tt.ip || parsedLog.ClientIP != tt.clientIP {
+			t.Errorf("expected peer IP %s and client IP %s, got %s and %s", tt.ip, tt.clientIP, parsedLog.IP, parsedLog.ClientIP)
+		}
+	}
+
+	// The regex must capture the forwarding header
+	if _, err := NewParser(&mockLogger{}, &config.Config{ForwardedHeader: "x-forwarded-for", TrustedProxies: "10.0.0.0/8"}, &defaultRegex); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestParser_ParseLogURLs(t *testing.T) {
+	p, err := NewParser(&mockLogger{}, &config.Config{DecodeURLs: true}, &defaultRegex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	tests := []struct {
+		uri           string
+		route         string
+		query         string
+		params        []Param
+		invalid       bool
+		doubleEncoded bool
+		nullByte      bool
+	}{
+		{uri: "/", route: "/", query: "-"},
+		{uri: "/search%20results/caf%C3%A9+bar", route: "/search results/café+bar", query: "-"},
+		{uri: "/?utm_source=news%20letter&q=a+b&debug&&empty=", route: "/", query: "utm_source=news letter&q=a b&debug&&empty=", params: []Param{{Key: "utm_source", Value: "news letter"}, {Key: "q", Value: "a b"}, {Key: "debug"}, {Key: "empty"}}},
+		{uri: "/static/%252e%252e%252fetc%252fpasswd", route: "/static/%2e%2e%2fetc%2fpasswd", query: "-", doubleEncoded: true},
+		{uri: "/download?file=report.pdf%00.txt", route: "/download", query: "file=report.pdf\x00.txt", params: []Param{{Key: "file", Value: "report.pdf\x00.txt"}}, nullByte: true},
+		{uri: "/100%", route: "/100%", query: "-", invalid: true},
+		// The query string is kept as it is if any of its parameters has an invalid encoding
+		{uri: "/?q=%zz&ok=1", route: "/", query: "q=%zz&ok=1", params: []Param{{Key: "q", Value: "%zz"}, {Key: "ok", Value: "1"}}, invalid: true},
+	}
+
+	for _, tt := range tests {
+		parsedLog, err := p.parseLog(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET ` + tt.uri + ` HTTP/1.1" 200 2326 "-" "-"`)
+		if err != nil {
+			t.Errorf("did not expect error, got %v", err)
+			continue
+		}
+
+		if parsedLog.RouteDecoded != tt.route || parsedLog.ParamsDecoded != tt.query || !reflect.DeepEqual(parsedLog.QueryParams, tt.params) {
+			t.Errorf("%s: expected route %q, query %q and params %v, got %q, %q and %v", tt.uri, tt.route, tt.query, tt.params, parsedLog.RouteDecoded, parsedLog.ParamsDecoded, parsedLog.QueryParams)
+		}
+
+		if parsedLog.URLInvalid != tt.invalid || parsedLog.URLDoubleEncoded != tt.doubleEncoded || parsedLog.URLNullByte != tt.nullByte {
+			t.Errorf("%s: expected flags %t %t %t, got %t %t %t", tt.uri, tt.invalid, tt.doubleEncoded, tt.nullByte, parsedLog.URLInvalid, parsedLog.URLDoubleEncoded, parsedLog.URLNullByte)
+		}
+	}
+
+	// The raw route and params are kept
+	parsedLog, err := p.parseLog(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a%20b?q=a+b HTTP/1.1" 200 2326 "-" "-"`)
+	if err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+	if parsedLog.Route != "/a%20b" || parsedLog.Params != "q=a+b" {
+		t.Errorf("expected the raw route and params to be kept, got %s and %s", parsedLog.Route, parsedLog.Params)
+	}
+}
+
+func TestParser_ParseLogRouteTemplate(t *testing.T) {
+	p, err := NewParser(&mockLogger{}, &config.Config{RouteTemplates: true}, &defaultRegex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	parsedLog, err := p.parseLog(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /users/12345/orders/987?page=2 HTTP/1.1" 200 2326 "-" "-"`)
+	if err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+
+	// The route is kept along with its template
+	if parsedLog.Route != "/users/12345/orders/987" || parsedLog.RouteTemplate != "/users/:id/orders/:id" {
+		t.Errorf("expected route /users/12345/orders/987 and template /users/:id/orders/:id, got %s and %s", parsedLog.Route, parsedLog.RouteTemplate)
+	}
+
+	// The custom rules must exist
+	if _, err := NewParser(&mockLogger{}, &config.Config{RouteTemplates: true, RouteRules: "missing.yaml"}, &defaultRegex); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestParser_ParseLogBytesSentOverflow(t *testing.T) {
+	tests := []struct {
+		bytesSent string
+		expected  uint64
+	}{
+		// Values over 4 GiB must not wrap around
+		{bytesSent: "4294967296", expected: 4294967296},
+		{bytesSent: "53687091200", expected: 53687091200},
+		{bytesSent: "9223372036854775807", expected: math.MaxInt64},
+		// Values which cannot be stored in SQLite fall back to the default value
+		{bytesSent: "9223372036854775808", expected: defaultBytesSent},
+		{bytesSent: "18446744073709551616", expected: defaultBytesSent},
+	}
+
+	p, err := NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
+	if err != nil {
+		t.Errorf("error creating parser: %v", err)
+	}
+
+	for _, tt := range tests {
+		log := `127.0.0.1 user-identifier frank [10/Oct/2000:13:55:36 -0700] "GET /video.mp4 HTTP/1.0" 200 ` + tt.bytesSent + ` "referrer" "agent"`
+
+		parsedLog, err := p.parseLog(log)
+		if err != nil {
+			t.Errorf("did not expect error, got %v", err)
+			continue
+		}
+
+		if parsedLog.BytesSent != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.bytesSent, tt.expected, parsedLog.BytesSent)
+		}
+	}
+}
+
 func TestParser_ParseLogInvalidTime(t *testing.T) {
 	log := `127.0.0.1 user-identifier frank [99/Abc/2000:13:55:36 -9999] "GET /apache_pb.gif HTTP/1.0" abc 2326`
 
 	l := &mockLogger{}
 
-	p, err := NewParser(l, &defaultRegex)
+	p, err := NewParser(l, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating parser: %v", err)
 	}
@@ -195,52 +400,63 @@ func TestParser_ParseBatchValid(t *testing.T) {
 
 	l := &mockLogger{}
 
-	p, err := NewParser(l, &defaultRegex)
+	p, err := NewParser(l, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating parser: %v", err)
 	}
 
-	batchChan := make(chan []string, 1)
-	parsedLogChan := make(chan []Log, 1)
+	batchChan := make(chan RawBatch, 1)
+	parsedLogChan := make(chan Batch, 1)
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go p.ParseBatch(1, batchChan, parsedLogChan, &wg)
 
-	batchChan <- logs
+	batchChan <- RawBatch{Seq: 7, Logs: rawLogs(logs...)}
 	close(batchChan)
 
 	wg.Wait()
 
-	parsedLogs := <-parsedLogChan
+	parsedBatch := <-parsedLogChan
+	parsedLogs := parsedBatch.Logs
 	close(parsedLogChan)
 
+	if parsedBatch.Seq != 7 {
+		t.Errorf("expected the sequence number of the raw batch 7, got %d", parsedBatch.Seq)
+	}
+
 	expected := []Log{{
-		IP:           "127.0.0.1",
-		Identity:     "user-identifier",
-		User:         "frank",
-		Time:         "10/Oct/2000:13:55:36 -0700",
-		TimestampUTC: "2000-10-10T20:55:36Z",
-		Method:       "GET",
-		Route:        "/apache_pb.gif",
-		Params:       "param1=test",
-		ResponseCode: 200,
-		BytesSent:    2326,
-		Referer:      "referrer",
-		Agent:        "agent",
+		IP:            "127.0.0.1",
+		Identity:      "user-identifier",
+		User:          "frank",
+		Time:          "10/Oct/2000:13:55:36 -0700",
+		TimestampUTC:  "2000-10-10T20:55:36Z",
+		TimestampUnix: 971211336,
+		Method:        "GET",
+		Route:         "/apache_pb.gif",
+		Params:        "param1=test",
+		ResponseCode:  200,
+		BytesSent:     2326,
+		Referer:       "referrer",
+		Agent:         "agent",
+		SourceFile:    "access.log",
+		LineNumber:    1,
 	}, {
-		IP:           "127.0.0.1",
-		Identity:     "user-identifier",
-		User:         "frank",
-		Time:         "10/Oct/2000:13:55:36 -0700",
-		TimestampUTC: "2000-10-10T20:55:36Z",
-		Method:       "GET",
-		Route:        "/apache_pb.gif",
-		Params:       "param1=test",
-		ResponseCode: 200,
-		BytesSent:    2326,
-		Referer:      "referrer",
-		Agent:        "agent",
+		IP:            "127.0.0.1",
+		Identity:      "user-identifier",
+		User:          "frank",
+		Time:          "10/Oct/2000:13:55:36 -0700",
+		TimestampUTC:  "2000-10-10T20:55:36Z",
+		TimestampUnix: 971211336,
+		Method:        "GET",
+		Route:         "/apache_pb.gif",
+		Params:        "param1=test",
+		ResponseCode:  200,
+		BytesSent:     2326,
+		Referer:       "referrer",
+		Agent:         "agent",
+		SourceFile:    "access.log",
+		LineNumber:    2,
 	}}
 
 	if !reflect.DeepEqual(&expected, &parsedLogs) {
@@ -253,19 +469,19 @@ func TestParser_ParseBatchInvalid(t *testing.T) {
 
 	l := &mockLogger{}
 
-	p, err := NewParser(l, &defaultRegex)
+	p, err := NewParser(l, &config.Config{}, &defaultRegex)
 	if err != nil {
 		t.Errorf("error creating parser: %v", err)
 	}
 
-	batchChan := make(chan []string, 1)
-	parsedLogChan := make(chan []Log, 1)
+	batchChan := make(chan RawBatch, 1)
+	parsedLogChan := make(chan Batch, 1)
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go p.ParseBatch(1, batchChan, parsedLogChan, &wg)
 
-	batchChan <- logs
+	batchChan <- RawBatch{Logs: rawLogs(logs...)}
 	close(batchChan)
 
 	wg.Wait()
@@ -283,4 +499,197 @@ func TestParser_ParseBatchInvalid(t *testing.T) {
 		t.Errorf("expected an error parsing response code to be logged. logs: %s", allLogs)
 	}
 
+	if stats := p.Stats(); stats.Parsed != 0 || stats.Rejected != 1 {
+		t.Errorf("expected 0 parsed and 1 rejected logs, got %+v", stats)
+	}
+}
+
+func TestParser_ParseLogTimestampUnix(t *testing.T) {
+	p, err := NewParser(&mockLogger{}, &config.Config{}, &defaultRegex)
+	if err != nil {
+		t.Errorf("error creating parser: %v", err)
+	}
+
+	parsedLog, err := p.parseLog(validCombinedLog)
+	if err != nil {
+		t.Errorf("did not expect error, got %v", err)
+	}
+
+	var expected int64 = 971211336
+
+	if parsedLog.TimestampUnix != expected {
+		t.Errorf("expected %d, got %d", expected, parsedLog.TimestampUnix)
+	}
+}
+
+func TestParser_ParseBatchDedupe(t *testing.T) {
+	logs := []string{validCombinedLog, validCombinedLog}
+
+	p, err := NewParser(&mockLogger{}, &config.Config{Dedupe: true, Source: "web1"}, &defaultRegex)
+	if err != nil {
+		t.Errorf("error creating parser: %v", err)
+	}
+
+	batchChan := make(chan RawBatch, 1)
+	parsedLogChan := make(chan Batch, 1)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go p.ParseBatch(1, batchChan, parsedLogChan, &wg)
+
+	batchChan <- RawBatch{Logs: rawLogs(logs...)}
+	close(batchChan)
+
+	wg.Wait()
+
+	parsedLogs := (<-parsedLogChan).Logs
+	close(parsedLogChan)
+
+	if len(parsedLogs[0].Hash) != hashSize {
+		t.Errorf("expected a hash of %d bytes, got %d bytes", hashSize, len(parsedLogs[0].Hash))
+	}
+
+	if !reflect.DeepEqual(parsedLogs[0].Hash, parsedLogs[1].Hash) {
+		t.Errorf("expected identical logs to have identical hashes, got %x and %x", parsedLogs[0].Hash, parsedLogs[1].Hash)
+	}
+
+	if reflect.DeepEqual(hashLog("web1", validCombinedLog), hashLog("web2", validCombinedLog)) {
+		t.Error("expected identical logs from different sources to have different hashes")
+	}
+}
+
+func TestParser_ParseBatchAgents(t *testing.T) {
+	logs := []string{
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"`,
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /robots.txt HTTP/1.1" 200 12 "-" "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)"`,
+	}
+
+	p, err := NewParser(&mockLogger{}, &config.Config{ParseAgents: true}, &defaultRegex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	batchChan := make(chan RawBatch, 1)
+	parsedLogChan := make(chan Batch, 1)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go p.ParseBatch(1, batchChan, parsedLogChan, &wg)
+
+	batchChan <- RawBatch{Logs: rawLogs(logs...)}
+	close(batchChan)
+
+	wg.Wait()
+
+	parsedLogs := (<-parsedLogChan).Logs
+	close(parsedLogChan)
+
+	expected := []Log{
+		{Browser: "Firefox", BrowserVersion: "121.0", OS: "Linux", DeviceType: "desktop"},
+		{Browser: "bingbot", BrowserVersion: "2.0", OS: "Other", DeviceType: "bot", IsBot: true},
+	}
+
+	for i, l := range parsedLogs {
+		if l.Browser != expected[i].Browser || l.BrowserVersion != expected[i].BrowserVersion || l.OS != expected[i].OS || l.DeviceType != expected[i].DeviceType || l.IsBot != expected[i].IsBot {
+			t.Errorf("expected %+v, got %+v", expected[i], l)
+		}
+	}
+}
+
+func TestParser_ParseBatchReferers(t *testing.T) {
+	logs := []string{
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "https://www.google.com/search?q=xilt+sqlite" "curl/8.4.0"`,
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /docs HTTP/1.1" 200 2326 "https://example.com/blog/post?id=1" "curl/8.4.0"`,
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /docs HTTP/1.1" 200 2326 "-" "curl/8.4.0"`,
+	}
+
+	p, err := NewParser(&mockLogger{}, &config.Config{ParseReferers: true, Sites: "example.com"}, &defaultRegex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	batchChan := make(chan RawBatch, 1)
+	parsedLogChan := make(chan Batch, 1)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go p.ParseBatch(1, batchChan, parsedLogChan, &wg)
+
+	batchChan <- RawBatch{Logs: rawLogs(logs...)}
+	close(batchChan)
+
+	wg.Wait()
+
+	parsedLogs := (<-parsedLogChan).Logs
+	close(parsedLogChan)
+
+	expected := []Log{
+		{RefererHost: "www.google.com", RefererPath: "/search", SearchEngine: "Google", SearchQuery: "xilt sqlite"},
+		{RefererHost: "example.com", RefererPath: "/blog/post", RefererInternal: true},
+		{},
+	}
+
+	for i, l := range parsedLogs {
+		if l.RefererHost != expected[i].RefererHost || l.RefererPath != expected[i].RefererPath || l.RefererInternal != expected[i].RefererInternal || l.SearchEngine != expected[i].SearchEngine || l.SearchQuery != expected[i].SearchQuery {
+			t.Errorf("expected %+v, got %+v", expected[i], l)
+		}
+	}
+}
+
+func TestParser_ParseLogDurations(t *testing.T) {
+	regex := strings.TrimSuffix(defaultRegex, `\s*$`) + `\s(?<request_time>\S+)\s"(?<upstream_time>[^"]*)"\s*$`
+
+	tests := []struct {
+		unit         string
+		requestTime  string
+		upstreamTime string
+		expected     int64
+		upstream     int64
+	}{
+		{unit: config.DurationSeconds, requestTime: "0.123", upstreamTime: "0.120", expected: 123000, upstream: 120000},
+		{unit: config.DurationSeconds, requestTime: "0.000", upstreamTime: "-", expected: 0, upstream: -1},
+		// Requests passed to several upstream servers log the time spent with each of them
+		{unit: config.DurationSeconds, requestTime: "1.5", upstreamTime: "0.002, 0.004 : 0.010", expected: 1500000, upstream: 16000},
+		{unit: config.DurationMilliseconds, requestTime: "42", upstreamTime: "", expected: 42000, upstream: -1},
+		{unit: config.DurationMicroseconds, requestTime: "2326", upstreamTime: "2000", expected: 2326, upstream: 2000},
+		{unit: config.DurationMicroseconds, requestTime: "-", upstreamTime: "abc", expected: -1, upstream: -1},
+		{unit: config.DurationMicroseconds, requestTime: "-5", upstreamTime: "1e400", expected: -1, upstream: -1},
+	}
+
+	for _, tt := range tests {
+		p, err := NewParser(&mockLogger{}, &config.Config{DurationUnit: tt.unit}, &regex)
+		if err != nil {
+			t.Fatalf("error creating parser: %v", err)
+		}
+
+		parsedLog, err := p.parseLog(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "curl/8.4.0" ` + tt.requestTime + ` "` + tt.upstreamTime + `"`)
+		if err != nil {
+			t.Errorf("did not expect error, got %v", err)
+			continue
+		}
+
+		if parsedLog.RequestTimeUs != tt.expected || parsedLog.UpstreamTimeUs != tt.upstream {
+			t.Errorf("%s %s: expected %d and %d, got %d and %d", tt.requestTime, tt.upstreamTime, tt.expected, tt.upstream, parsedLog.RequestTimeUs, parsedLog.UpstreamTimeUs)
+		}
+	}
+
+	// The upstream time is optional, unlike the request time
+	regex = strings.TrimSuffix(defaultRegex, `\s*$`) + `\s(?<request_time>\S+)\s*$`
+
+	p, err := NewParser(&mockLogger{}, &config.Config{DurationUnit: config.DurationMicroseconds}, &regex)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	parsedLog, err := p.parseLog(`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "curl/8.4.0" 512`)
+	if err != nil {
+		t.Fatalf("did not expect error, got %v", err)
+	}
+	if parsedLog.RequestTimeUs != 512 || parsedLog.UpstreamTimeUs != -1 {
+		t.Errorf("expected 512 and -1, got %d and %d", parsedLog.RequestTimeUs, parsedLog.UpstreamTimeUs)
+	}
+
+	if _, err := NewParser(&mockLogger{}, &config.Config{DurationUnit: config.DurationMicroseconds}, &defaultRegex); err == nil {
+		t.Error("expected error, got nil")
+	}
 }
@@ -0,0 +1,79 @@
+package parser
+
+// RollupDimension is the attribute of logs aggregated by a rollup table.
+type RollupDimension uint8
+
+const (
+	RollupRoute RollupDimension = iota
+	RollupIP
+	RollupAgent
+)
+
+// RollupDimensions lists all dimensions logs are aggregated by.
+var RollupDimensions = []RollupDimension{RollupRoute, RollupIP, RollupAgent}
+
+// RollupKey identifies a single row of a rollup table, i.e. the aggregates of one value of a dimension in one time bucket.
+type RollupKey struct {
+	Bucket    int64
+	Dimension RollupDimension
+	Value     string
+}
+
+// RollupCounts holds the aggregates of a single rollup row. StatusClasses holds the counts of 1xx to 5xx responses.
+type RollupCounts struct {
+	Requests      int64
+	Bytes         int64
+	StatusClasses [5]int64
+}
+
+// Rollups holds the aggregates of a batch of logs, which are merged into the rollup tables by the write routine.
+type Rollups map[RollupKey]*RollupCounts
+
+// Add adds the log to the aggregates of all dimensions in the time bucket of the provided interval (in seconds) the log belongs to.
+func (r Rollups) Add(l *Log, interval int64) {
+	r.update(l, interval, 1)
+}
+
+// Subtract removes a log previously added to the aggregates, e.g. after it is skipped as a duplicate.
+func (r Rollups) Subtract(l *Log, interval int64) {
+	r.update(l, interval, -1)
+}
+
+// update adds the log to the aggregates with the provided sign.
+func (r Rollups) update(l *Log, interval int64, sign int64) {
+	bucket := l.TimestampUnix - l.TimestampUnix%interval
+
+	for _, dimension := range RollupDimensions {
+		key := RollupKey{
+			Bucket:    bucket,
+			Dimension: dimension,
+			Value:     l.rollupValue(dimension),
+		}
+
+		counts, ok := r[key]
+		if !ok {
+			counts = &RollupCounts{}
+			r[key] = counts
+		}
+
+		counts.Requests += sign
+		counts.Bytes += sign * int64(l.BytesSent)
+
+		// Logs with a response code out of the valid range are only counted as requests
+		if class := l.ResponseCode / 100; class >= 1 && class <= 5 {
+			counts.StatusClasses[class-1] += sign
+		}
+	}
+}
+
+// rollupValue returns the value of the log in the provided dimension.
+func (l *Log) rollupValue(dimension RollupDimension) string {
+	switch dimension {
+	case RollupIP:
+		return l.IP
+	case RollupAgent:
+		return l.Agent
+	default:
+		return l.Route
+	}
+}
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestRollups_Add(t *testing.T) {
+	rollups := make(Rollups)
+
+	logs := []Log{
+		{TimestampUnix: 971211336, IP: "127.0.0.1", Route: "/a", Agent: "agent", ResponseCode: 200, BytesSent: 100},
+		{TimestampUnix: 971211400, IP: "127.0.0.1", Route: "/a", Agent: "agent", ResponseCode: 404, BytesSent: 50},
+		{TimestampUnix: 971215000, IP: "127.0.0.1", Route: "/a", Agent: "agent", ResponseCode: 0, BytesSent: 10},
+	}
+
+	for i := range logs {
+		rollups.Add(&logs[i], 3600)
+	}
+
+	// The first two logs belong to the same hourly bucket, the third one to the next
+	expected := RollupCounts{Requests: 2, Bytes: 150, StatusClasses: [5]int64{0, 1, 0, 1, 0}}
+
+	actual := rollups[RollupKey{Bucket: 971208000, Dimension: RollupRoute, Value: "/a"}]
+	if actual == nil || *actual != expected {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+
+	// Logs with an invalid response code are only counted as requests
+	expected = RollupCounts{Requests: 1, Bytes: 10}
+
+	actual = rollups[RollupKey{Bucket: 971211600, Dimension: RollupIP, Value: "127.0.0.1"}]
+	if actual == nil || *actual != expected {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+
+	if len(rollups) != 6 {
+		t.Errorf("expected 6 aggregates (3 dimensions in 2 buckets), got %d", len(rollups))
+	}
+}
+
+func TestRollups_Subtract(t *testing.T) {
+	rollups := make(Rollups)
+
+	l := Log{TimestampUnix: 971211336, IP: "127.0.0.1", Route: "/a", Agent: "agent", ResponseCode: 200, BytesSent: 100}
+
+	rollups.Add(&l, 60)
+	rollups.Add(&l, 60)
+	rollups.Subtract(&l, 60)
+
+	expected := RollupCounts{Requests: 1, Bytes: 100, StatusClasses: [5]int64{0, 1, 0, 0, 0}}
+
+	for _, dimension := range RollupDimensions {
+		actual := rollups[RollupKey{Bucket: 971211300, Dimension: dimension, Value: l.rollupValue(dimension)}]
+		if actual == nil || *actual != expected {
+			t.Errorf("expected %+v, got %+v", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// encodedSequence matches a percent-encoded byte, which is not expected to be left in a decoded value
+var encodedSequence = regexp.MustCompile(`%[0-9A-Fa-f]{2}`)
+
+// Param is a URL-decoded query parameter of a log. Parameters without a value (e.g. ?debug) have an empty one.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// decodeURL URL-decodes the route, the query string and the query parameters of the log, keeping the parameters in the order of the query string. Values which fail to be decoded are kept as they are and flagged as invalid. Values which still contain percent-encoded bytes once decoded are flagged as double encoded (e.g. %252e%252e%252f decoding to %2e%2e%2f), which is a common way of evading filters, and so are values containing null bytes (%00), which are used to truncate paths in the applications handling them.
+func decodeURL(l *Log) {
+	l.RouteDecoded = decodeValue(l, l.Route, url.PathUnescape)
+	l.ParamsDecoded = l.Params
+
+	if l.Params == defaultParams {
+		return
+	}
+
+	// The query string is decoded as a whole for reading, its parameters flag the log
+	if decoded, err := url.QueryUnescape(l.Params); err == nil {
+		l.ParamsDecoded = decoded
+	}
+
+	for _, pair := range strings.Split(l.Params, "&") {
+		if pair == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+
+		l.QueryParams = append(l.QueryParams, Param{
+			Key:   decodeValue(l, key, url.QueryUnescape),
+			Value: decodeValue(l, value, url.QueryUnescape),
+		})
+	}
+}
+
+// decodeValue decodes a single value of the log's URL using the provided unescape function (the routes and the query strings differ in the decoding of '+'), flagging the log if the encoding of the value is invalid or suspicious.
+func decodeValue(l *Log, s string, unescape func(string) (string, error)) string {
+	decoded, err := unescape(s)
+	if err != nil {
+		l.URLInvalid = true
+		return s
+	}
+
+	if strings.Contains(decoded, "\x00") {
+		l.URLNullByte = true
+	}
+
+	if encodedSequence.MatchString(decoded) {
+		l.URLDoubleEncoded = true
+	}
+
+	return decoded
+}
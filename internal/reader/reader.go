@@ -3,18 +3,32 @@ package reader
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 
 	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
 	"go.vxn.dev/xilt/pkg/logger"
 )
 
 type reader struct {
-	logger logger.Logger
-	cfg    *config.Config
+	logger  logger.Logger
+	cfg     *config.Config
+	summary Summary
+	window  chan<- struct{}
+}
+
+// Summary describes the log file read by the reader.
+type Summary struct {
+	Path   string
+	Size   int64
+	SHA256 string
+	Lines  int64
 }
 
 // NewReader returns a new instance of the Reader struct.
@@ -25,8 +39,13 @@ func NewReader(l logger.Logger, c *config.Config) *reader {
 	}
 }
 
-// ReadAndBatch reads from the file configured in the Reader struct's config and pushes raw logs into a batch channel for further processing.
-func (r *reader) ReadAndBatch(batchChannel chan<- []string) error {
+// SetWindow bounds the number of batches in flight. A slot of the window is taken before each batch is pushed into the batch channel and it has to be released by the consumer of the batch (i.e. the write routine) once the batch is processed.
+func (r *reader) SetWindow(window chan<- struct{}) {
+	r.window = window
+}
+
+// ReadAndBatch reads from the file configured in the Reader struct's config and pushes raw logs along with their positions in the file into a batch channel for further processing. The batches are numbered in the order they are read.
+func (r *reader) ReadAndBatch(batchChannel chan<- parser.RawBatch) error {
 	file, err := os.Open(r.cfg.InputFilePath)
 	if err != nil {
 		switch {
@@ -46,31 +65,92 @@ func (r *reader) ReadAndBatch(batchChannel chan<- []string) error {
 		r.logger.Debug("log file closed...")
 	}()
 
-	scanner := bufio.NewScanner(file)
+	// The file is hashed while being read so that it does not have to be read twice
+	hash := sha256.New()
+	counter := &countingWriter{}
+
+	scanner := bufio.NewScanner(io.TeeReader(file, io.MultiWriter(hash, counter)))
+
+	splitter := &lineSplitter{}
+	scanner.Split(splitter.split)
 
-	batch := make([]string, 0, r.cfg.BatchSize)
+	r.summary = Summary{
+		Path: r.cfg.InputFilePath,
+	}
+
+	batch := make([]parser.RawLog, 0, r.cfg.BatchSize)
+	var lineNumber, seq int64
+
+	push := func() {
+		if r.window != nil {
+			r.window <- struct{}{}
+		}
+		batchChannel <- parser.RawBatch{Seq: seq, Logs: batch}
+		seq++
+	}
 
 	r.logger.Println("beginning reading from log file and the parsing process...")
 
 	// Iterate over the lines in the log file and push them into the batch slice until the batch size or EOF is reached
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNumber++
 		if len(line) > 0 {
-			batch = append(batch, line)
+			r.summary.Lines++
+			batch = append(batch, parser.RawLog{
+				Line:       line,
+				SourceFile: r.cfg.InputFilePath,
+				LineNumber: lineNumber,
+				ByteOffset: splitter.offset,
+			})
 			if len(batch) == r.cfg.BatchSize {
-				batchChannel <- batch
-				batch = make([]string, 0, r.cfg.BatchSize)
+				push()
+				batch = make([]parser.RawLog, 0, r.cfg.BatchSize)
 			}
 		}
 	}
 
 	// Push the remaining logs if any
 	if len(batch) > 0 {
-		batchChannel <- batch
+		push()
 	}
 
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("error reading file '%s': %v", r.cfg.InputFilePath, err)
 	}
+
+	r.summary.Size = counter.n
+	r.summary.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
 	return nil
 }
+
+// Summary returns the summary of the log file read by the last call to ReadAndBatch.
+func (r *reader) Summary() Summary {
+	return r.summary
+}
+
+// lineSplitter splits the scanned input into lines like bufio.ScanLines, keeping track of the byte offset at which the last scanned line starts.
+type lineSplitter struct {
+	offset int64
+	next   int64
+}
+
+func (s *lineSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token != nil {
+		s.offset = s.next
+	}
+	s.next += int64(advance)
+	return advance, token, err
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -1,11 +1,16 @@
 package reader
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"go.vxn.dev/xilt/internal/config"
+	"go.vxn.dev/xilt/internal/parser"
 	"go.vxn.dev/xilt/pkg/logger"
 )
 
@@ -35,9 +40,9 @@ func TestReadAndBatch(t *testing.T) {
 	}
 	r := NewReader(logger, cfg)
 
-	batchChannel := make(chan []string)
+	batchChannel := make(chan parser.RawBatch)
 
-	go func(channel chan []string) {
+	go func(channel chan parser.RawBatch) {
 		for log := range channel {
 			t.Logf("Value read: %v", log)
 		}
@@ -49,6 +54,20 @@ func TestReadAndBatch(t *testing.T) {
 	}
 
 	close(batchChannel)
+
+	content := strings.Join(testData, "\n") + "\n"
+	hash := sha256.Sum256([]byte(content))
+
+	expected := Summary{
+		Path:   tmpFile.Name(),
+		Size:   int64(len(content)),
+		SHA256: hex.EncodeToString(hash[:]),
+		Lines:  int64(len(testData)),
+	}
+
+	if summary := r.Summary(); summary != expected {
+		t.Errorf("expected %+v, got %+v", expected, summary)
+	}
 }
 
 func TestReadAndBatch_InvalidFile(t *testing.T) {
@@ -60,9 +79,9 @@ func TestReadAndBatch_InvalidFile(t *testing.T) {
 	r := NewReader(logger, cfg)
 
 	// Create a channel to receive batches
-	batchChannel := make(chan []string)
+	batchChannel := make(chan parser.RawBatch)
 
-	go func(channel chan []string) {
+	go func(channel chan parser.RawBatch) {
 		for log := range channel {
 			t.Logf("Value read: %v", log)
 		}
@@ -95,7 +114,7 @@ func TestReadAndBatch_InsufficientFilePermissions(t *testing.T) {
 
 		r := NewReader(logger, cfg)
 
-		batchChannel := make(chan []string)
+		batchChannel := make(chan parser.RawBatch)
 
 		err = r.ReadAndBatch(batchChannel)
 		if err == nil {
@@ -103,3 +122,100 @@ func TestReadAndBatch_InsufficientFilePermissions(t *testing.T) {
 		}
 	}
 }
+
+func TestReadAndBatch_Provenance(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-logfile-*.log")
+	if err != nil {
+		t.Errorf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// Empty lines are skipped but still counted, and CRLF line endings are taken into account
+	if _, err := tmpFile.WriteString("first\r\n\nsecond\nthird"); err != nil {
+		t.Errorf("Failed to write to temp file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Errorf("failed to close temp file: %v", err)
+	}
+
+	cfg := &config.Config{
+		InputFilePath: tmpFile.Name(),
+		BatchSize:     10,
+	}
+	r := NewReader(logger.NewLogger(false), cfg)
+
+	batchChannel := make(chan parser.RawBatch, 1)
+
+	if err := r.ReadAndBatch(batchChannel); err != nil {
+		t.Errorf("error reading and batching: %v", err)
+	}
+	close(batchChannel)
+
+	expected := []parser.RawLog{
+		{Line: "first", SourceFile: tmpFile.Name(), LineNumber: 1, ByteOffset: 0},
+		{Line: "second", SourceFile: tmpFile.Name(), LineNumber: 3, ByteOffset: 8},
+		{Line: "third", SourceFile: tmpFile.Name(), LineNumber: 4, ByteOffset: 15},
+	}
+
+	actual := (<-batchChannel).Logs
+
+	if len(actual) != len(expected) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], actual[i])
+		}
+	}
+}
+
+func TestReadAndBatch_SequenceWindow(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-logfile-*.log")
+	if err != nil {
+		t.Errorf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString("first\nsecond\nthird\nfourth\nfifth\n"); err != nil {
+		t.Errorf("Failed to write to temp file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Errorf("failed to close temp file: %v", err)
+	}
+
+	cfg := &config.Config{
+		InputFilePath: tmpFile.Name(),
+		BatchSize:     2,
+	}
+	r := NewReader(logger.NewLogger(false), cfg)
+
+	window := make(chan struct{}, 1)
+	r.SetWindow(window)
+
+	batchChannel := make(chan parser.RawBatch)
+	done := make(chan error)
+
+	go func() {
+		done <- r.ReadAndBatch(batchChannel)
+	}()
+
+	// The reader must wait for the slot of each batch to be released before pushing the next one
+	for seq := int64(0); seq < 3; seq++ {
+		batch := <-batchChannel
+		if batch.Seq != seq {
+			t.Errorf("expected batch %d, got %d", seq, batch.Seq)
+		}
+
+		select {
+		case batch := <-batchChannel:
+			t.Errorf("expected the reader to wait for the window, got batch %d", batch.Seq)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		<-window
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("error reading and batching: %v", err)
+	}
+}
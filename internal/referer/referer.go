@@ -0,0 +1,146 @@
+// Package referer provides functionality for decomposing referers into their host and path, telling internal referrals (from the sites the logs belong to) from external ones, and extracting the search queries of referrals from well-known search engines.
+package referer
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"go.vxn.dev/xilt/internal/cache"
+)
+
+// Referer holds the parts of a parsed referer. The host is lowercased and stripped of the port. Referers which are not absolute URLs (e.g. "-") have no parts.
+type Referer struct {
+	Host         string
+	Path         string
+	Internal     bool
+	SearchEngine string
+	SearchQuery  string
+}
+
+// searchEngine is a well-known search engine identified by the label of its registrable domains (e.g. google in google.co.uk) and the subdomains serving the search (e.g. www) along with the query parameters holding the search query, in the order of preference.
+type searchEngine struct {
+	name       string
+	label      string
+	subdomains []string
+	params     []string
+}
+
+// searchEngines lists the well-known search engines. Most of them no longer pass the search query in the referer, in which case only the engine is known.
+var searchEngines = []searchEngine{
+	{name: "Google", label: "google", subdomains: []string{"www"}, params: []string{"q"}},
+	{name: "Bing", label: "bing", subdomains: []string{"www", "cn"}, params: []string{"q"}},
+	{name: "Yahoo", label: "yahoo", subdomains: []string{"search"}, params: []string{"p"}},
+	{name: "DuckDuckGo", label: "duckduckgo", subdomains: []string{"html", "lite"}, params: []string{"q"}},
+	{name: "Yandex", label: "yandex", subdomains: []string{"www"}, params: []string{"text"}},
+	{name: "Baidu", label: "baidu", subdomains: []string{"www", "m"}, params: []string{"wd", "word"}},
+	{name: "Ecosia", label: "ecosia", subdomains: []string{"www"}, params: []string{"q"}},
+	{name: "Seznam", label: "seznam", subdomains: []string{"search"}, params: []string{"q"}},
+	{name: "Naver", label: "naver", subdomains: []string{"search"}, params: []string{"query"}},
+	{name: "Startpage", label: "startpage", subdomains: []string{"www"}, params: []string{"query", "q"}},
+	{name: "Qwant", label: "qwant", subdomains: []string{"www"}, params: []string{"q"}},
+}
+
+// Parser parses referers, caching the results.
+type Parser struct {
+	sites []string
+
+	cache *cache.Cache[string, Referer]
+}
+
+// NewParser returns a new Parser treating the referers from the hosts in the provided comma-separated list of sites as internal. The sites may be given as hosts (example.com) or URLs (https://example.com), a host matching only itself, not its subdomains.
+func NewParser(sites string) *Parser {
+	p := &Parser{
+		sites: make([]string, 0),
+		cache: cache.New[string, Referer](cache.DefaultSize),
+	}
+
+	for _, site := range strings.Split(sites, ",") {
+		site = strings.ToLower(strings.TrimSpace(site))
+		if site == "" {
+			continue
+		}
+
+		if u, err := url.Parse(site); err == nil && u.Host != "" {
+			site = u.Hostname()
+		}
+
+		p.sites = append(p.sites, site)
+	}
+
+	return p
+}
+
+// Parse returns the parts of the provided referer. The results are cached per referer. It is safe for concurrent use.
+func (p *Parser) Parse(referer string) Referer {
+	return p.cache.GetOrCompute(referer, p.parse)
+}
+
+// parse decomposes the provided referer. Search queries are only extracted from external referrals.
+func (p *Parser) parse(referer string) Referer {
+	var r Referer
+
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return r
+	}
+
+	r.Host = strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	r.Path = u.EscapedPath()
+	r.Internal = slices.Contains(p.sites, r.Host)
+
+	if r.Internal {
+		return r
+	}
+
+	if engine, ok := matchSearchEngine(r.Host); ok {
+		r.SearchEngine = engine.name
+
+		query := u.Query()
+		for _, param := range engine.params {
+			if q := strings.TrimSpace(query.Get(param)); q != "" {
+				r.SearchQuery = q
+				break
+			}
+		}
+	}
+
+	return r
+}
+
+// matchSearchEngine returns the search engine of the provided host. The host matches an engine if it is a registrable domain of the engine (its label followed by a public suffix, e.g. google.co.uk) or one of its subdomains serving the search, including their regional variants (e.g. www.google.co.uk or uk.search.yahoo.com). Other subdomains (e.g. mail.google.com) and domains merely containing the label (e.g. google.example.com) do not match.
+func matchSearchEngine(host string) (searchEngine, bool) {
+	labels := strings.Split(host, ".")
+
+	for _, engine := range searchEngines {
+		i := slices.Index(labels, engine.label)
+		if i < 0 || !publicSuffix(labels[i+1:]) {
+			continue
+		}
+
+		subdomain := strings.Join(labels[:i], ".")
+		if subdomain == "" {
+			return engine, true
+		}
+
+		for _, s := range engine.subdomains {
+			if subdomain == s || strings.HasSuffix(subdomain, "."+s) {
+				return engine, true
+			}
+		}
+	}
+
+	return searchEngine{}, false
+}
+
+// publicSuffix reports whether the provided labels of a domain look like a public suffix under which the search engines register their domains: a top-level domain (com) or a generic second-level domain of a country code top-level domain (co.uk or com.au). The full Public Suffix List is not needed for the few engines.
+func publicSuffix(labels []string) bool {
+	switch len(labels) {
+	case 1:
+		return labels[0] != ""
+	case 2:
+		return (labels[0] == "co" || labels[0] == "com") && len(labels[1]) == 2
+	}
+
+	return false
+}
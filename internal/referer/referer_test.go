@@ -0,0 +1,48 @@
+package referer
+
+import "testing"
+
+func TestParser_Parse(t *testing.T) {
+	p := NewParser("example.com, https://WWW.example.com:8443/, ")
+
+	tests := []struct {
+		referer  string
+		expected Referer
+	}{
+		{referer: "-"},
+		{referer: ""},
+		{referer: "/relative/path"},
+		{referer: "not a url %zz"},
+		{referer: "https://example.com/blog/post?id=1", expected: Referer{Host: "example.com", Path: "/blog/post", Internal: true}},
+		{referer: "http://WWW.Example.com:8080/a%20b", expected: Referer{Host: "www.example.com", Path: "/a%20b", Internal: true}},
+		// Subdomains of the sites are external
+		{referer: "https://shop.example.com/", expected: Referer{Host: "shop.example.com", Path: "/"}},
+		{referer: "https://news.ycombinator.com/item?id=1", expected: Referer{Host: "news.ycombinator.com", Path: "/item"}},
+		{referer: "https://www.google.co.uk/search?q=access+log+sqlite&hl=en", expected: Referer{Host: "www.google.co.uk", Path: "/search", SearchEngine: "Google", SearchQuery: "access log sqlite"}},
+		{referer: "https://www.google.com/", expected: Referer{Host: "www.google.com", Path: "/", SearchEngine: "Google"}},
+		{referer: "https://search.yahoo.com/search?p=xilt", expected: Referer{Host: "search.yahoo.com", Path: "/search", SearchEngine: "Yahoo", SearchQuery: "xilt"}},
+		{referer: "https://www.baidu.com/s?word=%E6%97%A5%E5%BF%97", expected: Referer{Host: "www.baidu.com", Path: "/s", SearchEngine: "Baidu", SearchQuery: "日志"}},
+		{referer: "https://yandex.ru/search/?text=logs", expected: Referer{Host: "yandex.ru", Path: "/search/", SearchEngine: "Yandex", SearchQuery: "logs"}},
+		{referer: "https://duckduckgo.com/?q=%20", expected: Referer{Host: "duckduckgo.com", Path: "/", SearchEngine: "DuckDuckGo"}},
+		{referer: "https://www.google.com.au/search?q=x", expected: Referer{Host: "www.google.com.au", Path: "/search", SearchEngine: "Google", SearchQuery: "x"}},
+		{referer: "https://uk.search.yahoo.com/search?p=x", expected: Referer{Host: "uk.search.yahoo.com", Path: "/search", SearchEngine: "Yahoo", SearchQuery: "x"}},
+		// Only the registrable domains of the engines and their search subdomains identify the engines
+		{referer: "https://example.google/?q=x", expected: Referer{Host: "example.google", Path: "/"}},
+		{referer: "https://google.evil.com/?q=x", expected: Referer{Host: "google.evil.com", Path: "/"}},
+		{referer: "https://www.google.evil.com/?q=x", expected: Referer{Host: "www.google.evil.com", Path: "/"}},
+		{referer: "https://google.com.evil.com/?q=x", expected: Referer{Host: "google.com.evil.com", Path: "/"}},
+		{referer: "https://docs.google.com/document/d/1", expected: Referer{Host: "docs.google.com", Path: "/document/d/1"}},
+		{referer: "https://mail.google.com/mail/u/0/", expected: Referer{Host: "mail.google.com", Path: "/mail/u/0/"}},
+		{referer: "https://www.yahoo.com/?p=x", expected: Referer{Host: "www.yahoo.com", Path: "/"}},
+		{referer: "https://notgoogle.com/?q=x", expected: Referer{Host: "notgoogle.com", Path: "/"}},
+	}
+
+	for _, tt := range tests {
+		// The second pass is served from the cache
+		for range 2 {
+			if actual := p.Parse(tt.referer); actual != tt.expected {
+				t.Errorf("%q: expected %+v, got %+v", tt.referer, tt.expected, actual)
+			}
+		}
+	}
+}
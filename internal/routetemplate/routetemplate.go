@@ -0,0 +1,143 @@
+// Package routetemplate provides functionality for collapsing the variable parts of routes (e.g. numeric IDs, UUIDs and hashes) into placeholders, so that routes differing only in the identifiers of the resources they request share a single template, e.g. /users/:id/orders/:id. Custom regex rules can be added without rebuilding the app.
+package routetemplate
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// PlaceholderID replaces the segments made of digits only
+	PlaceholderID = ":id"
+	// PlaceholderUUID replaces the segments holding a UUID in the 8-4-4-4-12 form
+	PlaceholderUUID = ":uuid"
+	// PlaceholderHash replaces the segments holding hexadecimal hashes or tokens of at least minHashLength digits
+	PlaceholderHash = ":hash"
+
+	// minHashLength is the minimum length of a hexadecimal segment to be considered a hash, so that short words made of the letters a-f (e.g. /cafe or /feed) are kept
+	minHashLength = 16
+)
+
+// rule is a custom regex matched against the whole route along with its replacement, which may reference the matched groups ($1 or ${name}).
+type rule struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+
+	regex *regexp.Regexp
+}
+
+// rules is a custom rule set stored in a YAML file.
+type rules struct {
+	Rules []*rule `yaml:"route_rules"`
+}
+
+// Templater rewrites routes into templates. It is safe for concurrent use.
+type Templater struct {
+	rules []*rule
+}
+
+// NewTemplater returns a new Templater applying the custom rules stored in the YAML file at the provided path before the built-in ones. Only the built-in rules are applied if the path is empty.
+func NewTemplater(path string) (*Templater, error) {
+	if path == "" {
+		return &Templater{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading route rules: %w", err)
+	}
+
+	var r rules
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error decoding route rules: %w", err)
+	}
+
+	for _, ru := range r.Rules {
+		if ru.regex, err = regexp.Compile(ru.Regex); err != nil {
+			return nil, fmt.Errorf("error compiling route rule %q: %w", ru.Regex, err)
+		}
+	}
+
+	return &Templater{rules: r.Rules}, nil
+}
+
+// Template returns the template of the provided route. The custom rules are applied to the whole route in their order, then each segment of the route matching a built-in rule is replaced by its placeholder.
+func (t *Templater) Template(route string) string {
+	for _, ru := range t.rules {
+		route = ru.regex.ReplaceAllString(route, ru.Replacement)
+	}
+
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		segments[i] = placeholder(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// placeholder returns the placeholder replacing the segment if it matches any of the built-in rules, or the segment itself otherwise.
+func placeholder(segment string) string {
+	switch {
+	case segment == "":
+		return segment
+	case isDigits(segment):
+		return PlaceholderID
+	case isUUID(segment):
+		return PlaceholderUUID
+	case len(segment) >= minHashLength && isHex(segment):
+		return PlaceholderHash
+	default:
+		return segment
+	}
+}
+
+// isDigits reports whether the string is made of decimal digits only.
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isHex reports whether the string is made of hexadecimal digits only.
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHexDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isUUID reports whether the string is a UUID in the 8-4-4-4-12 form of hexadecimal digits, regardless of its version.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHexDigit(s[i]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isHexDigit reports whether the byte is a hexadecimal digit of either case.
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,83 @@
+package routetemplate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplater_Template(t *testing.T) {
+	templater, err := NewTemplater("")
+	if err != nil {
+		t.Fatalf("error creating templater: %v", err)
+	}
+
+	tests := []struct {
+		route    string
+		expected string
+	}{
+		{route: "/users/12345/orders/987", expected: "/users/:id/orders/:id"},
+		{route: "/users/67890/orders/123", expected: "/users/:id/orders/:id"},
+		{route: "/files/3F2504E0-4F89-11D3-9A0C-0305E82C3301/", expected: "/files/:uuid/"},
+		{route: "/commits/9fceb02d0ae598e95dc970b74767f19372d61af8", expected: "/commits/:hash"},
+		{route: "/static/app.5d41402abc4b2a76.js", expected: "/static/app.5d41402abc4b2a76.js"},
+		{route: "/cafe/feed/deadbeef", expected: "/cafe/feed/deadbeef"},
+		{route: "/v2/items", expected: "/v2/items"},
+		{route: "/", expected: "/"},
+		{route: "*", expected: "*"},
+	}
+
+	for _, tt := range tests {
+		if actual := templater.Template(tt.route); actual != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.route, tt.expected, actual)
+		}
+	}
+}
+
+func TestNewTemplater_Rules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+
+	rules := `
+route_rules:
+  - regex: '^/blog/[^/]+'
+    replacement: '/blog/:slug'
+  - regex: '^/(en|de|fr)/'
+    replacement: '/:lang/'
+`
+
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("error writing rules: %v", err)
+	}
+
+	templater, err := NewTemplater(path)
+	if err != nil {
+		t.Fatalf("error creating templater: %v", err)
+	}
+
+	tests := []struct {
+		route    string
+		expected string
+	}{
+		{route: "/blog/hello-world/comments/42", expected: "/blog/:slug/comments/:id"},
+		{route: "/de/products/7", expected: "/:lang/products/:id"},
+		{route: "/products/7", expected: "/products/:id"},
+	}
+
+	for _, tt := range tests {
+		if actual := templater.Template(tt.route); actual != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.route, tt.expected, actual)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("route_rules:\n  - regex: '('\n"), 0o644); err != nil {
+		t.Fatalf("error writing rules: %v", err)
+	}
+
+	if _, err := NewTemplater(path); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if _, err := NewTemplater(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
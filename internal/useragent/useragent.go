@@ -0,0 +1,207 @@
+// Package useragent provides functionality for parsing user agent strings into the browser, OS and device type of the client, using a rule set in the format of uap-core's regexes.yaml (https://github.com/ua-parser/uap-core). A default rule set is embedded, which can be replaced by a custom one (including the upstream one) without rebuilding the app.
+package useragent
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.vxn.dev/xilt/internal/cache"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Other is the browser or OS of agents not matched by any rule
+	Other = "Other"
+	// DeviceOther is the device type of agents not matched by any rule
+	DeviceOther = "other"
+	// DeviceBot is the device type of bots and crawlers
+	DeviceBot = "bot"
+	// deviceSpider is the device family of bots and crawlers in the upstream rule set of uap-core, which is reported as DeviceBot
+	deviceSpider = "Spider"
+)
+
+//go:embed regexes.yaml
+var defaultRules []byte
+
+// Agent holds the attributes of a parsed user agent.
+type Agent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	DeviceType     string
+	IsBot          bool
+}
+
+// rule is a single regex of the rule set along with the replacements of its matched groups. The replacement of the device parsers is either a device type (desktop, mobile, tablet or bot) or, in the upstream rule set, a device family.
+type rule struct {
+	Regex      string `yaml:"regex"`
+	RegexFlag  string `yaml:"regex_flag"`
+	Family     string `yaml:"family_replacement"`
+	Major      string `yaml:"v1_replacement"`
+	Minor      string `yaml:"v2_replacement"`
+	OS         string `yaml:"os_replacement"`
+	DeviceType string `yaml:"device_replacement"`
+
+	regex *regexp.Regexp
+}
+
+// rules is a rule set in the format of uap-core's regexes.yaml.
+type rules struct {
+	UserAgentParsers []*rule `yaml:"user_agent_parsers"`
+	OSParsers        []*rule `yaml:"os_parsers"`
+	DeviceParsers    []*rule `yaml:"device_parsers"`
+}
+
+// Parser parses user agents according to a rule set, caching the results.
+type Parser struct {
+	rules *rules
+	// skipped lists the regexes of the rule set which cannot be compiled
+	skipped []string
+
+	cache *cache.Cache[string, Agent]
+}
+
+// NewParser returns a new Parser using the rule set stored in the YAML file at the provided path. The embedded default rule set is used if the path is empty. Rules whose regexes cannot be compiled (such as the ones of the upstream rule set using PCRE features unsupported by Go) are skipped and reported by Skipped, an error is only returned if none of them can be compiled.
+func NewParser(path string) (*Parser, error) {
+	data := defaultRules
+
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("error reading user agent rules: %w", err)
+		}
+	}
+
+	var r rules
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error decoding user agent rules: %w", err)
+	}
+
+	p := &Parser{
+		rules: &r,
+		cache: cache.New[string, Agent](cache.DefaultSize),
+	}
+
+	r.UserAgentParsers = p.compile(r.UserAgentParsers)
+	r.OSParsers = p.compile(r.OSParsers)
+	r.DeviceParsers = p.compile(r.DeviceParsers)
+
+	if len(p.skipped) > 0 && len(r.UserAgentParsers)+len(r.OSParsers)+len(r.DeviceParsers) == 0 {
+		return nil, fmt.Errorf("none of the user agent rules can be compiled, e.g. %q", p.skipped[0])
+	}
+
+	return p, nil
+}
+
+// compile compiles the regexes of the provided section and returns its rules without the ones which cannot be compiled, recording their regexes as skipped.
+func (p *Parser) compile(section []*rule) []*rule {
+	compiled := section[:0]
+
+	for _, ru := range section {
+		pattern := ru.Regex
+		if ru.RegexFlag == "i" {
+			pattern = "(?i)" + pattern
+		}
+
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			p.skipped = append(p.skipped, ru.Regex)
+			continue
+		}
+
+		ru.regex = regex
+		compiled = append(compiled, ru)
+	}
+
+	return compiled
+}
+
+// Skipped returns the regexes of the rule set which were skipped as they cannot be compiled.
+func (p *Parser) Skipped() []string {
+	return p.skipped
+}
+
+// Parse returns the attributes of the provided user agent. The results are cached per agent. It is safe for concurrent use.
+func (p *Parser) Parse(agent string) Agent {
+	return p.cache.GetOrCompute(agent, p.parse)
+}
+
+// parse matches the provided user agent against the rule set.
+func (p *Parser) parse(agent string) Agent {
+	a := Agent{
+		Browser:    Other,
+		OS:         Other,
+		DeviceType: DeviceOther,
+	}
+
+	if ru, matches := match(p.rules.UserAgentParsers, agent); ru != nil {
+		a.Browser = replace(ru.Family, matches, 1)
+		a.BrowserVersion = version(ru, matches)
+	}
+
+	if ru, matches := match(p.rules.OSParsers, agent); ru != nil {
+		a.OS = replace(ru.OS, matches, 1)
+	}
+
+	if ru, matches := match(p.rules.DeviceParsers, agent); ru != nil {
+		a.DeviceType = replace(ru.DeviceType, matches, 1)
+	}
+
+	if a.DeviceType == deviceSpider {
+		a.DeviceType = DeviceBot
+	}
+
+	a.IsBot = a.DeviceType == DeviceBot
+
+	return a
+}
+
+// match returns the first rule matching the user agent along with the matched groups.
+func match(section []*rule, agent string) (*rule, []string) {
+	for _, ru := range section {
+		if matches := ru.regex.FindStringSubmatch(agent); matches != nil {
+			return ru, matches
+		}
+	}
+
+	return nil, nil
+}
+
+// replace returns the replacement with the references to the matched groups ($1 to $9) substituted. If the replacement is empty, the group with the provided index is returned instead.
+func replace(replacement string, matches []string, group int) string {
+	if replacement == "" {
+		if group < len(matches) {
+			return matches[group]
+		}
+		return ""
+	}
+
+	if !strings.Contains(replacement, "$") {
+		return replacement
+	}
+
+	for i := len(matches) - 1; i >= 1; i-- {
+		replacement = strings.ReplaceAll(replacement, "$"+strconv.Itoa(i), matches[i])
+	}
+
+	// Unmatched optional groups leave trailing separators behind
+	return strings.Trim(replacement, ". ")
+}
+
+// version returns the major and minor browser version joined by a dot. They are the replacements of the rule, or the second and third matched groups following the browser family.
+func version(ru *rule, matches []string) string {
+	major := replace(ru.Major, matches, 2)
+	if major == "" {
+		return ""
+	}
+
+	if minor := replace(ru.Minor, matches, 3); minor != "" {
+		return major + "." + minor
+	}
+
+	return major
+}
@@ -0,0 +1,188 @@
+package useragent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParser_Parse(t *testing.T) {
+	p, err := NewParser("")
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	tests := []struct {
+		agent    string
+		expected Agent
+	}{
+		{
+			agent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			expected: Agent{Browser: "Chrome", BrowserVersion: "120.0", OS: "Windows", DeviceType: "desktop"},
+		},
+		{
+			agent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			expected: Agent{Browser: "Edge", BrowserVersion: "120.0", OS: "Windows", DeviceType: "desktop"},
+		},
+		{
+			agent:    "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			expected: Agent{Browser: "Firefox", BrowserVersion: "121.0", OS: "Linux", DeviceType: "desktop"},
+		},
+		{
+			agent:    "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			expected: Agent{Browser: "Safari", BrowserVersion: "17.1", OS: "iOS", DeviceType: "mobile"},
+		},
+		{
+			agent:    "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			expected: Agent{Browser: "Safari", BrowserVersion: "16.6", OS: "iOS", DeviceType: "tablet"},
+		},
+		{
+			agent:    "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			expected: Agent{Browser: "Samsung Internet", BrowserVersion: "23.0", OS: "Android", DeviceType: "mobile"},
+		},
+		{
+			agent:    "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			expected: Agent{Browser: "Safari", BrowserVersion: "17.2", OS: "Mac OS X", DeviceType: "desktop"},
+		},
+		{
+			agent:    "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			expected: Agent{Browser: "Googlebot", BrowserVersion: "2.1", OS: "Other", DeviceType: "bot", IsBot: true},
+		},
+		{
+			agent:    "Mozilla/5.0 (compatible; ExampleCrawler/3; +https://example.com)",
+			expected: Agent{Browser: "ExampleCrawler", BrowserVersion: "3", OS: "Other", DeviceType: "bot", IsBot: true},
+		},
+		{
+			agent:    "curl/8.4.0",
+			expected: Agent{Browser: "curl", BrowserVersion: "8.4", OS: "Other", DeviceType: "bot", IsBot: true},
+		},
+		{
+			agent:    "-",
+			expected: Agent{Browser: "Other", OS: "Other", DeviceType: "other"},
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := p.Parse(tt.agent); actual != tt.expected {
+			t.Errorf("%s: expected %+v, got %+v", tt.agent, tt.expected, actual)
+		}
+	}
+
+	// Parsed agents are cached
+	if p.cache.Len() != len(tests) {
+		t.Errorf("expected %d cached agents, got %d", len(tests), p.cache.Len())
+	}
+}
+
+func TestNewParser_CustomRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regexes.yaml")
+
+	rules := `
+user_agent_parsers:
+  - regex: '(InternalApp)/(\d+)\.(\d+)'
+    family_replacement: 'Internal $1'
+os_parsers:
+  - regex: 'Kiosk'
+    os_replacement: 'KioskOS'
+device_parsers:
+  - regex: 'kiosk'
+    regex_flag: 'i'
+    device_replacement: 'kiosk'
+`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("error writing rules: %v", err)
+	}
+
+	p, err := NewParser(path)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	expected := Agent{Browser: "Internal InternalApp", BrowserVersion: "4.2", OS: "KioskOS", DeviceType: "kiosk"}
+
+	if actual := p.Parse("InternalApp/4.2 (Kiosk)"); actual != expected {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+
+	// The embedded rules are replaced by the custom ones
+	if actual := p.Parse("curl/8.4.0"); actual.Browser != Other || actual.IsBot {
+		t.Errorf("expected the embedded rules not to be used, got %+v", actual)
+	}
+}
+
+func TestNewParser_UpstreamRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regexes.yaml")
+
+	// An excerpt in the format of uap-core's regexes.yaml, including a lookahead unsupported by Go
+	rules := `
+user_agent_parsers:
+  - regex: '(Edg)/(\d+)(?:\.(\d+)|)(?:\.(\d+)|)'
+    family_replacement: 'Edge'
+  - regex: '(?!Edg)(Chrome)/(\d+)\.(\d+)'
+  - regex: '(FeedReader)/(\d+)'
+    v1_replacement: '$2'
+    v2_replacement: '9'
+os_parsers:
+  - regex: '(Windows) NT'
+device_parsers:
+  - regex: '(?:(?:bot|spider)[/ ]|crawler)'
+    regex_flag: 'i'
+    device_replacement: 'Spider'
+  - regex: '(iPhone)'
+    device_replacement: '$1'
+`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatalf("error writing rules: %v", err)
+	}
+
+	p, err := NewParser(path)
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	if skipped := p.Skipped(); len(skipped) != 1 || skipped[0] != `(?!Edg)(Chrome)/(\d+)\.(\d+)` {
+		t.Errorf("expected the lookahead rule to be skipped, got %v", skipped)
+	}
+
+	tests := []struct {
+		agent    string
+		expected Agent
+	}{
+		{
+			agent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			expected: Agent{Browser: "Edge", BrowserVersion: "120.0", OS: "Windows", DeviceType: "other"},
+		},
+		{
+			agent:    "FeedReader/3 (compatible; ExampleBot/1.0)",
+			expected: Agent{Browser: "FeedReader", BrowserVersion: "3.9", OS: "Other", DeviceType: "bot", IsBot: true},
+		},
+		{
+			// The device families other than Spider are kept
+			agent:    "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X)",
+			expected: Agent{Browser: "Other", OS: "Other", DeviceType: "iPhone"},
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := p.Parse(tt.agent); actual != tt.expected {
+			t.Errorf("%s: expected %+v, got %+v", tt.agent, tt.expected, actual)
+		}
+	}
+}
+
+func TestNewParser_InvalidRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regexes.yaml")
+
+	// Rules which cannot be compiled are skipped, but not all of them
+	if err := os.WriteFile(path, []byte("user_agent_parsers:\n  - regex: '(unclosed'\n"), 0o644); err != nil {
+		t.Fatalf("error writing rules: %v", err)
+	}
+
+	if _, err := NewParser(path); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if _, err := NewParser(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error, got nil")
+	}
+}